@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"api/utils"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Content hidden from a profile (kids libraries, maximum rating) is treated
+// as missing: catalogues leave it out and direct accesses answer 404, so a
+// restricted profile can't even tell it exists.
+
+// visibleLibraries returns the keys of the libraries the user may browse,
+// nil when every library is visible
+func visibleLibraries(c *gin.Context) []string {
+	user, ok := currentUser(c)
+	if !ok || user.Role != utils.ROLE_KID {
+		return nil
+	}
+	keys := []string{}
+	for _, lib := range utils.Libraries() {
+		if user.CanSeeLibrary(lib) {
+			keys = append(keys, lib.Key)
+		}
+	}
+	return keys
+}
+
+// canSeeLibrary tells whether the user may browse a library
+func canSeeLibrary(c *gin.Context, key string) bool {
+	visible := visibleLibraries(c)
+	return visible == nil || slices.Contains(visible, key)
+}
+
+// restrictCatalogue completes a movies or series filter: the requested
+// library (empty for all) among the visible ones, within the user's rating
+func restrictCatalogue(c *gin.Context, filter bson.M, requested string) {
+	visible := visibleLibraries(c)
+	switch {
+	case visible != nil && requested == "":
+		filter["library"] = bson.M{"$in": visible}
+	case visible != nil && !slices.Contains(visible, requested):
+		filter["library"] = bson.M{"$in": []string{}}
+	case requested != "":
+		filter["library"] = requested
+	}
+	if user, ok := currentUser(c); ok {
+		if maxAge, limited := user.MaxAge(); limited {
+			filter["$and"] = bson.A{utils.RatingFilter(maxAge)}
+		}
+	}
+}
+
+// canWatch tells whether the user may see a movie or series. Callers answer
+// 404 when it can't.
+func canWatch(c *gin.Context, library string, minAge *int) bool {
+	user, ok := currentUser(c)
+	return !ok || user.CanWatch(library, minAge)
+}
+
+// allowPlayback guards the stream, HLS, chapters and trickplay endpoints of
+// a movie (by tmdbID) or episode (by ID), answering 404 when the media is
+// hidden from the user. Restricted users get a 404 for unknown media and a
+// 500 when the lookup fails, never the media.
+func allowPlayback(c *gin.Context, kind, id string) bool {
+	user, ok := currentUser(c)
+	if !ok || !user.Restricted() {
+		return true
+	}
+	ctx, cancel := getDBContext()
+	defer cancel()
+
+	var rated struct {
+		Library string `bson:"library"`
+		MinAge  *int   `bson:"minAge"`
+	}
+	var err error
+	if kind == "movie" {
+		tmdbID, convErr := strconv.Atoi(id)
+		if convErr != nil {
+			err = mongo.ErrNoDocuments
+		} else {
+			err = utils.GetCollection("movies").FindOne(ctx, bson.M{"tmdbID": tmdbID}).Decode(&rated)
+		}
+	} else {
+		var ep utils.Episode
+		if objID, hexErr := primitive.ObjectIDFromHex(id); hexErr != nil {
+			err = mongo.ErrNoDocuments
+		} else {
+			err = utils.GetCollection("episodes").FindOne(ctx, bson.M{"_id": objID}).Decode(&ep)
+		}
+		if err == nil {
+			err = utils.GetCollection("series").FindOne(ctx, bson.M{"_id": ep.SeriesID}).Decode(&rated)
+		}
+	}
+	switch {
+	case err == nil && user.CanWatch(rated.Library, rated.MinAge):
+		return true
+	case err == nil, err == mongo.ErrNoDocuments:
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": kind + " not found"})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	}
+	return false
+}
@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Keys of the authenticated user and session in the gin context
+const (
+	CTX_USER    = "user"
+	CTX_SESSION = "session"
+)
+
+// requestToken reads the access token from "Authorization: Bearer <token>",
+// or from ?token= for URLs used as <video> or <img> sources
+func requestToken(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return c.Query("token")
+}
+
+func abortUnauthorized(c *gin.Context, msg string) {
+	c.Header("WWW-Authenticate", `Bearer realm="nitflex"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+}
+
+// RequireAuth rejects requests without a valid access token and stores the
+// user and session in the context. AUTH_DISABLED=true lets everything through.
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if utils.AuthDisabled() {
+			c.Next()
+			return
+		}
+		token := requestToken(c)
+		if token == "" {
+			abortUnauthorized(c, "authentication required")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		session, err := utils.SessionFromAccessToken(ctx, token)
+		if errors.Is(err, utils.ErrInvalidToken) {
+			abortUnauthorized(c, err.Error())
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		var user utils.User
+		if err := utils.GetCollection("users").FindOne(ctx, bson.M{"_id": session.UserID}).Decode(&user); err != nil {
+			// Profile deleted since the login
+			abortUnauthorized(c, utils.ErrInvalidToken.Error())
+			return
+		}
+
+		c.Set(CTX_USER, user)
+		c.Set(CTX_SESSION, session)
+		c.Next()
+	}
+}
+
+// RequireAuthOrSetup is RequireAuth followed by RequireRole(roles...),
+// except during setup, while no admin able to log in exists, so one can be
+// created
+func RequireAuthOrSetup(roles ...string) gin.HandlerFunc {
+	requireAuth, requireRole := RequireAuth(), RequireRole(roles...)
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		setup, err := setupPending(ctx)
+		cancel()
+		if err == nil && setup {
+			c.Next()
+			return
+		}
+		requireAuth(c)
+		if !c.IsAborted() {
+			requireRole(c)
+		}
+	}
+}
+
+// RequireRole lets through the users having one of the roles. It runs after
+// RequireAuth; with auth disabled every request is let through.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if utils.AuthDisabled() || len(roles) == 0 {
+			c.Next()
+			return
+		}
+		if user, ok := currentUser(c); ok && slices.Contains(roles, user.Role) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	}
+}
+
+// currentUser returns the authenticated user, false when auth is disabled
+func currentUser(c *gin.Context) (utils.User, bool) {
+	v, ok := c.Get(CTX_USER)
+	if !ok {
+		return utils.User{}, false
+	}
+	user, ok := v.(utils.User)
+	return user, ok
+}
+
+// actingUserID is the user a request acts for: the session user, or with
+// auth disabled the id sent by the client (legacy "user" fields)
+func actingUserID(c *gin.Context, fallback string) (primitive.ObjectID, error) {
+	if user, ok := currentUser(c); ok {
+		return user.ID, nil
+	}
+	if !utils.AuthDisabled() {
+		return primitive.NilObjectID, errors.New("authentication required")
+	}
+	return primitive.ObjectIDFromHex(fallback)
+}
+
+// isAdmin tells whether the request comes from an admin, always true with
+// auth disabled
+func isAdmin(c *gin.Context) bool {
+	if utils.AuthDisabled() {
+		return true
+	}
+	user, ok := currentUser(c)
+	return ok && user.Role == utils.ROLE_ADMIN
+}
+
+// requireSelf answers 403 unless the request comes from the given user or an admin
+func requireSelf(c *gin.Context, userID primitive.ObjectID) bool {
+	if isAdmin(c) {
+		return true
+	}
+	if user, ok := currentUser(c); ok && user.ID == userID {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	return false
+}
+
+// POST /auth/login {userId, password} - password is the PIN of PIN profiles.
+// Profiles without a password or PIN can't hold a session: they answer 403
+// with setupRequired until an admin sets one (PUT /users/:id/secret).
+func Login(c *gin.Context) {
+	var input struct {
+		UserID   string `json:"userId" binding:"required"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(input.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+
+	if left := utils.LoginLocked(userID); left > 0 {
+		retry := int(math.Ceil(left.Seconds()))
+		c.Header("Retry-After", fmt.Sprint(retry))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts", "retryAfter": retry})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var user utils.User
+	if err := utils.GetCollection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	if user.Auth == utils.AUTH_NONE {
+		c.JSON(http.StatusForbidden, gin.H{"error": "set a password or PIN first", "setupRequired": true})
+		return
+	}
+	if !utils.CheckSecret(user, input.Password) {
+		utils.RecordLogin(userID, false)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+	utils.RecordLogin(userID, true)
+
+	_, tokens, err := utils.CreateSession(ctx, user.ID, c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"accessToken":  tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+		"user":         user,
+	})
+}
+
+// POST /auth/refresh {refreshToken} - new tokens, the old ones stop working
+func RefreshToken(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, tokens, err := utils.RefreshSession(ctx, input.RefreshToken)
+	if errors.Is(err, utils.ErrInvalidToken) {
+		abortUnauthorized(c, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// POST /auth/logout?all=true - end the current session, or every session of the user
+func Logout(c *gin.Context) {
+	v, ok := c.Get(CTX_SESSION)
+	if !ok {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	session := v.(utils.Session)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{"_id": session.ID}
+	if c.Query("all") == "true" {
+		filter = bson.M{"userID": session.UserID}
+	}
+	if err := utils.DeleteSessions(ctx, filter); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /auth/status - whether profiles must log in, and whether the first
+// admin is still to be created (POST /users with a password or PIN)
+func GetAuthStatus(c *gin.Context) {
+	if utils.AuthDisabled() {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "setupRequired": false})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pending, err := setupPending(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "setupRequired": pending})
+}
+
+// GET /auth/me
+func GetMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "authentication is disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// PUT /users/:id/secret {auth, secret, current} - set, change or remove
+// (auth "", not for admins) the password or PIN of a profile. The current one
+// is required when set. Other sessions of the profile are logged out.
+func UpdateUserSecret(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalide"})
+		return
+	}
+	if !requireSelf(c, objID) {
+		return
+	}
+	var input struct {
+		Auth    string `json:"auth"`
+		Secret  string `json:"secret"`
+		Current string `json:"current"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var user utils.User
+	if err := utils.GetCollection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Utilisateur non trouvé"})
+		return
+	}
+	// Admins reset the secret of other profiles without knowing it
+	me, ok := currentUser(c)
+	self := ok && me.ID == objID
+	if (self || !isAdmin(c)) && !utils.CheckSecret(user, input.Current) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "current password is wrong"})
+		return
+	}
+
+	if input.Auth == utils.AUTH_NONE && user.Role == utils.ROLE_ADMIN {
+		c.JSON(http.StatusConflict, gin.H{"error": "an admin needs a password or PIN"})
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"auth": "", "secretHash": ""}}
+	if input.Auth != utils.AUTH_NONE {
+		if err := utils.ValidateSecret(input.Auth, input.Secret); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hash, err := utils.HashSecret(input.Secret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		update = bson.M{"$set": bson.M{"auth": input.Auth, "secretHash": hash}}
+	}
+	if _, err := utils.GetCollection("users").UpdateOne(ctx, bson.M{"_id": objID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	revoke := bson.M{"userID": objID}
+	if v, ok := c.Get(CTX_SESSION); ok {
+		revoke["_id"] = bson.M{"$ne": v.(utils.Session).ID}
+	}
+	if err := utils.DeleteSessions(ctx, revoke); err != nil {
+		fmt.Println("Session cleanup error:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"auth": input.Auth})
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Movies and episodes are marked as watched automatically once played past
+// WATCHED_THRESHOLD (see UpdateOnGoingMedia), or by hand with the routes
+// below. With auth disabled, the user is taken from ?user=.
+
+const DEFAULT_HISTORY_LIMIT = 50
+
+// watchedInput is the body of the PUT /watched routes
+type watchedInput struct {
+	Watched *bool `json:"watched" binding:"required"`
+}
+
+// requestUser returns the user a request acts for, writing the error response itself
+func requestUser(c *gin.Context) (primitive.ObjectID, bool) {
+	userID, err := actingUserID(c, c.Query("user"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return userID, false
+	}
+	return userID, true
+}
+
+// applyWatched marks the media as watched or unwatched. Marking as watched
+// also drops their playback progress, they are finished.
+func applyWatched(c *gin.Context, ctx context.Context, userID primitive.ObjectID, typ string, media []utils.WatchedMedia, watched bool) {
+	if err := utils.SetWatched(ctx, userID, media, watched); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if watched {
+		ids := make([]primitive.ObjectID, len(media))
+		for i, m := range media {
+			ids[i] = m.ID
+		}
+		if err := purgeUserProgress(ctx, userID, typ, ids); err != nil {
+			fmt.Println("Progress cleanup error:", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"watched": watched, "count": len(media)})
+}
+
+// PUT /watched/movie/:id {watched} - id is the ObjectID or the tmdbID
+func SetMovieWatched(c *gin.Context) {
+	var input watchedInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := getDBContext()
+	defer cancel()
+	movie, ok := findMovie(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+	applyWatched(c, ctx, userID, "movie", []utils.WatchedMedia{{Type: "movie", ID: movie.ID}}, *input.Watched)
+}
+
+// PUT /watched/episode/:id {watched}
+func SetEpisodeWatched(c *gin.Context) {
+	var input watchedInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := getDBContext()
+	defer cancel()
+	episode, ok := findEpisode(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+	media := []utils.WatchedMedia{{Type: "episode", ID: episode.ID, SeriesID: episode.SeriesID}}
+	applyWatched(c, ctx, userID, "episode", media, *input.Watched)
+}
+
+// PUT /watched/series/:id {watched} - every episode of the series
+func SetSeriesWatched(c *gin.Context) {
+	setEpisodesWatched(c, bson.M{})
+}
+
+// PUT /watched/series/:id/seasons/:season {watched} - every episode of a season
+func SetSeasonWatched(c *gin.Context) {
+	season, err := strconv.Atoi(c.Param("season"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid season number"})
+		return
+	}
+	setEpisodesWatched(c, bson.M{"seasonNumber": season})
+}
+
+// setEpisodesWatched marks the episodes of the :id series matching filter
+func setEpisodesWatched(c *gin.Context, filter bson.M) {
+	var input watchedInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+	filter["seriesID"] = series.ID
+	cursor, err := utils.GetCollection("episodes").Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var episodes []utils.Episode
+	if err := cursor.All(ctx, &episodes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(episodes) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No episode found"})
+		return
+	}
+	media := make([]utils.WatchedMedia, len(episodes))
+	for i, ep := range episodes {
+		media[i] = utils.WatchedMedia{Type: "episode", ID: ep.ID, SeriesID: series.ID}
+	}
+	applyWatched(c, ctx, userID, "episode", media, *input.Watched)
+}
+
+// GET /history?type=movie|episode&limit=&skip= - what the user finished,
+// most recent first, with the title and poster of each media
+func GetHistory(c *gin.Context) {
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	filter := bson.M{"user": userID, "watched": true}
+	switch typ := c.Query("type"); typ {
+	case "":
+	case "movie", "episode":
+		filter["type"] = typ
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be movie or episode"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DEFAULT_HISTORY_LIMIT)))
+	if err != nil || limit <= 0 {
+		limit = DEFAULT_HISTORY_LIMIT
+	}
+	skip, _ := strconv.Atoi(c.Query("skip"))
+	if skip < 0 {
+		skip = 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Records of deleted media are dropped before paginating
+	exists := func(coll string) bson.M {
+		return bson.M{"$lookup": bson.M{
+			"from":     coll,
+			"let":      bson.M{"media": "$media"},
+			"pipeline": bson.A{bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$media"}}}}, bson.M{"$project": bson.M{"_id": 1}}},
+			"as":       "_" + coll,
+		}}
+	}
+	cursor, err := utils.GetCollection("watch_history").Aggregate(ctx, bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$sort": bson.D{{Key: "lastWatched", Value: -1}}},
+		exists("movies"),
+		exists("episodes"),
+		bson.M{"$match": bson.M{"$or": bson.A{
+			bson.M{"type": "movie", "_movies.0": bson.M{"$exists": true}},
+			bson.M{"type": "episode", "_episodes.0": bson.M{"$exists": true}},
+		}}},
+		bson.M{"$skip": skip},
+		bson.M{"$limit": limit},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var records []utils.WatchRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Media of the page in one query per collection
+	var movieIDs, episodeIDs []primitive.ObjectID
+	for _, r := range records {
+		if r.Type == "movie" {
+			movieIDs = append(movieIDs, r.MediaID)
+		} else {
+			episodeIDs = append(episodeIDs, r.MediaID)
+		}
+	}
+	movies := map[primitive.ObjectID]utils.Movie{}
+	episodes := map[primitive.ObjectID]utils.Episode{}
+	series := map[primitive.ObjectID]utils.Series{}
+	var seriesIDs []primitive.ObjectID
+	err = loadByIDs(ctx, "movies", movieIDs, func(m utils.Movie) { movies[m.ID] = m })
+	if err == nil {
+		err = loadByIDs(ctx, "episodes", episodeIDs, func(ep utils.Episode) {
+			episodes[ep.ID] = ep
+			seriesIDs = append(seriesIDs, ep.SeriesID)
+		})
+	}
+	if err == nil {
+		err = loadByIDs(ctx, "series", seriesIDs, func(s utils.Series) { series[s.ID] = s })
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	items := []gin.H{}
+	for _, r := range records {
+		item := gin.H{"type": r.Type, "id": r.MediaID, "playCount": r.PlayCount, "lastWatched": r.LastWatched}
+		if r.Type == "movie" {
+			m, ok := movies[r.MediaID]
+			if !ok || !canWatch(c, m.Library, m.MinAge) {
+				continue
+			}
+			item["title"], item["tmdbID"], item["poster"] = m.Title, m.TmdbID, m.Poster
+		} else {
+			ep, ok := episodes[r.MediaID]
+			s, found := series[ep.SeriesID]
+			if !ok || !found || !canWatch(c, s.Library, s.MinAge) {
+				continue
+			}
+			item["title"], item["seasonNumber"], item["episodeNumber"] = ep.Title, ep.SeasonNumber, ep.EpisodeNumber
+			item["series"] = gin.H{"id": s.ID, "title": s.Title, "tmdbID": s.TmdbID, "poster": s.Poster}
+		}
+		items = append(items, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "skip": skip, "limit": limit})
+}
+
+// purgeHistory removes the watch history of deleted movies or episodes
+// (typ "movie" | "episode")
+func purgeHistory(ctx context.Context, typ string, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := utils.GetCollection("watch_history").DeleteMany(ctx, bson.M{"type": typ, "media": bson.M{"$in": ids}})
+	return err
+}
+
+// loadByIDs decodes the documents of coll with the given IDs
+func loadByIDs[T any](ctx context.Context, coll string, ids []primitive.ObjectID, each func(T)) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	cursor, err := utils.GetCollection(coll).Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"versions": 0}))
+	if err != nil {
+		return err
+	}
+	var docs []T
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	for _, d := range docs {
+		each(d)
+	}
+	return nil
+}
+
+// GET /history/series/:id - watched episodes of a series, by episode ID
+func GetSeriesHistory(c *gin.Context) {
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := getDBContext()
+	defer cancel()
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+	watched, err := utils.WatchedEpisodes(ctx, userID, series.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	cursor, err := utils.GetCollection("episodes").Find(ctx, bson.M{"seriesID": series.ID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var stored []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &stored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Only episodes still in the series count
+	episodes := gin.H{}
+	for _, ep := range stored {
+		if r, ok := watched[ep.ID]; ok {
+			episodes[ep.ID.Hex()] = gin.H{"playCount": r.PlayCount, "lastWatched": r.LastWatched}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"episodes": episodes, "watched": len(episodes), "total": len(stored)})
+}
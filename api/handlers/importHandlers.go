@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"api/utils"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Imports take files already on the server, under INBOX_DIR (ex: a downloads
+// folder), instead of pushing them through the browser and nginx. They go
+// through the same validation and ingest jobs as uploads.
+
+var errInboxDisabled = errors.New("INBOX_DIR is not set")
+
+// inboxPath resolves a path relative to INBOX_DIR, symlinks included, and
+// refuses anything outside of it. An empty rel is the inbox itself.
+func inboxPath(rel string) (string, error) {
+	inbox := os.Getenv("INBOX_DIR")
+	if inbox == "" {
+		return "", errInboxDisabled
+	}
+	realInbox, err := filepath.EvalSymlinks(inbox)
+	if err != nil {
+		return "", err
+	}
+	if rel == "" || rel == "." || rel == "/" {
+		return realInbox, nil
+	}
+	joined, err := utils.SafeJoin(inbox, rel)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(joined)
+	if err != nil {
+		return "", err
+	}
+	if real != realInbox && !strings.HasPrefix(real, realInbox+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", utils.ErrUnsafePath, rel)
+	}
+	return real, nil
+}
+
+// inboxFile wraps a file of the inbox, moved or hardlinked by the ingest job
+func inboxFile(rel, mode string) (incomingFile, error) {
+	path, err := inboxPath(rel)
+	if err != nil {
+		return incomingFile{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return incomingFile{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return incomingFile{}, fmt.Errorf("%s is not a file", rel)
+	}
+	return incomingFile{
+		name:  info.Name(),
+		size:  info.Size(),
+		path:  path,
+		open:  func() (io.ReadCloser, error) { return os.Open(path) },
+		stage: func() (ingestSource, error) { return ingestSource{path: path, mode: mode}, nil },
+	}, nil
+}
+
+// respondInboxError maps an inbox path error to an HTTP status
+func respondInboxError(c *gin.Context, rel string, err error) {
+	switch {
+	case errors.Is(err, errInboxDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, utils.ErrUnsafePath):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "file": rel})
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found in inbox", "file": rel})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "file": rel})
+	}
+}
+
+// GET /import/inbox?path= - list a folder of the inbox
+func GetInbox(c *gin.Context) {
+	rel := c.Query("path")
+	dir, err := inboxPath(rel)
+	if err != nil {
+		respondInboxError(c, rel, err)
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		respondInboxError(c, rel, err)
+		return
+	}
+
+	list := []gin.H{}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, gin.H{
+			"name":     e.Name(),
+			"path":     filepath.ToSlash(filepath.Join(rel, e.Name())),
+			"dir":      e.IsDir(),
+			"size":     info.Size(),
+			"modified": info.ModTime(),
+			"video":    !e.IsDir() && videoExt(e.Name()) != "",
+		})
+	}
+	// Folders first, then by name
+	sort.SliceStable(list, func(i, k int) bool {
+		if list[i]["dir"] != list[k]["dir"] {
+			return list[i]["dir"].(bool)
+		}
+		return strings.ToLower(list[i]["name"].(string)) < strings.ToLower(list[k]["name"].(string))
+	})
+	c.JSON(http.StatusOK, gin.H{"path": rel, "entries": list})
+}
+
+// POST /import - register inbox files with the same metadata as POST /movies
+// (JSON fields) or POST /series ("episodes", whose fileName is the path of
+// each file under INBOX_DIR). Extra fields: type ("movie" or "series"), mode
+// ("move" by default, or "hardlink") and path (the movie file). Like
+// uploads, ?async=true answers with a job ID.
+func ImportMedia(c *gin.Context) {
+	var req struct {
+		Type string `json:"type" binding:"required,oneof=movie series"`
+		Mode string `json:"mode"`
+		Path string `json:"path"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid import: " + err.Error()})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = IMPORT_MODE_MOVE
+	}
+	if req.Mode != IMPORT_MODE_MOVE && req.Mode != IMPORT_MODE_HARDLINK {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid mode %q", req.Mode)})
+		return
+	}
+
+	if req.Type == utils.LIBRARY_TYPE_MOVIE {
+		var metadata MovieMetadata
+		if err := c.ShouldBindBodyWith(&metadata, binding.JSON); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to parse metadata: %s", err.Error())})
+			return
+		}
+		file, err := inboxFile(req.Path, req.Mode)
+		if err != nil {
+			respondInboxError(c, req.Path, err)
+			return
+		}
+		movie, job, ok := ingestMovie(c, metadata, file)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"movie": movie, "jobId": job.ID})
+		return
+	}
+
+	var metadata Metadata
+	if err := c.ShouldBindBodyWith(&metadata, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metadata: " + err.Error()})
+		return
+	}
+	files := []incomingFile{}
+	for _, meta := range metadata.Episodes {
+		file, err := inboxFile(meta.FileName, req.Mode)
+		if err != nil {
+			respondInboxError(c, meta.FileName, err)
+			return
+		}
+		files = append(files, file)
+	}
+	episodes, job, ok := ingestSeries(c, metadata, files)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"status": "ok", "jobId": job.ID, "episodes": episodes})
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Ingest runs as a job: the handler validates the request and stages the
+// uploaded files, then the job copies them into the library, probes them,
+// registers them and runs the post-ingest pipeline (see pipeline.go),
+// reporting its progress on /jobs/:id/events.
+
+// stagingDir holds uploads between the request and their ingest job,
+// UPLOAD_STAGING_DIR or a folder of the system temp dir
+func stagingDir() string {
+	if dir := os.Getenv("UPLOAD_STAGING_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "nitflex-staging")
+}
+
+// How an inbox file enters a library
+const (
+	IMPORT_MODE_MOVE     = "move"
+	IMPORT_MODE_HARDLINK = "hardlink" // The inbox keeps the file, ex: still seeding
+)
+
+// ingestSource is a file handed over to an ingest job
+type ingestSource struct {
+	path   string
+	mode   string // IMPORT_MODE_*
+	staged bool   // Upload staging file, dropped whatever the outcome
+}
+
+// discard drops a staging file that won't be ingested
+func (s ingestSource) discard() {
+	if s.staged {
+		os.Remove(s.path)
+	}
+}
+
+// imported tells whether the file comes from the inbox and must survive a failure
+func (s ingestSource) imported() bool {
+	return !s.staged && s.mode == IMPORT_MODE_MOVE
+}
+
+// rollback undoes the ingest of the file now at path: a moved inbox file goes
+// back to the inbox, anything else is removed
+func (s ingestSource) rollback(path string) {
+	if s.imported() {
+		if _, err := os.Stat(s.path); os.IsNotExist(err) {
+			if err := os.Rename(path, s.path); err != nil {
+				fmt.Println("Import rollback error:", err)
+				return // Better left in the library than lost
+			}
+			removeEmptyDirs(path)
+			return
+		}
+	}
+	removeMediaFile(path)
+}
+
+// stageUpload keeps an uploaded file beyond the request, whose multipart temp
+// files are deleted once the handler returns. Files already spooled to disk
+// are renamed, which costs nothing on the same filesystem.
+func stageUpload(fh *multipart.FileHeader) (ingestSource, error) {
+	if err := os.MkdirAll(stagingDir(), 0755); err != nil {
+		return ingestSource{}, err
+	}
+	src, err := fh.Open()
+	if err != nil {
+		return ingestSource{}, err
+	}
+	defer src.Close()
+
+	if f, ok := src.(*os.File); ok {
+		staged := filepath.Join(stagingDir(), filepath.Base(f.Name()))
+		if err := os.Rename(f.Name(), staged); err == nil {
+			return ingestSource{path: staged, mode: IMPORT_MODE_MOVE, staged: true}, nil
+		}
+	}
+	staged, err := writeTempFile(stagingDir(), src)
+	return ingestSource{path: staged, mode: IMPORT_MODE_MOVE, staged: true}, err
+}
+
+// progressReader reports the bytes read to a job, at most every half second,
+// and stops when the job is canceled
+type progressReader struct {
+	r     io.Reader
+	job   *utils.Job
+	total int64
+	done  int64
+	last  time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.job.Context().Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if p.total > 0 && (time.Since(p.last) > 500*time.Millisecond || err == io.EOF) {
+		p.last = time.Now()
+		p.job.Progress(float64(p.done)/float64(p.total), map[string]int64{"bytes": p.done, "total": p.total})
+	}
+	return n, err
+}
+
+// ingestUpload moves or links a file next to dst (copy step), validates it
+// (probe step) then gives it its final name, dst or the next free one
+func ingestUpload(job *utils.Job, src ingestSource, fileName, dst string) (string, utils.ProbeResult, error) {
+	defer src.discard() // no-op once moved
+
+	job.StartStep("copy", fileName)
+	claim, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return "", utils.ProbeResult{}, fmt.Errorf("failed to create file: %w", err)
+	}
+	tmp := claim.Name()
+	claim.Close()
+	if src.mode == IMPORT_MODE_HARDLINK {
+		os.Remove(tmp)
+		if err := os.Link(src.path, tmp); err != nil {
+			// Other filesystem: copy, the inbox keeps its file
+			job.Log("hardlink impossible, copying: " + err.Error())
+			if err := copyStaged(job, src.path, tmp); err != nil {
+				os.Remove(tmp)
+				return "", utils.ProbeResult{}, err
+			}
+		}
+	} else if os.Rename(src.path, tmp) != nil {
+		// Other filesystem: copy with progress
+		if err := copyStaged(job, src.path, tmp); err != nil {
+			os.Remove(tmp)
+			return "", utils.ProbeResult{}, err
+		}
+	}
+	job.Progress(1, nil)
+
+	job.StartStep("probe", fileName)
+	probe, err := probeUpload(tmp, fileName)
+	if err != nil {
+		src.rollback(tmp)
+		return "", probe, err
+	}
+	job.Log(fmt.Sprintf("%s %dx%d, %s", probe.VideoCodec, probe.Width, probe.Height, time.Duration(probe.Duration)*time.Second))
+
+	final, err := utils.RenameUnique(tmp, dst)
+	if err != nil {
+		src.rollback(tmp)
+		return "", probe, err
+	}
+	if src.imported() {
+		// Copied across filesystems: the move is complete once in the library
+		os.Remove(src.path)
+	}
+	return final, probe, nil
+}
+
+func copyStaged(job *utils.Job, staged, tmp string) error {
+	src, err := os.Open(staged)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, &progressReader{r: src, job: job, total: info.Size()})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// movieIngest is a movie file handed over to an ingest job
+type movieIngest struct {
+	movie    utils.Movie // Metadata, without file
+	edition  string
+	res      string
+	source   string
+	src      ingestSource
+	fileName string
+	dst      string
+}
+
+// runMovieIngest copies, validates and registers a movie. The pipeline then
+// runs in a job of its own, so the upload doesn't wait for ffmpeg.
+func runMovieIngest(job *utils.Job, in movieIngest) (utils.Movie, error) {
+	job.Start()
+	movie := in.movie
+
+	dst, _, err := ingestUpload(job, in.src, in.fileName, in.dst)
+	if err != nil {
+		removeEmptyDirs(in.dst)
+		job.Fail(err)
+		return movie, err
+	}
+
+	movie.FilePath = dst
+	movie.Format = filepath.Ext(dst)
+	movie.Versions = []utils.MediaVersion{{
+		ID:         primitive.NewObjectID(),
+		Label:      firstNonEmpty(strings.TrimSpace(in.edition+" "+in.res), "Original"),
+		Edition:    in.edition,
+		Resolution: in.res,
+		Source:     in.source,
+		FilePath:   movie.FilePath,
+		Format:     movie.Format,
+		Date:       movie.Date,
+	}}
+
+	job.StartStep("register", movie.Title)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	_, err = utils.GetCollection("movies").InsertOne(ctx, movie)
+	if err == nil {
+		if err := utils.WatchlistImported(ctx, "movie", movie.TmdbID); err != nil {
+			fmt.Println("Watchlist update error:", err)
+		}
+	}
+	cancel()
+	if err != nil {
+		in.src.rollback(dst)
+		job.Fail(err)
+		return movie, err
+	}
+
+	job.Log("pipeline: job " + startPipeline(movie.Title, &pipelineMedia{kind: "movie", movie: movie}))
+	job.Finish(movie)
+	return movie, nil
+}
+
+// episodeIngest is an episode file handed over to an ingest job
+type episodeIngest struct {
+	meta     EpisodeMeta
+	src      ingestSource
+	fileName string
+	dst      string
+}
+
+// runSeriesIngest copies, validates and registers the episodes of a series,
+// one file after the other, then starts the pipeline of the episodes in a job
+// of its own. Episodes registered before a failure are kept. A created series
+// is only stored with its first episode.
+func runSeriesIngest(job *utils.Job, series utils.Series, created bool, items []episodeIngest) ([]utils.Episode, error) {
+	job.Start()
+	episodes := []utils.Episode{}
+	touchedSeasons := []int{}
+
+	fail := func(err error, from int) ([]utils.Episode, error) {
+		for _, it := range items[from:] {
+			it.src.discard()
+		}
+		finishSeriesIngest(series, touchedSeasons)
+		job.Fail(err)
+		return episodes, err
+	}
+
+	for index, it := range items {
+		dst, probe, err := ingestUpload(job, it.src, it.fileName, it.dst)
+		if err != nil {
+			removeEmptyDirs(it.dst)
+			return fail(err, index+1)
+		}
+
+		// save episode in db
+		job.StartStep("register", it.meta.Title)
+		if created {
+			if series, err = insertSeries(series); err != nil {
+				it.src.rollback(dst)
+				return fail(fmt.Errorf("failed to create series: %w", err), index+1)
+			}
+			created = false
+		}
+		ep := utils.Episode{
+			ID:               primitive.NewObjectID(),
+			TmdbID:           series.TmdbID,
+			EpisodeNumber:    it.meta.EpisodeNumber,
+			EpisodeNumberEnd: it.meta.EpisodeNumberEnd,
+			AbsoluteNumber:   it.meta.AbsoluteNumber,
+			SeasonNumber:     it.meta.SeasonNumber,
+			SeriesID:         series.ID,
+			Title:            it.meta.Title,
+			Runtime:          int(probe.Duration / 60),
+			FilePath:         dst,
+			Date:             primitive.NewDateTimeFromTime(time.Now()),
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = utils.GetCollection("episodes").InsertOne(ctx, ep)
+		cancel()
+		if err != nil {
+			it.src.rollback(dst)
+			return fail(fmt.Errorf("failed to create episode: %w", err), index+1)
+		}
+		episodes = append(episodes, ep)
+		touchedSeasons = append(touchedSeasons, ep.SeasonNumber)
+		job.Progress(float64(index+1)/float64(len(items)), map[string]int{"files": index + 1, "total": len(items)})
+	}
+
+	job.StartStep("organize", series.Title)
+	finishSeriesIngest(series, touchedSeasons)
+
+	media := []*pipelineMedia{}
+	for i, ep := range episodes {
+		// Paths may have changed during the reorganisation
+		var current utils.Episode
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := utils.GetCollection("episodes").FindOne(ctx, bson.M{"_id": ep.ID}).Decode(&current)
+		cancel()
+		if err != nil {
+			job.Log("episode vanished before its pipeline: " + ep.Title)
+			continue
+		}
+		episodes[i] = current
+		media = append(media, &pipelineMedia{kind: "episode", episode: current, series: series})
+	}
+	if len(media) > 0 {
+		job.Log("pipeline: job " + startPipeline(series.Title, media...))
+	}
+
+	job.Finish(gin.H{"series": series, "episodes": episodes})
+	return episodes, nil
+}
+
+// insertSeries stores a series with its first episode. When another upload
+// created it meanwhile, the stored one is used.
+func insertSeries(series utils.Series) (utils.Series, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := utils.GetCollection("series").InsertOne(ctx, series)
+	if !mongo.IsDuplicateKeyError(err) {
+		return series, err
+	}
+	var existing utils.Series
+	if err := utils.GetCollection("series").FindOne(ctx, bson.M{"library": series.Library, "tmdbID": series.TmdbID}).Decode(&existing); err != nil {
+		return series, err
+	}
+	return existing, nil
+}
+
+// finishSeriesIngest flags the watchlist entries of the series once episodes
+// are stored, moves the episodes of a flat series getting a second season
+// into their season folder, and syncs the touched seasons. Anything
+// else is left as organised by hand, POST /series/:id/reorganize being the
+// explicit way to apply the whole layout.
+func finishSeriesIngest(series utils.Series, touchedSeasons []int) {
+	if len(touchedSeasons) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := utils.WatchlistImported(ctx, "series", series.TmdbID); err != nil {
+		fmt.Println("Watchlist update error:", err)
+	}
+	if _, err := splitFlatSeries(ctx, series); err != nil {
+		fmt.Println("Series reorganisation error:", err)
+	}
+	syncSeasons(ctx, series.ID, touchedSeasons...)
+}
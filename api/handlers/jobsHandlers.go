@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"api/utils"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wantsAsync tells whether the client asked for a job ID instead of waiting
+// for the ingest: ?async=true or "Prefer: respond-async"
+func wantsAsync(c *gin.Context) bool {
+	return c.Query("async") == "true" || strings.Contains(c.GetHeader("Prefer"), "respond-async")
+}
+
+// GET /jobs?status=running
+func GetJobs(c *gin.Context) {
+	status := c.Query("status")
+	list := []utils.JobInfo{}
+	for _, job := range utils.ListJobs() {
+		if status == "" || job.Status == status {
+			list = append(list, job)
+		}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GET /jobs/:id
+func GetJob(c *gin.Context) {
+	job, ok := utils.GetJob(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	events, _, _ := job.EventsSince(0)
+	c.JSON(http.StatusOK, gin.H{"job": job.Snapshot(), "events": events})
+}
+
+// DELETE /jobs/:id - cancel a running job
+func CancelJob(c *gin.Context) {
+	job, ok := utils.GetJob(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if !job.Cancel() {
+		c.JSON(http.StatusConflict, gin.H{"error": "job already finished"})
+		return
+	}
+	c.JSON(http.StatusAccepted, job.Snapshot())
+}
+
+// GET /jobs/:id/events - server-sent events of a job. A reconnecting client
+// sends Last-Event-ID (or ?lastEventId=) and only gets the events it missed.
+// The stream ends once the job is finished.
+func JobEvents(c *gin.Context) {
+	job, ok := utils.GetJob(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	lastID, _ := strconv.Atoi(firstNonEmpty(c.GetHeader("Last-Event-ID"), c.Query("lastEventId")))
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		events, done, wait := job.EventsSince(lastID)
+		for _, ev := range events {
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+			lastID = ev.ID
+		}
+		if done {
+			return false
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-wait:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-c.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errLayoutConflict = errors.New("destination already exists")
+
+// layoutMove relocates the files of one movie or episode to its layout path
+type layoutMove struct {
+	ID       primitive.ObjectID   `json:"id"`
+	From     string               `json:"from"`
+	To       string               `json:"to"`
+	versions []utils.MediaVersion // Before the move
+}
+
+// moviePath returns where a movie file belongs in its library root
+func moviePath(root string, movie utils.Movie, title, ext string) (string, error) {
+	rel, err := utils.CurrentLayout().MoviePath(title, movie.TmdbID, ext)
+	if err != nil {
+		return "", err
+	}
+	return utils.SafeJoin(root, rel)
+}
+
+// episodePath returns where an episode file belongs in its library root
+func episodePath(root string, series utils.Series, ep utils.Episode, ext string) (string, error) {
+	rel, err := utils.CurrentLayout().EpisodePath(series, ep, ext)
+	if err != nil {
+		return "", err
+	}
+	return utils.SafeJoin(root, rel)
+}
+
+// currentRoot is the library root holding a file, its folder when it lives
+// outside every library
+func currentRoot(path string) string {
+	if root := utils.LibraryRootOf(path); root != "" {
+		return root
+	}
+	return filepath.Dir(path)
+}
+
+// planMovieLayout returns the move needed by a movie, if any. Files outside
+// every library are left alone.
+func planMovieLayout(movie utils.Movie) ([]layoutMove, error) {
+	root := utils.LibraryRootOf(movie.FilePath)
+	if root == "" {
+		return nil, nil
+	}
+	to, err := moviePath(root, movie, movie.CustomTitle, videoExt(movie.FilePath))
+	if err != nil {
+		return nil, err
+	}
+	if to = utils.KeepUniqueSuffix(movie.FilePath, to); to == movie.FilePath {
+		return nil, nil
+	}
+	return []layoutMove{{ID: movie.ID, From: movie.FilePath, To: to, versions: movie.Versions}}, nil
+}
+
+// planSeriesLayout returns the moves needed by the episodes of a series
+func planSeriesLayout(ctx context.Context, series utils.Series) ([]layoutMove, error) {
+	cursor, err := utils.GetCollection("episodes").Find(ctx, bson.M{"seriesID": series.ID})
+	if err != nil {
+		return nil, err
+	}
+	var episodes []utils.Episode
+	if err := cursor.All(ctx, &episodes); err != nil {
+		return nil, err
+	}
+
+	moves := []layoutMove{}
+	for _, ep := range episodes {
+		root := utils.LibraryRootOf(ep.FilePath)
+		if root == "" {
+			continue
+		}
+		to, err := episodePath(root, series, ep, videoExt(ep.FilePath))
+		if err != nil {
+			return nil, err
+		}
+		if to = utils.KeepUniqueSuffix(ep.FilePath, to); to != ep.FilePath {
+			moves = append(moves, layoutMove{ID: ep.ID, From: ep.FilePath, To: to, versions: ep.Versions})
+		}
+	}
+	return moves, nil
+}
+
+// applyLayout performs moves as a whole: destinations are checked before
+// touching anything, then files are renamed and documents of coll updated.
+// commit, if set, runs last. Any failure restores the previous paths on disk
+// and in the database.
+func applyLayout(ctx context.Context, coll *mongo.Collection, moves []layoutMove, commit func() error) error {
+	sources := map[string]bool{}
+	for _, m := range moves {
+		sources[m.From] = true
+	}
+	targets := map[string]bool{}
+	for _, m := range moves {
+		if targets[m.To] {
+			return fmt.Errorf("%w: %s (twice)", errLayoutConflict, m.To)
+		}
+		targets[m.To] = true
+		if _, err := os.Stat(m.To); err == nil && !sources[m.To] {
+			return fmt.Errorf("%w: %s", errLayoutConflict, m.To)
+		}
+	}
+
+	renamed := map[string]string{} // new path -> old path
+	newVersions := make([][]utils.MediaVersion, len(moves))
+	for i, m := range moves {
+		versions, done, err := renameVersionFiles(m.From, m.To, m.versions)
+		if err != nil {
+			rollbackRenames(renamed)
+			return err
+		}
+		for k, v := range done {
+			renamed[k] = v
+		}
+		newVersions[i] = versions
+	}
+
+	updated := []layoutMove{}
+	rollback := func() {
+		for _, m := range updated {
+			restore := bson.M{"$set": bson.M{"filePath": m.From, "versions": m.versions}}
+			if m.versions == nil {
+				restore = bson.M{"$set": bson.M{"filePath": m.From}, "$unset": bson.M{"versions": ""}}
+			}
+			if _, err := coll.UpdateOne(ctx, bson.M{"_id": m.ID}, restore); err != nil {
+				fmt.Println("Layout rollback error:", err)
+			}
+		}
+		rollbackRenames(renamed)
+	}
+	for i, m := range moves {
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": m.ID}, bson.M{"$set": bson.M{"filePath": m.To, "versions": newVersions[i]}}); err != nil {
+			rollback()
+			return err
+		}
+		updated = append(updated, m)
+	}
+	if commit != nil {
+		if err := commit(); err != nil {
+			rollback()
+			return err
+		}
+	}
+
+	for _, m := range moves {
+		removeEmptyDirs(m.From)
+	}
+	return nil
+}
+
+// reorganizeSeries moves the episodes of a series to their layout paths
+func reorganizeSeries(ctx context.Context, series utils.Series) ([]layoutMove, error) {
+	moves, err := planSeriesLayout(ctx, series)
+	if err != nil || len(moves) == 0 {
+		return moves, err
+	}
+	if err := applyLayout(ctx, utils.GetCollection("episodes"), moves, nil); err != nil {
+		return nil, err
+	}
+	syncSeasons(ctx, series.ID, movedSeasons(ctx, moves)...)
+	return moves, nil
+}
+
+// splitFlatSeries moves the episodes lying directly in the folder of a
+// series into the season folder of the layout, once the series has several
+// seasons. File names are kept.
+func splitFlatSeries(ctx context.Context, series utils.Series) ([]layoutMove, error) {
+	seasons, err := utils.GetCollection("episodes").Distinct(ctx, "seasonNumber", bson.M{"seriesID": series.ID})
+	if err != nil || len(seasons) < 2 {
+		return nil, err
+	}
+	planned, err := planSeriesLayout(ctx, series)
+	if err != nil {
+		return nil, err
+	}
+	moves := []layoutMove{}
+	for _, m := range planned {
+		seasonDir := filepath.Dir(m.To)
+		if filepath.Dir(m.From) != filepath.Dir(seasonDir) {
+			continue // already in a folder of its own
+		}
+		m.To = filepath.Join(seasonDir, filepath.Base(m.From))
+		moves = append(moves, m)
+	}
+	if len(moves) == 0 {
+		return moves, nil
+	}
+	if err := applyLayout(ctx, utils.GetCollection("episodes"), moves, nil); err != nil {
+		return nil, err
+	}
+	syncSeasons(ctx, series.ID, movedSeasons(ctx, moves)...)
+	return moves, nil
+}
+
+// movedSeasons lists the seasons whose folder may have changed
+func movedSeasons(ctx context.Context, moves []layoutMove) []int {
+	ids := make([]primitive.ObjectID, 0, len(moves))
+	for _, m := range moves {
+		ids = append(ids, m.ID)
+	}
+	values, err := utils.GetCollection("episodes").Distinct(ctx, "seasonNumber", bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil
+	}
+	numbers := []int{}
+	for _, v := range values {
+		if n, ok := v.(int32); ok {
+			numbers = append(numbers, int(n))
+		}
+	}
+	return numbers
+}
+
+// layoutErrorStatus maps a reorganisation error to an HTTP status
+func layoutErrorStatus(err error) int {
+	if errors.Is(err, errLayoutConflict) || errors.Is(err, os.ErrExist) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// POST /series/:id/reorganize?dryRun=true - move the episode files to the
+// configured layout, updating their paths
+func ReorganizeSeries(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if c.Query("dryRun") == "true" {
+		moves, err := planSeriesLayout(ctx, series)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"moves": moves, "dryRun": true})
+		return
+	}
+
+	moves, err := reorganizeSeries(ctx, series)
+	if err != nil {
+		c.JSON(layoutErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"moves": moves})
+}
+
+// POST /movie/:id/reorganize?dryRun=true - move the movie files to the configured layout
+func ReorganizeMovie(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter, err := mediaFilter(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var movie utils.Movie
+	if err := utils.GetCollection("movies").FindOne(ctx, filter).Decode(&movie); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Movie not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	moves, err := planMovieLayout(movie)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("dryRun") != "true" && len(moves) > 0 {
+		if err := applyLayout(ctx, utils.GetCollection("movies"), moves, nil); err != nil {
+			c.JSON(layoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"moves": moves, "dryRun": c.Query("dryRun") == "true"})
+}
+
+// POST /libraries/:key/reorganize?dryRun=true - apply the layout to a whole
+// library, after a template change. Each movie or series is handled on its
+// own: a failure is reported without undoing the others.
+func ReorganizeLibrary(c *gin.Context) {
+	lib, err := utils.GetLibrary(c.Param("key"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	dryRun := c.Query("dryRun") == "true"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	moves := []layoutMove{}
+	failures := []gin.H{}
+
+	if lib.Type == utils.LIBRARY_TYPE_MOVIE {
+		cursor, err := utils.GetCollection("movies").Find(ctx, bson.M{"library": lib.Key})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch movies"})
+			return
+		}
+		var movies []utils.Movie
+		if err := cursor.All(ctx, &movies); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode movies"})
+			return
+		}
+		for _, movie := range movies {
+			planned, err := planMovieLayout(movie)
+			if err == nil && !dryRun && len(planned) > 0 {
+				err = applyLayout(ctx, utils.GetCollection("movies"), planned, nil)
+			}
+			if err != nil {
+				failures = append(failures, gin.H{"id": movie.ID, "title": movie.Title, "error": err.Error()})
+				continue
+			}
+			moves = append(moves, planned...)
+		}
+	} else {
+		cursor, err := utils.GetCollection("series").Find(ctx, bson.M{"library": lib.Key})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch series"})
+			return
+		}
+		var seriesList []utils.Series
+		if err := cursor.All(ctx, &seriesList); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode series"})
+			return
+		}
+		for _, series := range seriesList {
+			var planned []layoutMove
+			if dryRun {
+				planned, err = planSeriesLayout(ctx, series)
+			} else {
+				planned, err = reorganizeSeries(ctx, series)
+			}
+			if err != nil {
+				failures = append(failures, gin.H{"id": series.ID, "title": series.Title, "error": err.Error()})
+				continue
+			}
+			moves = append(moves, planned...)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"moves": moves, "failures": failures, "dryRun": dryRun})
+}
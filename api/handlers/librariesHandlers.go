@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GET /libraries
+func GetLibraries(c *gin.Context) {
+	libs := []utils.Library{}
+	for _, lib := range utils.Libraries() {
+		if canSeeLibrary(c, lib.Key) {
+			libs = append(libs, lib)
+		}
+	}
+	c.JSON(http.StatusOK, libs)
+}
+
+// POST /libraries - the key is unique (index on libraries.key)
+func CreateLibrary(c *gin.Context) {
+	var lib utils.Library
+	if err := c.ShouldBindJSON(&lib); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ValidateLibrary(lib); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lib.ID = primitive.NewObjectID()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := utils.GetCollection("libraries").InsertOne(ctx, lib); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "library key already used"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if err := utils.ReloadLibraries(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, lib)
+}
+
+// PUT /libraries/:key - the key and type are immutable since media reference
+// them. A root can only be removed once no movie or episode file is left
+// under it (move them with a reorganise first).
+func UpdateLibrary(c *gin.Context) {
+	current, err := utils.GetLibrary(c.Param("key"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var lib utils.Library
+	if err := c.ShouldBindJSON(&lib); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lib.ID = current.ID
+	lib.Key = current.Key
+	lib.Type = current.Type
+	if err := utils.ValidateLibrary(lib); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	kept := map[string]bool{}
+	for _, root := range lib.Roots {
+		kept[filepath.Clean(root)] = true
+	}
+	for _, root := range current.Roots {
+		if kept[filepath.Clean(root)] {
+			continue
+		}
+		count, err := countMediaUnder(ctx, root)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "media are still stored under a removed root", "root": root, "count": count})
+			return
+		}
+	}
+
+	if _, err := utils.GetCollection("libraries").ReplaceOne(ctx, bson.M{"_id": current.ID}, lib); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ReloadLibraries(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lib)
+}
+
+// DELETE /libraries/:key - refused while media still belong to the library
+func DeleteLibrary(c *gin.Context) {
+	lib, err := utils.GetLibrary(c.Param("key"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	coll := "movies"
+	if lib.Type == utils.LIBRARY_TYPE_SERIES {
+		coll = "series"
+	}
+	count, err := utils.GetCollection(coll).CountDocuments(ctx, bson.M{"library": lib.Key})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "library is not empty", "count": count})
+		return
+	}
+
+	if _, err := utils.GetCollection("libraries").DeleteOne(ctx, bson.M{"_id": lib.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ReloadLibraries(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": 1})
+}
+
+// countMediaUnder counts the movies and episodes with a file (default or
+// version) under root
+func countMediaUnder(ctx context.Context, root string) (int64, error) {
+	under := bson.M{"$regex": "^" + regexp.QuoteMeta(filepath.Clean(root)+string(filepath.Separator))}
+	filter := bson.M{"$or": bson.A{bson.M{"filePath": under}, bson.M{"versions.filePath": under}}}
+	var total int64
+	for _, coll := range []string{"movies", "episodes"} {
+		count, err := utils.GetCollection(coll).CountDocuments(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// resolveUploadLibrary picks the library for an upload: an explicit key wins,
+// otherwise the legacy isDocu/isKids flags map to the default library keys.
+func resolveUploadLibrary(key, typ string, isDocu, isKids bool) (utils.Library, error) {
+	if key == "" {
+		legacy := ""
+		switch {
+		case isKids && typ == utils.LIBRARY_TYPE_SERIES:
+			legacy = "series_kid"
+		case isDocu && typ == utils.LIBRARY_TYPE_MOVIE:
+			legacy = "movies_docu"
+		case isDocu:
+			legacy = "series_docu"
+		}
+		if legacy != "" {
+			if lib, err := utils.ResolveLibrary(legacy, typ); err == nil {
+				return lib, nil
+			}
+		}
+	}
+	return utils.ResolveLibrary(key, typ)
+}
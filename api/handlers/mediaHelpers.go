@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mediaFilter matches a movie or series either by Mongo ID (24 hex chars) or by tmdbID
+func mediaFilter(id string) (bson.M, error) {
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		return bson.M{"_id": oid}, nil
+	}
+	tmdbID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id %q", id)
+	}
+	return bson.M{"tmdbID": tmdbID}, nil
+}
+
+// hlsCacheDir returns the folder holding the HLS playlist and segments of a media
+func hlsCacheDir(typeMedia, id string) string {
+	base := os.Getenv("HLS_DIR")
+	if base == "" {
+		base = "./hls_cache"
+	}
+	return filepath.Join(base, typeMedia, id)
+}
+
+// removeHLSCache drops generated HLS files so they get rebuilt from the current file
+func removeHLSCache(typeMedia, id string) {
+	if err := os.RemoveAll(hlsCacheDir(typeMedia, id)); err != nil {
+		fmt.Println("HLS cleanup error:", err)
+	}
+}
+
+// checkFileName rejects names that would escape their folder or that
+// sanitization would make empty
+func checkFileName(name string) error {
+	n := strings.TrimSpace(name)
+	if n == "" || n == "." || n == ".." || strings.ContainsAny(n, `/\`) || (utils.SanitizeName(n) == "untitled" && !strings.EqualFold(n, "untitled")) {
+		return errors.New("invalid file name")
+	}
+	return nil
+}
+
+// videoExt returns the extension of a video file, ignoring dots that are
+// part of a title (ex: "Mr. Robot")
+func videoExt(path string) string {
+	ext := filepath.Ext(path)
+	if len(ext) < 2 || len(ext) > 5 || strings.ContainsAny(ext, " -_") {
+		return ""
+	}
+	return strings.ToLower(ext)
+}
+
+// renameMediaFile moves a media file, refusing to overwrite an existing one
+func renameMediaFile(oldPath, newPath string) error {
+	if oldPath == newPath {
+		return nil
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("%w: %s", errLayoutConflict, newPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// renameVersionFiles renames the default file of a media to newDefault, moving
+// the other versions named after it ("<name> - <label><ext>") alongside. It
+// returns the updated versions and the renames done (new path -> old path).
+func renameVersionFiles(defaultPath, newDefault string, versions []utils.MediaVersion) ([]utils.MediaVersion, map[string]string, error) {
+	oldDir := filepath.Dir(defaultPath)
+	oldBase := strings.TrimSuffix(filepath.Base(defaultPath), videoExt(defaultPath))
+	newBase := strings.TrimSuffix(filepath.Base(newDefault), videoExt(newDefault))
+
+	out := utils.MaterializeVersions(defaultPath, versions)
+	renamed := map[string]string{}
+	for i, v := range out {
+		target := ""
+		name := filepath.Base(v.FilePath)
+		if v.FilePath == defaultPath {
+			target = newDefault
+		} else if filepath.Dir(v.FilePath) == oldDir && strings.HasPrefix(name, oldBase) {
+			target = filepath.Join(filepath.Dir(newDefault), newBase+strings.TrimPrefix(name, oldBase))
+		} else {
+			continue
+		}
+		if err := renameMediaFile(v.FilePath, target); err != nil {
+			rollbackRenames(renamed)
+			return nil, nil, err
+		}
+		if target != v.FilePath {
+			renamed[target] = v.FilePath
+		}
+		out[i].FilePath = target
+	}
+	return out, renamed, nil
+}
+
+// rollbackRenames undoes renameMediaFile calls, given as new path -> old path
+func rollbackRenames(renamed map[string]string) {
+	for newPath, oldPath := range renamed {
+		if err := os.Rename(newPath, oldPath); err != nil {
+			fmt.Println("Rename rollback error:", err)
+		}
+	}
+}
+
+// removeMediaFile deletes a media file then the folders left empty above it,
+// up to (excluding) the library root
+func removeMediaFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removeEmptyDirs(path)
+	return nil
+}
+
+// removeEmptyDirs deletes the folders left empty above a removed or moved
+// file, up to (excluding) the library root
+func removeEmptyDirs(path string) {
+	stop := utils.LibraryRootOf(path)
+	if stop == "" {
+		return
+	}
+	for dir := filepath.Dir(path); dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // not empty
+		}
+	}
+}
+
+// purgeProgress removes the progress records pointing to the given movies or
+// episodes (typ "movie" | "episode"), their ongoing_medias wrappers and the
+// references kept on users
+func purgeProgress(ctx context.Context, typ string, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return purgeProgressMatching(ctx, typ, ids, bson.M{})
+}
+
+// purgeUserProgress removes the progress of one user on the given media,
+// ex: once they are marked as watched
+func purgeUserProgress(ctx context.Context, userID primitive.ObjectID, typ string, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return purgeProgressMatching(ctx, typ, ids, bson.M{"user": userID})
+}
+
+func purgeProgressMatching(ctx context.Context, typ string, ids []primitive.ObjectID, filter bson.M) error {
+	progressColl, field := "ongoing_movies", "movie"
+	if typ == "episode" {
+		progressColl, field = "ongoing_episodes", "episode"
+	}
+	filter[field] = bson.M{"$in": ids}
+
+	cursor, err := utils.GetCollection(progressColl).Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var progress []bson.M
+	if err := cursor.All(ctx, &progress); err != nil {
+		return err
+	}
+	progressIDs := make([]primitive.ObjectID, 0, len(progress))
+	for _, p := range progress {
+		if oid, ok := p["_id"].(primitive.ObjectID); ok {
+			progressIDs = append(progressIDs, oid)
+		}
+	}
+	if len(progressIDs) == 0 {
+		return nil
+	}
+
+	cursor, err = utils.GetCollection("ongoing_medias").Find(ctx, bson.M{"type": typ, "id": bson.M{"$in": progressIDs}})
+	if err != nil {
+		return err
+	}
+	var wrappers []bson.M
+	if err := cursor.All(ctx, &wrappers); err != nil {
+		return err
+	}
+	wrapperIDs := make([]primitive.ObjectID, 0, len(wrappers))
+	for _, w := range wrappers {
+		if oid, ok := w["_id"].(primitive.ObjectID); ok {
+			wrapperIDs = append(wrapperIDs, oid)
+		}
+	}
+
+	if len(wrapperIDs) > 0 {
+		if _, err := utils.GetCollection("users").UpdateMany(ctx,
+			bson.M{"onGoingMedias": bson.M{"$in": wrapperIDs}},
+			bson.M{"$pull": bson.M{"onGoingMedias": bson.M{"$in": wrapperIDs}}},
+		); err != nil {
+			return err
+		}
+		if _, err := utils.GetCollection("ongoing_medias").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": wrapperIDs}}); err != nil {
+			return err
+		}
+	}
+	_, err = utils.GetCollection(progressColl).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": progressIDs}})
+	return err
+}
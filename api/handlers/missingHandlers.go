@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GET /series/:id/missing?specials=true - aired episodes absent from the library
+// and upcoming ones, according to TMDB
+func GetSeriesMissing(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	report, err := utils.FindMissingEpisodes(ctx, series, c.Query("specials") == "true")
+	if err != nil {
+		status := http.StatusBadGateway
+		if err == utils.ErrTmdbDisabled {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GET /missing?library=&specials=true - missing report of every series, only
+// series with missing or upcoming episodes (or a TMDB error) are listed
+func GetLibraryMissing(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	filter := bson.M{}
+	restrictCatalogue(c, filter, c.Query("library"))
+	cursor, err := utils.GetCollection("series").Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch series"})
+		return
+	}
+	var seriesList []utils.Series
+	if err := cursor.All(ctx, &seriesList); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode series"})
+		return
+	}
+
+	specials := c.Query("specials") == "true"
+	reports := []utils.MissingReport{}
+	missing, upcoming := 0, 0
+	for _, series := range seriesList {
+		report, err := utils.FindMissingEpisodes(ctx, series, specials)
+		if err == utils.ErrTmdbDisabled {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			report.Error = err.Error()
+		} else if len(report.Missing) == 0 && len(report.Upcoming) == 0 {
+			continue
+		}
+		missing += len(report.Missing)
+		upcoming += len(report.Upcoming)
+		reports = append(reports, report)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"series":   reports,
+		"missing":  missing,
+		"upcoming": upcoming,
+	})
+}
@@ -4,7 +4,6 @@ import (
 	"api/utils"
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -21,17 +20,8 @@ import (
 
 // POST /movies
 func UploadMovie(c *gin.Context) {
-	type Metadata struct {
-		TmdbID      int     `json:"tmdbID" binding:"required"`
-		Title       string  `json:"title" binding:"required"`
-		Poster      string  `json:"poster" binding:"required"`
-		Rating      float64 `json:"rating" binding:"required"`
-		CustomTitle string  `json:"customTitle" binding:"required"`
-		IsDocu      string  `json:"isDocu" binding:"required"`
-	}
-
 	// get metadata
-	var metadata Metadata
+	var metadata MovieMetadata
 	if err := c.ShouldBind(&metadata); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to parse metadata: %s", err.Error())})
 		return
@@ -45,52 +35,126 @@ func UploadMovie(c *gin.Context) {
 	}
 	defer file.Close()
 
+	movie, job, ok := ingestMovie(c, metadata, uploadedFile(header))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":   fmt.Sprintf("http://localhost:8080/uploads/%s", header.Filename),
+		"movie": movie,
+		"jobId": job.ID,
+	})
+}
+
+// MovieMetadata describes a new movie, uploaded or imported
+type MovieMetadata struct {
+	TmdbID      int     `json:"tmdbID" binding:"required"`
+	Title       string  `json:"title" binding:"required"`
+	Poster      string  `json:"poster" binding:"required"`
+	Rating      float64 `json:"rating" binding:"required"`
+	CustomTitle string  `json:"customTitle" binding:"required"`
+	IsDocu      string  `json:"isDocu" binding:"required"`
+	Library     string  `json:"library"`
+	Edition     string  `json:"edition"`
+	Resolution  string  `json:"resolution"`
+	Source      string  `json:"source"`
+}
+
+// ingestMovie validates a new movie file and hands it over to an ingest job.
+// Errors, and the 202 of a job run in the background when the client asked
+// for it, are written to c; true means the movie was ingested and the caller
+// writes the response.
+func ingestMovie(c *gin.Context, metadata MovieMetadata, file incomingFile) (utils.Movie, *utils.Job, bool) {
+	// one document per movie: a better rip goes through POST /movie/:id/file
+	findCtx, findCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	var existing utils.Movie
+	err := utils.GetCollection("movies").FindOne(findCtx, bson.M{"tmdbID": metadata.TmdbID}).Decode(&existing)
+	findCancel()
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "movie already in library, replace its file instead", "id": existing.ID})
+		return existing, nil, false
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return existing, nil, false
+	}
+
 	// destination
-	// dst := filepath.Join(metadata.IsDocu == "true" && utils.MOVIES_DOCU_DIR : utils.MOVIES_DIR, metadata.CustomTitle)
-	var dst string
-	if metadata.IsDocu == "true" {
-		dst = filepath.Join(utils.MOVIES_DOCU_DIR, metadata.CustomTitle)
-	} else {
-		dst = filepath.Join(utils.MOVIES_DIR, metadata.CustomTitle)
+	lib, err := resolveUploadLibrary(metadata.Library, utils.LIBRARY_TYPE_MOVIE, metadata.IsDocu == "true", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid library: %s", err.Error())})
+		return existing, nil, false
+	}
+	root, err := lib.PickRoot("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return existing, nil, false
+	}
+
+	// validate before writing anything
+	if err := checkUploadSize(lib, root, file); err != nil {
+		respondUploadError(c, err)
+		return existing, nil, false
+	}
+	if err := sniffUpload(file); err != nil {
+		respondUploadError(c, err)
+		return existing, nil, false
 	}
 
-	out, err := os.Create(dst)
+	ext := videoExt(file.name)
+	if ext == "" {
+		ext = ".mp4"
+	}
+	dst, err := moviePath(root, utils.Movie{TmdbID: metadata.TmdbID}, metadata.CustomTitle, ext)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to create file: %s", err.Error())})
-		return
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return existing, nil, false
 	}
-	defer out.Close()
-	if _, err := io.Copy(out, file); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to write file: %s", err.Error())})
-		return
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to create folder: %s", err.Error())})
+		return existing, nil, false
+	}
+
+	// Keep the file past this request, the ingest job moves it into the library
+	src, err := file.stage()
+	if err != nil {
+		removeEmptyDirs(dst)
+		respondUploadError(c, err)
+		return existing, nil, false
 	}
 
 	// Build minimal movie object for client response and/or DB
-	movie := utils.Movie{
-		ID:          primitive.NewObjectID(),
-		FilePath:    dst,
-		Date:        primitive.NewDateTimeFromTime(time.Now()),
-		Format:      filepath.Ext(dst),
-		TmdbID:      metadata.TmdbID,
-		Title:       metadata.Title,
-		Poster:      metadata.Poster,
-		Rating:      metadata.Rating,
-		CustomTitle: metadata.CustomTitle,
-	}
-
-	// Insert movie into DB
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if _, err := utils.GetCollection("movies").InsertOne(ctx, movie); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	in := movieIngest{
+		movie: utils.Movie{
+			ID:          primitive.NewObjectID(),
+			Date:        primitive.NewDateTimeFromTime(time.Now()),
+			TmdbID:      metadata.TmdbID,
+			Title:       metadata.Title,
+			Poster:      metadata.Poster,
+			Rating:      metadata.Rating,
+			CustomTitle: metadata.CustomTitle,
+			Library:     lib.Key,
+		},
+		edition:  metadata.Edition,
+		res:      metadata.Resolution,
+		source:   metadata.Source,
+		src:      src,
+		fileName: file.name,
+		dst:      dst,
 	}
+	job := utils.NewJob("movie", metadata.Title)
 
-	// Respond immediately
-	c.JSON(http.StatusOK, gin.H{
-		"url":   fmt.Sprintf("http://localhost:8080/uploads/%s", header.Filename),
-		"movie": movie,
-	})
+	if wantsAsync(c) {
+		go runMovieIngest(job, in)
+		c.JSON(http.StatusAccepted, gin.H{"jobId": job.ID, "job": job.Snapshot()})
+		return in.movie, job, false
+	}
+
+	movie, err := runMovieIngest(job, in)
+	if err != nil {
+		respondUploadError(c, err)
+		return movie, job, false
+	}
+	return movie, job, true
 }
 
 // GET /movies
@@ -107,7 +171,11 @@ func GetMovies(c *gin.Context) {
 
 	filter := bson.M{}
 	if query.Genre != "" {
-		filter["genre"] = query.Genre
+		filter["genres"] = query.Genre
+	}
+	restrictCatalogue(c, filter, query.Library)
+	if query.MinHouseholdRating > 0 {
+		filter["householdRating.average"] = bson.M{"$gte": query.MinHouseholdRating}
 	}
 	if query.Title != "" {
 		// Recherche insensible à la casse et partielle
@@ -123,6 +191,9 @@ func GetMovies(c *gin.Context) {
 		orderParts := strings.Split(query.OrderBy, ":")
 		if len(orderParts) == 2 {
 			field := orderParts[0]
+			if field == "householdRating" {
+				field = "householdRating.average"
+			}
 			dir := -1
 			if strings.ToLower(orderParts[1]) == "asc" {
 				dir = 1
@@ -193,6 +264,207 @@ func GetMovieByID(c *gin.Context) {
 		}
 		return
 	}
+	if !canWatch(c, movie.Library, movie.MinAge) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Film non trouvé", "id": tmdbID})
+		return
+	}
 
 	c.JSON(http.StatusOK, movie)
 }
+
+// findMovie loads a movie the user may watch by Mongo ID or tmdbID, writing
+// the error response itself
+func findMovie(c *gin.Context, ctx context.Context, id string) (utils.Movie, bool) {
+	var movie utils.Movie
+	filter, err := mediaFilter(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return movie, false
+	}
+	if err := utils.GetCollection("movies").FindOne(ctx, filter).Decode(&movie); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Film non trouvé"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return movie, false
+	}
+	if !canWatch(c, movie.Library, movie.MinAge) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Film non trouvé"})
+		return movie, false
+	}
+	return movie, true
+}
+
+// PUT /movie/:id - edit metadata, re-match to another tmdbID and/or rename the file
+// after customTitle. :id is the Mongo ID or the tmdbID.
+func UpdateMovie(c *gin.Context) {
+	var input struct {
+		Title       *string  `json:"title"`
+		CustomTitle *string  `json:"customTitle"`
+		Poster      *string  `json:"poster"`
+		Rating      *float64 `json:"rating"`
+		TmdbID      *int     `json:"tmdbID"`
+
+		Certifications *map[string]string `json:"certifications"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := mediaFilter(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := utils.GetCollection("movies")
+	var movie utils.Movie
+	if err := coll.FindOne(ctx, filter).Decode(&movie); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Film non trouvé"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	set := bson.M{}
+	if input.Title != nil {
+		set["title"] = *input.Title
+	}
+	if input.Poster != nil {
+		set["poster"] = *input.Poster
+	}
+	if input.Rating != nil {
+		set["rating"] = *input.Rating
+	}
+	if input.Certifications != nil {
+		for k, v := range utils.CertificationFields(*input.Certifications) {
+			set[k] = v
+		}
+	}
+
+	// Re-match: the tmdbID must stay unique across movies
+	oldTmdbID := movie.TmdbID
+	if input.TmdbID != nil && *input.TmdbID != movie.TmdbID {
+		count, err := coll.CountDocuments(ctx, bson.M{"tmdbID": *input.TmdbID, "_id": bson.M{"$ne": movie.ID}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "another movie already uses this tmdbID"})
+			return
+		}
+		set["tmdbID"] = *input.TmdbID
+	}
+
+	// Rename the files after the new custom title, keeping their extension and
+	// version suffix
+	renamed := map[string]string{} // new path -> old path
+	if input.CustomTitle != nil && *input.CustomTitle != movie.CustomTitle {
+		newTitle := strings.TrimSpace(*input.CustomTitle)
+		if err := checkFileName(newTitle); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		target := movie
+		if tmdbID, ok := set["tmdbID"].(int); ok {
+			target.TmdbID = tmdbID
+		}
+		newPath, err := moviePath(currentRoot(movie.FilePath), target, newTitle, videoExt(movie.FilePath))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		versions, done, err := renameVersionFiles(movie.FilePath, newPath, movie.Versions)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("failed to rename file: %s", err.Error())})
+			return
+		}
+		renamed = done
+		set["filePath"] = newPath
+		set["customTitle"] = newTitle
+		set["versions"] = versions
+	}
+
+	if len(set) == 0 {
+		c.JSON(http.StatusOK, movie)
+		return
+	}
+	oldPath := movie.FilePath
+	if err := coll.FindOneAndUpdate(ctx, bson.M{"_id": movie.ID}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&movie); err != nil {
+		// Keep disk and DB consistent
+		rollbackRenames(renamed)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(renamed) > 0 {
+		removeEmptyDirs(oldPath)
+	}
+
+	if movie.TmdbID != oldTmdbID {
+		// Progress records and the HLS cache are keyed by tmdbID
+		if _, err := utils.GetCollection("ongoing_movies").UpdateMany(ctx, bson.M{"movie": movie.ID}, bson.M{"$set": bson.M{"tmdbID": movie.TmdbID}}); err != nil {
+			fmt.Println("Progress update error:", err)
+		}
+		removeHLSCache("movie", strconv.Itoa(oldTmdbID))
+		removeTrickplay("movie", strconv.Itoa(oldTmdbID))
+	}
+
+	c.JSON(http.StatusOK, movie)
+}
+
+// DELETE /movie/:id?deleteFiles=true - remove a movie, its progress records and
+// HLS cache, and optionally its file
+func DeleteMovie(c *gin.Context) {
+	filter, err := mediaFilter(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var movie utils.Movie
+	if err := utils.GetCollection("movies").FindOneAndDelete(ctx, filter).Decode(&movie); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Film non trouvé"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if err := purgeProgress(ctx, "movie", []primitive.ObjectID{movie.ID}); err != nil {
+		fmt.Println("Progress cleanup error:", err)
+	}
+	if err := purgeHistory(ctx, "movie", []primitive.ObjectID{movie.ID}); err != nil {
+		fmt.Println("History cleanup error:", err)
+	}
+	if err := purgeRatings(ctx, "movie", []primitive.ObjectID{movie.ID}); err != nil {
+		fmt.Println("Ratings cleanup error:", err)
+	}
+	if err := utils.WatchlistRemoved(ctx, "movie", movie.TmdbID); err != nil {
+		fmt.Println("Watchlist update error:", err)
+	}
+	removeHLSCache("movie", strconv.Itoa(movie.TmdbID))
+	removeTrickplay("movie", strconv.Itoa(movie.TmdbID))
+
+	if c.Query("deleteFiles") == "true" {
+		for _, v := range utils.MediaVersions(movie.FilePath, movie.Versions) {
+			if err := removeMediaFile(v.FilePath); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("movie deleted but failed to remove file: %s", err.Error())})
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": 1})
+}
@@ -14,6 +14,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Stale progress is purged in the background, reads only skip it
+const ONGOING_PURGE_INTERVAL = 6 * time.Hour
+
 // POST /ongoing_media - idempotent upsert for movie or episode progress and order pin
 func UpdateOnGoingMedia(c *gin.Context) {
 	var raw map[string]interface{}
@@ -22,9 +25,10 @@ func UpdateOnGoingMedia(c *gin.Context) {
 		return
 	}
 
-	// parse common fields
+	// parse common fields; the user comes from the session, the body "user"
+	// is only read when auth is disabled
 	userHex, _ := raw["user"].(string)
-	uid, err := primitive.ObjectIDFromHex(userHex)
+	uid, err := actingUserID(c, userHex)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
 		return
@@ -37,6 +41,13 @@ func UpdateOnGoingMedia(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
 	defer cancel()
 
+	// Going back before the threshold (rewatch) lets the next completion count
+	complete := utils.PastThreshold(position, duration)
+	progress := bson.M{"tmdbID": tmdbID, "duration": duration, "position": position, "user": uid, "updated": time.Now()}
+	if !complete {
+		progress["complete"] = false
+	}
+
 	var mediaDoc bson.M
 	medCol := utils.GetCollection("ongoing_medias")
 
@@ -56,15 +67,9 @@ func UpdateOnGoingMedia(c *gin.Context) {
 
 		// Upsert OnGoingEpisode by (user, episode) atomically
 		epFilter := bson.M{"user": uid, "episode": epID}
+		progress["episode"], progress["series"] = epID, seriesID
 		epUpdate := bson.M{
-			"$set": bson.M{
-				"episode":  epID,
-				"series":   seriesID,
-				"tmdbID":   tmdbID,
-				"duration": duration,
-				"position": position,
-				"user":     uid,
-			},
+			"$set":         progress,
 			"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
 		}
 		var ep utils.OnGoingEpisode
@@ -77,6 +82,9 @@ func UpdateOnGoingMedia(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
 			return
 		}
+		if complete {
+			recordCompletion(ctx, "ongoing_episodes", ep.ID, uid, utils.WatchedMedia{Type: "episode", ID: epID, SeriesID: seriesID})
+		}
 
 		// Upsert corresponding OnGoingMedia doc atomically and return it
 		filter := bson.M{"type": "episode", "id": ep.ID}
@@ -96,14 +104,9 @@ func UpdateOnGoingMedia(c *gin.Context) {
 		}
 		// Upsert OnGoingMovie by (user, movie) atomically
 		mvFilter := bson.M{"user": uid, "movie": mid}
+		progress["movie"] = mid
 		mvUpdate := bson.M{
-			"$set": bson.M{
-				"movie":    mid,
-				"tmdbID":   tmdbID,
-				"duration": duration,
-				"position": position,
-				"user":     uid,
-			},
+			"$set":         progress,
 			"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
 		}
 		var mv utils.OnGoingMovie
@@ -116,6 +119,9 @@ func UpdateOnGoingMedia(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
 			return
 		}
+		if complete {
+			recordCompletion(ctx, "ongoing_movies", mv.ID, uid, utils.WatchedMedia{Type: "movie", ID: mid})
+		}
 		// Upsert OnGoingMedia
 		filter := bson.M{"type": "movie", "id": mv.ID}
 		update := bson.M{"$setOnInsert": bson.M{"type": "movie", "id": mv.ID}}
@@ -168,7 +174,11 @@ func UpdateOnGoingMedia(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"ok": true, "onGoingMedia": mediaDoc})
 }
 
-// GET /ongoing_media/:id - Unified ongoing media for a user (movies and deduped episodes per series)
+// GET /ongoing_media/:id - "Continue watching" of a user, most recent first.
+// Finished movies are left out, each series appears once with its last
+// played episode, or the next unwatched one ("next": true) once it is
+// finished, without progress ids. Progress untouched for
+// CONTINUE_WATCHING_DAYS is left out (see PurgeStaleOnGoing).
 func GetOnGoingMediaByUserID(c *gin.Context) {
 	userID := c.Param("id")
 	uid, err := primitive.ObjectIDFromHex(userID)
@@ -176,6 +186,9 @@ func GetOnGoingMediaByUserID(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
+	if !requireSelf(c, uid) {
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
@@ -265,6 +278,8 @@ func GetOnGoingMediaByUserID(c *gin.Context) {
 	}
 
 	// Build response in user's order, skip duplicates if any
+	maxAge := utils.ContinueWatchingMaxAge()
+	seriesSeen := map[primitive.ObjectID]bool{}
 	out := make([]gin.H, 0, len(ids))
 	seen := map[primitive.ObjectID]bool{}
 	for _, oid := range ids {
@@ -279,38 +294,184 @@ func GetOnGoingMediaByUserID(c *gin.Context) {
 		t, _ := m["type"].(string)
 		rid, _ := m["id"].(primitive.ObjectID)
 		if t == "movie" {
-			if mv, ok := movieByOID[rid]; ok {
-				out = append(out, gin.H{
-					"type":     "movie",
-					"ogId":     oid.Hex(),
-					"id":       mv.ID.Hex(),
-					"tmdbID":   mv.TmdbID,
-					"position": mv.Position,
-					"duration": mv.Duration,
-				})
+			mv, ok := movieByOID[rid]
+			if !ok {
+				continue
+			}
+			if utils.ProgressStale(mv.ID, mv.Updated, maxAge) {
+				continue
 			}
+			if mv.Complete || utils.PastThreshold(mv.Position, mv.Duration) {
+				continue
+			}
+			out = append(out, gin.H{
+				"type":     "movie",
+				"ogId":     oid.Hex(),
+				"id":       mv.ID.Hex(),
+				"tmdbID":   mv.TmdbID,
+				"position": mv.Position,
+				"duration": mv.Duration,
+				"updated":  mv.Updated,
+			})
 		} else if t == "episode" {
-			if oe, ok := episodeByOID[rid]; ok {
-				meta := epMeta[oe.EpisodeID]
-				out = append(out, gin.H{
-					"type":          "episode",
-					"ogId":          oid.Hex(),
-					"id":            oe.ID.Hex(),
-					"episodeId":     oe.EpisodeID.Hex(),
-					"seriesId":      oe.SeriesID.Hex(),
-					"tmdbID":        oe.TmdbID,
-					"seasonNumber":  meta.SeasonNumber,
-					"episodeNumber": meta.EpisodeNumber,
-					"position":      oe.Position,
-					"duration":      oe.Duration,
-				})
+			oe, ok := episodeByOID[rid]
+			if !ok {
+				continue
+			}
+			if utils.ProgressStale(oe.ID, oe.Updated, maxAge) {
+				continue
 			}
+			// Only the most recent episode of a series counts
+			if seriesSeen[oe.SeriesID] {
+				continue
+			}
+			seriesSeen[oe.SeriesID] = true
+			meta, ok := epMeta[oe.EpisodeID]
+			if !ok {
+				continue
+			}
+			entry := gin.H{
+				"type":          "episode",
+				"ogId":          oid.Hex(),
+				"id":            oe.ID.Hex(),
+				"episodeId":     oe.EpisodeID.Hex(),
+				"seriesId":      oe.SeriesID.Hex(),
+				"tmdbID":        oe.TmdbID,
+				"seasonNumber":  meta.SeasonNumber,
+				"episodeNumber": meta.EpisodeNumber,
+				"position":      oe.Position,
+				"duration":      oe.Duration,
+				"updated":       oe.Updated,
+				"next":          false,
+			}
+			if oe.Complete || utils.PastThreshold(oe.Position, oe.Duration) {
+				next, ok, err := nextUnwatchedEpisode(ctx, uid, meta)
+				if err != nil {
+					fmt.Println("Next episode error:", err)
+				}
+				if !ok {
+					continue
+				}
+				entry["episodeId"], entry["seasonNumber"], entry["episodeNumber"] = next.ID.Hex(), next.SeasonNumber, next.EpisodeNumber
+				entry["position"], entry["duration"], entry["next"] = 0, 0, true
+				// The progress belongs to the finished episode
+				delete(entry, "ogId")
+				delete(entry, "id")
+			}
+			out = append(out, entry)
 		}
 	}
 
 	c.JSON(http.StatusOK, out)
 }
 
+// PurgeStaleOnGoing deletes the progress untouched for CONTINUE_WATCHING_DAYS
+// now and every ONGOING_PURGE_INTERVAL, until ctx is done
+func PurgeStaleOnGoing(ctx context.Context) {
+	ticker := time.NewTicker(ONGOING_PURGE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if err := purgeStaleOnGoing(ctx); err != nil {
+			fmt.Println("Expired progress cleanup error:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeStaleOnGoing(ctx context.Context) error {
+	maxAge := utils.ContinueWatchingMaxAge()
+	if maxAge <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	// Same rule as utils.ProgressStale
+	cutoff := time.Now().Add(-maxAge)
+	stale := bson.M{"$or": []bson.M{
+		{"updated": bson.M{"$lt": cutoff}},
+		{"updated": bson.M{"$exists": false}, "_id": bson.M{"$lt": primitive.NewObjectIDFromTimestamp(cutoff)}},
+	}}
+	for kind, collName := range map[string]string{"movie": "ongoing_movies", "episode": "ongoing_episodes"} {
+		cursor, err := utils.GetCollection(collName).Find(ctx, stale, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		var progress []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &progress); err != nil {
+			return err
+		}
+		if len(progress) == 0 {
+			continue
+		}
+		ids := make([]primitive.ObjectID, 0, len(progress))
+		for _, p := range progress {
+			ids = append(ids, p.ID)
+		}
+
+		cursor, err = utils.GetCollection("ongoing_medias").Find(ctx, bson.M{"type": kind, "id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		var wrappers []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &wrappers); err != nil {
+			return err
+		}
+		for _, w := range wrappers {
+			if err := deleteOnGoing(ctx, w.ID); err != nil {
+				return err
+			}
+		}
+		// Progress without a wrapper
+		if _, err := utils.GetCollection(collName).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextUnwatchedEpisode returns the first episode after current in its
+// playback chain that the user hasn't watched, false at the end of the series
+func nextUnwatchedEpisode(ctx context.Context, userID primitive.ObjectID, current utils.Episode) (utils.Episode, bool, error) {
+	var series utils.Series
+	if err := utils.GetCollection("series").FindOne(ctx, bson.M{"_id": current.SeriesID}).Decode(&series); err != nil {
+		return utils.Episode{}, false, err
+	}
+	cursor, err := utils.GetCollection("episodes").Find(ctx, bson.M{"seriesID": current.SeriesID},
+		options.Find().SetProjection(bson.M{"versions": 0}))
+	if err != nil {
+		return utils.Episode{}, false, err
+	}
+	var siblings []utils.Episode
+	if err := cursor.All(ctx, &siblings); err != nil {
+		return utils.Episode{}, false, err
+	}
+	watched, err := utils.WatchedEpisodes(ctx, userID, current.SeriesID)
+	if err != nil {
+		return utils.Episode{}, false, err
+	}
+
+	after := false
+	for _, ep := range utils.PlaybackChain(siblings, series.Ordering, current) {
+		if ep.ID == current.ID {
+			after = true
+			continue
+		}
+		if _, done := watched[ep.ID]; after && !done {
+			return ep, true, nil
+		}
+	}
+	return utils.Episode{}, false, nil
+}
+
 // DELETE /ongoing_media/:id - Delete ongoing media wrapper and underlying progress
 func DeleteOnGoingMedia(c *gin.Context) {
 	id := c.Param("id")
@@ -323,9 +484,20 @@ func DeleteOnGoingMedia(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
 	defer cancel()
 
-	// Load media wrapper
-	var media bson.M
-	if err := utils.GetCollection("ongoing_medias").FindOne(ctx, bson.M{"_id": objID}).Decode(&media); err != nil {
+	// Only the owner of the progress may delete it
+	if user, ok := currentUser(c); ok {
+		n, err := utils.GetCollection("users").CountDocuments(ctx, bson.M{"_id": user.ID, "onGoingMedias": objID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		if n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "OnGoingMedia not found"})
+			return
+		}
+	}
+
+	if err := deleteOnGoing(ctx, objID); err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"error": "OnGoingMedia not found"})
 		} else {
@@ -333,6 +505,16 @@ func DeleteOnGoingMedia(c *gin.Context) {
 		}
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"deleted": 1})
+}
+
+// deleteOnGoing deletes an ongoing media wrapper and the progress it points to
+func deleteOnGoing(ctx context.Context, objID primitive.ObjectID) error {
+	// Load media wrapper
+	var media bson.M
+	if err := utils.GetCollection("ongoing_medias").FindOne(ctx, bson.M{"_id": objID}).Decode(&media); err != nil {
+		return err
+	}
 	t, _ := media["type"].(string)
 	rid, _ := media["id"].(primitive.ObjectID)
 
@@ -345,9 +527,22 @@ func DeleteOnGoingMedia(c *gin.Context) {
 
 	// Remove wrapper from users then delete wrapper
 	utils.GetCollection("users").UpdateMany(ctx, bson.M{"onGoingMedias": objID}, bson.M{"$pull": bson.M{"onGoingMedias": objID}})
-	_, _ = utils.GetCollection("ongoing_medias").DeleteOne(ctx, bson.M{"_id": objID})
+	_, err := utils.GetCollection("ongoing_medias").DeleteOne(ctx, bson.M{"_id": objID})
+	return err
+}
 
-	c.JSON(http.StatusOK, gin.H{"deleted": 1})
+// recordCompletion adds a play to the history the first time a progress
+// record passes the watched threshold. Errors are only logged, progress was saved.
+func recordCompletion(ctx context.Context, collName string, progressID, userID primitive.ObjectID, media utils.WatchedMedia) {
+	res, err := utils.GetCollection(collName).UpdateOne(ctx,
+		bson.M{"_id": progressID, "complete": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"complete": true}})
+	if err == nil && res.ModifiedCount == 1 {
+		err = utils.RecordPlay(ctx, userID, media)
+	}
+	if err != nil {
+		fmt.Println("Watch history error:", err)
+	}
 }
 
 // helper: safe int conversion
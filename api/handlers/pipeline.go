@@ -0,0 +1,612 @@
+package handlers
+
+import (
+	"api/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The post-ingest pipeline enriches a movie or episode once its file is in the
+// library. Each step records its outcome under processing.<step> on the media
+// document, a failed step doesn't stop the next ones and can be re-run alone
+// with POST /movie/:id/pipeline/:step or /episode/:id/pipeline/:step.
+
+// pipelineStep is one stage of the pipeline. Steps are added to pipelineSteps.
+type pipelineStep struct {
+	name    string
+	enabled func() bool // nil: always enabled
+	timeout time.Duration
+	run     func(ctx context.Context, job *utils.Job, m *pipelineMedia) error
+	file    bool // Output depends on the default file, run again when it changes
+}
+
+var pipelineSteps = []pipelineStep{
+	{name: "probe", timeout: 30 * time.Second, run: probeStep, file: true},
+	{name: "metadata", timeout: 30 * time.Second, run: metadataStep},
+	{name: "artwork", timeout: 30 * time.Second, run: artworkStep},
+	{name: "chapters", timeout: 10 * time.Second, run: chaptersStep, file: true},
+	{name: "trickplay", enabled: func() bool { return os.Getenv("TRICKPLAY_ENABLED") != "false" }, timeout: time.Hour, run: trickplayStep, file: true},
+	{name: "hls", enabled: func() bool { return os.Getenv("HLS_PREGENERATE") == "true" }, timeout: 15 * time.Minute, run: hlsStep, file: true},
+	{name: "notify", enabled: func() bool { return os.Getenv("NOTIFY_WEBHOOK_URL") != "" }, timeout: 15 * time.Second, run: notifyStep},
+}
+
+// stepSkipped is returned by a step with nothing to do (no TMDB key, no poster...)
+type stepSkipped string
+
+func (s stepSkipped) Error() string { return string(s) }
+
+// pipelineMedia is the movie or episode going through the pipeline. Steps
+// update it along with the database so later steps see their results.
+type pipelineMedia struct {
+	kind    string // "movie" or "episode"
+	movie   utils.Movie
+	episode utils.Episode
+	series  utils.Series // Episodes only
+}
+
+func (m *pipelineMedia) id() primitive.ObjectID {
+	if m.kind == "movie" {
+		return m.movie.ID
+	}
+	return m.episode.ID
+}
+
+func (m *pipelineMedia) title() string {
+	if m.kind == "movie" {
+		return m.movie.Title
+	}
+	return fmt.Sprintf("%s S%02dE%02d", m.series.Title, m.episode.SeasonNumber, m.episode.EpisodeNumber)
+}
+
+func (m *pipelineMedia) filePath() string {
+	if m.kind == "movie" {
+		return m.movie.FilePath
+	}
+	return m.episode.FilePath
+}
+
+// cacheKey is the id of the media HLS and trickplay folders
+func (m *pipelineMedia) cacheKey() string {
+	if m.kind == "movie" {
+		return strconv.Itoa(m.movie.TmdbID)
+	}
+	return m.episode.ID.Hex()
+}
+
+func (m *pipelineMedia) processing() map[string]utils.StepStatus {
+	p := &m.episode.Processing
+	if m.kind == "movie" {
+		p = &m.movie.Processing
+	}
+	if *p == nil {
+		*p = map[string]utils.StepStatus{}
+	}
+	return *p
+}
+
+func (m *pipelineMedia) mediaInfo() *utils.ProbeResult {
+	if m.kind == "movie" {
+		return m.movie.MediaInfo
+	}
+	return m.episode.MediaInfo
+}
+
+// set updates fields of the media document
+func (m *pipelineMedia) set(ctx context.Context, fields bson.M) error {
+	_, err := utils.GetCollection(m.kind+"s").UpdateOne(ctx, bson.M{"_id": m.id()}, bson.M{"$set": fields})
+	return err
+}
+
+// loadPipelineMedia reads a movie or an episode with its series
+func loadPipelineMedia(ctx context.Context, kind string, filter bson.M) (*pipelineMedia, error) {
+	m := &pipelineMedia{kind: kind}
+	if kind == "movie" {
+		return m, utils.GetCollection("movies").FindOne(ctx, filter).Decode(&m.movie)
+	}
+	if err := utils.GetCollection("episodes").FindOne(ctx, filter).Decode(&m.episode); err != nil {
+		return m, err
+	}
+	return m, utils.GetCollection("series").FindOne(ctx, bson.M{"_id": m.episode.SeriesID}).Decode(&m.series)
+}
+
+// findPipelineStep returns a step by name
+func findPipelineStep(name string) (pipelineStep, bool) {
+	for _, step := range pipelineSteps {
+		if step.name == name {
+			return step, true
+		}
+	}
+	return pipelineStep{}, false
+}
+
+// stepEnabled tells whether a step runs in the automatic pipeline; any step
+// can be turned off with PIPELINE_SKIP=trickplay,notify
+func stepEnabled(step pipelineStep) bool {
+	for _, name := range strings.Split(os.Getenv("PIPELINE_SKIP"), ",") {
+		if strings.TrimSpace(name) == step.name {
+			return false
+		}
+	}
+	return step.enabled == nil || step.enabled()
+}
+
+// runPipeline runs the enabled steps, or only the named ones even if
+// disabled, and returns their statuses
+func runPipeline(job *utils.Job, m *pipelineMedia, only ...string) map[string]utils.StepStatus {
+	results := map[string]utils.StepStatus{}
+	for _, step := range pipelineSteps {
+		if len(only) > 0 && !slices.Contains(only, step.name) {
+			continue
+		}
+		if job.Context().Err() != nil {
+			break
+		}
+		if len(only) == 0 && !stepEnabled(step) {
+			results[step.name] = recordStep(m, step.name, utils.StepStatus{Status: utils.STEP_SKIPPED, Message: "disabled"})
+			continue
+		}
+		results[step.name] = runPipelineStep(job, m, step)
+	}
+	return results
+}
+
+func runPipelineStep(job *utils.Job, m *pipelineMedia, step pipelineStep) utils.StepStatus {
+	job.StartStep(step.name, m.title())
+	attempts := m.processing()[step.name].Attempts + 1
+	recordStep(m, step.name, utils.StepStatus{Status: utils.STEP_RUNNING, Attempts: attempts})
+
+	ctx, cancel := context.WithTimeout(job.Context(), step.timeout)
+	err := step.run(ctx, job, m)
+	cancel()
+
+	status := utils.StepStatus{Status: utils.STEP_DONE, Attempts: attempts}
+	var skipped stepSkipped
+	if errors.As(err, &skipped) {
+		status.Status, status.Message = utils.STEP_SKIPPED, skipped.Error()
+		job.Log(step.name + " skipped: " + skipped.Error())
+	} else if err != nil {
+		status.Status, status.Message = utils.STEP_FAILED, err.Error()
+		job.Log(step.name + " failed: " + err.Error())
+	}
+	return recordStep(m, step.name, status)
+}
+
+// recordStep saves the status of a step on the media document
+func recordStep(m *pipelineMedia, name string, status utils.StepStatus) utils.StepStatus {
+	if status.Attempts == 0 {
+		status.Attempts = m.processing()[name].Attempts
+	}
+	status.Updated = primitive.NewDateTimeFromTime(time.Now())
+	m.processing()[name] = status
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.set(ctx, bson.M{"processing." + name: status}); err != nil {
+		fmt.Println("Pipeline status error:", err)
+	}
+	return status
+}
+
+// startPipeline runs the whole pipeline of freshly ingested media in the
+// background, one media after the other. It returns the job ID.
+func startPipeline(title string, media ...*pipelineMedia) string {
+	job := utils.NewJob("pipeline", title)
+	go func() {
+		job.Start()
+		results := map[string]map[string]utils.StepStatus{}
+		for _, m := range media {
+			if job.Context().Err() != nil {
+				break
+			}
+			results[m.id().Hex()] = runPipeline(job, m)
+		}
+		job.Finish(results)
+	}()
+	return job.ID
+}
+
+// refreshFileSteps runs the enabled file steps again in the background after
+// the default file of a media changed. It returns the job ID.
+func refreshFileSteps(kind string, id primitive.ObjectID) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	m, err := loadPipelineMedia(ctx, kind, bson.M{"_id": id})
+	cancel()
+	if err != nil {
+		fmt.Println("Pipeline refresh error:", err)
+		return ""
+	}
+	removeTrickplay(kind, m.cacheKey())
+
+	names := []string{}
+	for _, step := range pipelineSteps {
+		if step.file && stepEnabled(step) {
+			names = append(names, step.name)
+		}
+	}
+	job := utils.NewJob("pipeline", m.title())
+	go func() {
+		job.Start()
+		job.Finish(runPipeline(job, m, names...))
+	}()
+	return job.ID
+}
+
+// --- STEPS ---
+
+// probeStep stores the codecs, resolution and duration of the file
+func probeStep(ctx context.Context, job *utils.Job, m *pipelineMedia) error {
+	info, err := utils.ProbeFile(ctx, m.filePath())
+	if errors.Is(err, utils.ErrProbeUnavailable) {
+		return stepSkipped(err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	fields := bson.M{"mediaInfo": info}
+	runtime := int(math.Round(info.Duration / 60))
+	if m.kind == "movie" {
+		m.movie.MediaInfo = &info
+		if m.movie.Runtime == 0 && runtime > 0 {
+			m.movie.Runtime, fields["runtime"] = runtime, runtime
+		}
+	} else {
+		m.episode.MediaInfo = &info
+		if m.episode.Runtime == 0 && runtime > 0 {
+			m.episode.Runtime, fields["runtime"] = runtime, runtime
+		}
+	}
+	return m.set(ctx, fields)
+}
+
+// metadataStep fills overview, genres and cast (movies) or overview and air
+// date (episodes) from TMDB
+func metadataStep(ctx context.Context, job *utils.Job, m *pipelineMedia) error {
+	if m.kind == "movie" {
+		if m.movie.TmdbID == 0 {
+			return stepSkipped("no TMDB id")
+		}
+		details, err := utils.GetTmdbMovie(ctx, m.movie.TmdbID)
+		if errors.Is(err, utils.ErrTmdbDisabled) {
+			return stepSkipped(err.Error())
+		}
+		if err != nil {
+			return err
+		}
+		genres := []string{}
+		for _, g := range details.Genres {
+			genres = append(genres, g.Name)
+		}
+		cast := []string{}
+		for _, actor := range details.Credits.Cast {
+			if len(cast) == 10 {
+				break
+			}
+			cast = append(cast, actor.Name)
+		}
+		m.movie.Overview, m.movie.Genres, m.movie.Cast = details.Overview, genres, cast
+		fields := bson.M{"overview": details.Overview, "genres": genres, "cast": cast}
+		if len(m.movie.Certifications) == 0 {
+			// Certifications edited by an admin are kept
+			for k, v := range utils.CertificationFields(details.Certifications()) {
+				fields[k] = v
+			}
+		}
+		if details.Runtime > 0 {
+			m.movie.Runtime, fields["runtime"] = details.Runtime, details.Runtime
+		}
+		return m.set(ctx, fields)
+	}
+
+	if m.series.TmdbID == 0 {
+		return stepSkipped("no TMDB id")
+	}
+	// Certifications are fetched once per series
+	if m.series.Certifications == nil {
+		certs, err := utils.GetTmdbShowCertifications(ctx, m.series.TmdbID)
+		if errors.Is(err, utils.ErrTmdbDisabled) {
+			return stepSkipped(err.Error())
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := utils.GetCollection("series").UpdateOne(ctx, bson.M{"_id": m.series.ID}, bson.M{"$set": utils.CertificationFields(certs)}); err != nil {
+			return err
+		}
+		m.series.Certifications = certs
+	}
+	season, err := utils.GetTmdbSeason(ctx, m.series.TmdbID, m.episode.SeasonNumber)
+	if errors.Is(err, utils.ErrTmdbDisabled) {
+		return stepSkipped(err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	for _, ep := range season.Episodes {
+		if ep.EpisodeNumber != m.episode.EpisodeNumber {
+			continue
+		}
+		m.episode.Overview, m.episode.AirDate = ep.Overview, ep.AirDate
+		fields := bson.M{"overview": ep.Overview, "airDate": ep.AirDate}
+		if m.episode.Runtime == 0 && ep.Runtime > 0 {
+			m.episode.Runtime, fields["runtime"] = ep.Runtime, ep.Runtime
+		}
+		return m.set(ctx, fields)
+	}
+	return stepSkipped("episode not listed by TMDB")
+}
+
+// artworkStep downloads the movie poster, or the series poster once for all
+// its episodes
+func artworkStep(ctx context.Context, job *utils.Job, m *pipelineMedia) error {
+	kind, tmdbID, poster := "movie", m.movie.TmdbID, m.movie.Poster
+	if m.kind == "episode" {
+		kind, tmdbID, poster = "series", m.series.TmdbID, m.series.Poster
+		if _, err := os.Stat(utils.ArtworkPath(kind, tmdbID)); err == nil {
+			return nil
+		}
+	}
+	if poster == "" || tmdbID == 0 {
+		return stepSkipped("no poster")
+	}
+	_, err := utils.DownloadPoster(ctx, kind, tmdbID, poster)
+	return err
+}
+
+// chaptersStep stores the chapters of the file, served by /video/:id/chapters
+func chaptersStep(ctx context.Context, job *utils.Job, m *pipelineMedia) error {
+	chapters, err := ffprobeChapters(m.filePath())
+	if errors.Is(err, exec.ErrNotFound) {
+		return stepSkipped("ffprobe not found")
+	}
+	if err != nil {
+		return err
+	}
+	if m.kind == "movie" {
+		m.movie.Chapters = chapters
+	} else {
+		m.episode.Chapters = chapters
+	}
+	return m.set(ctx, bson.M{"chapters": chapters})
+}
+
+// Trickplay sprites: one thumbnail every TRICKPLAY_INTERVAL seconds, tiled in
+// TRICKPLAY_COLUMNS x TRICKPLAY_COLUMNS sheets
+const (
+	TRICKPLAY_INTERVAL = 10
+	TRICKPLAY_WIDTH    = 320
+	TRICKPLAY_COLUMNS  = 10
+)
+
+// trickplayIndex is written as index.json next to the sprites
+type trickplayIndex struct {
+	Interval int      `json:"interval"` // Seconds between thumbnails
+	Width    int      `json:"width"`
+	Height   int      `json:"height"` // 0 when unknown
+	Columns  int      `json:"columns"`
+	Rows     int      `json:"rows"`
+	Sprites  []string `json:"sprites"`
+}
+
+// trickplayDir returns the folder holding the seek thumbnails of a media
+func trickplayDir(typeMedia, id string) string {
+	base := os.Getenv("TRICKPLAY_DIR")
+	if base == "" {
+		base = "./trickplay_cache"
+	}
+	return filepath.Join(base, typeMedia, id)
+}
+
+// removeTrickplay drops the thumbnails of a replaced or deleted file
+func removeTrickplay(typeMedia, id string) {
+	if err := os.RemoveAll(trickplayDir(typeMedia, id)); err != nil {
+		fmt.Println("Trickplay cleanup error:", err)
+	}
+}
+
+// trickplayStep generates the seek bar thumbnails. Only keyframes are decoded,
+// which is much faster than a full decode and precise enough for previews.
+func trickplayStep(ctx context.Context, job *utils.Job, m *pipelineMedia) error {
+	outDir := trickplayDir(m.kind, m.cacheKey())
+	if err := os.MkdirAll(filepath.Dir(outDir), 0o755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(outDir), ".trickplay-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y",
+		"-hide_banner", "-loglevel", "error",
+		"-skip_frame", "nokey",
+		"-i", m.filePath(),
+		"-an", "-sn",
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:-2,tile=%dx%d", TRICKPLAY_INTERVAL, TRICKPLAY_WIDTH, TRICKPLAY_COLUMNS, TRICKPLAY_COLUMNS),
+		"-q:v", "5",
+		filepath.Join(tmpDir, "sprite_%03d.jpg"),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return stepSkipped("ffmpeg not found")
+		}
+		return fmt.Errorf("ffmpeg: %v %s", err, strings.TrimSpace(string(out)))
+	}
+
+	sprites, _ := filepath.Glob(filepath.Join(tmpDir, "sprite_*.jpg"))
+	index := trickplayIndex{Interval: TRICKPLAY_INTERVAL, Width: TRICKPLAY_WIDTH, Columns: TRICKPLAY_COLUMNS, Rows: TRICKPLAY_COLUMNS, Sprites: []string{}}
+	for _, s := range sprites {
+		index.Sprites = append(index.Sprites, filepath.Base(s))
+	}
+	if info := m.mediaInfo(); info != nil && info.Width > 0 {
+		index.Height = int(math.Round(float64(TRICKPLAY_WIDTH)*float64(info.Height)/float64(info.Width)/2)) * 2
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "index.json"), data, 0o644); err != nil {
+		return err
+	}
+
+	// Swap the whole folder so players never see half a set of sprites
+	if err := os.RemoveAll(outDir); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, outDir)
+}
+
+// hlsStep pre-generates the HLS stream instead of waiting for the first play
+func hlsStep(ctx context.Context, job *utils.Job, m *pipelineMedia) error {
+	return ensureHLS(m.filePath(), hlsCacheDir(m.kind, m.cacheKey()), -1)
+}
+
+// notifyStep posts the new media to NOTIFY_WEBHOOK_URL
+func notifyStep(ctx context.Context, job *utils.Job, m *pipelineMedia) error {
+	payload := gin.H{"event": "media.added", "type": m.kind, "id": m.id(), "title": m.title()}
+	if m.kind == "movie" {
+		payload["tmdbID"] = m.movie.TmdbID
+		payload["library"] = m.movie.Library
+	} else {
+		payload["tmdbID"] = m.series.TmdbID
+		payload["library"] = m.series.Library
+		payload["seriesID"] = m.series.ID
+		payload["seasonNumber"] = m.episode.SeasonNumber
+		payload["episodeNumber"] = m.episode.EpisodeNumber
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, os.Getenv("NOTIFY_WEBHOOK_URL"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// --- HANDLERS ---
+
+// POST /movie/:id/pipeline/:step - run one pipeline step again (?async=true)
+func RunMoviePipelineStep(c *gin.Context) { runPipelineStepHandler(c, "movie") }
+
+// POST /episode/:id/pipeline/:step - same as RunMoviePipelineStep for an episode
+func RunEpisodePipelineStep(c *gin.Context) { runPipelineStepHandler(c, "episode") }
+
+func runPipelineStepHandler(c *gin.Context, kind string) {
+	step, ok := findPipelineStep(c.Param("step"))
+	if !ok {
+		names := []string{}
+		for _, s := range pipelineSteps {
+			names = append(names, s.name)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown step", "steps": names})
+		return
+	}
+
+	var filter bson.M
+	if kind == "movie" {
+		f, err := mediaFilter(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter = f
+	} else {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode id"})
+			return
+		}
+		filter = bson.M{"_id": objID}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	m, err := loadPipelineMedia(ctx, kind, filter)
+	cancel()
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	job := utils.NewJob("pipeline", step.name+": "+m.title())
+	run := func() utils.StepStatus {
+		job.Start()
+		status := runPipeline(job, m, step.name)[step.name]
+		if status.Status == utils.STEP_FAILED {
+			job.Fail(errors.New(status.Message))
+		} else {
+			job.Finish(gin.H{"step": step.name, "status": status})
+		}
+		return status
+	}
+
+	if wantsAsync(c) {
+		go run()
+		c.JSON(http.StatusAccepted, gin.H{"jobId": job.ID, "job": job.Snapshot()})
+		return
+	}
+	status := run()
+	if status.Status == utils.STEP_FAILED {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": status.Message, "step": step.name, "status": status, "jobId": job.ID})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"step": step.name, "status": status, "jobId": job.ID})
+}
+
+// GET /trickplay/:type/:id/*asset - seek thumbnails (index.json and sprites)
+func TrickplayAsset(c *gin.Context) {
+	typeMedia := c.Param("type")
+	if typeMedia != "movie" && typeMedia != "episode" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !allowPlayback(c, typeMedia, c.Param("id")) {
+		return
+	}
+	outDir := trickplayDir(typeMedia, filepath.Base(c.Param("id")))
+	asset := strings.TrimPrefix(c.Param("asset"), "/")
+	if asset == "" {
+		asset = "index.json"
+	}
+	path := filepath.Join(outDir, filepath.Clean(asset))
+	if !strings.HasPrefix(path, outDir+string(filepath.Separator)) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.File(path)
+}
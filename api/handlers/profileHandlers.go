@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Picture formats accepted for avatars, by sniffed content type
+var avatarExts = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// loadUser loads the :id profile, writing the error response itself
+func loadUser(c *gin.Context, ctx context.Context) (utils.User, bool) {
+	var user utils.User
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalide"})
+		return user, false
+	}
+	if err := utils.GetCollection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Utilisateur non trouvé"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return user, false
+	}
+	return user, true
+}
+
+// GET /avatars - presets a profile can pick
+func GetAvatarPresets(c *gin.Context) {
+	presets := []gin.H{}
+	for _, p := range utils.AvatarPresets {
+		presets = append(presets, gin.H{"key": p.Key, "color": p.Color, "url": "/avatars/" + p.Key})
+	}
+	c.JSON(http.StatusOK, presets)
+}
+
+// GET /avatars/:key?name= - a preset, with the initial of name
+func AvatarPresetHandler(c *gin.Context) {
+	preset, ok := utils.FindAvatarPreset(c.Param("key"))
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "image/svg+xml", utils.AvatarSVG(preset, c.Query("name")))
+}
+
+// GET /users/:id/avatar - uploaded picture or preset of a profile
+func UserAvatarHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	user, ok := loadUser(c, ctx)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	if user.Avatar == utils.AVATAR_CUSTOM && user.AvatarFile != "" {
+		path := filepath.Join(utils.AvatarDir(), user.AvatarFile)
+		if _, err := os.Stat(path); err == nil {
+			c.File(path)
+			return
+		}
+	}
+	preset, ok := utils.FindAvatarPreset(user.Avatar)
+	if !ok {
+		preset = utils.DefaultAvatarPreset(user.ID)
+	}
+	c.Data(http.StatusOK, "image/svg+xml", utils.AvatarSVG(preset, user.Name))
+}
+
+// PUT /users/:id/avatar - {preset} in JSON, or a picture as the "file" form
+// field (PNG, JPEG, GIF or WebP, up to 2 MB)
+func UpdateUserAvatar(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	user, ok := loadUser(c, ctx)
+	if !ok || !requireSelf(c, user.ID) {
+		return
+	}
+
+	set := bson.M{}
+	if fh, err := c.FormFile("file"); err == nil {
+		if fh.Size > utils.AVATAR_MAX_SIZE {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "avatar is larger than 2 MB"})
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, utils.AVATAR_MAX_SIZE+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ext, ok := avatarExts[http.DetectContentType(data)]
+		if !ok {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "avatar must be a PNG, JPEG, GIF or WebP picture"})
+			return
+		}
+
+		if err := os.MkdirAll(utils.AvatarDir(), 0755); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		utils.RemoveAvatarFiles(user.ID)
+		name := user.ID.Hex() + ext
+		if err := os.WriteFile(filepath.Join(utils.AvatarDir(), name), data, 0644); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		set["avatar"], set["avatarFile"] = utils.AVATAR_CUSTOM, name
+	} else {
+		var input struct {
+			Preset string `json:"preset" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a preset or a file"})
+			return
+		}
+		if _, ok := utils.FindAvatarPreset(input.Preset); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown avatar preset %q", input.Preset)})
+			return
+		}
+		utils.RemoveAvatarFiles(user.ID)
+		set["avatar"], set["avatarFile"] = input.Preset, ""
+	}
+
+	if _, err := utils.GetCollection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": set}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"avatar": set["avatar"], "url": "/users/" + user.ID.Hex() + "/avatar"})
+}
+
+// GET /users/:id/preferences
+func GetUserPreferences(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	user, ok := loadUser(c, ctx)
+	if !ok || !requireSelf(c, user.ID) {
+		return
+	}
+	c.JSON(http.StatusOK, user.Preferences)
+}
+
+// PATCH /users/:id/preferences - only the fields sent are changed
+func PatchUserPreferences(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	user, ok := loadUser(c, ctx)
+	if !ok || !requireSelf(c, user.ID) {
+		return
+	}
+
+	// Decoding over the current preferences keeps the fields not sent
+	prefs := user.Preferences
+	if err := c.ShouldBindJSON(&prefs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := prefs.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := utils.GetCollection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"preferences": prefs}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// GET /playback/:type/:id?version= - how the user should play a movie (by
+// tmdbID) or an episode: the version for this client, the audio and
+// subtitle tracks matching their preferences, the HLS URL streaming them and
+// the player settings. Tracks are null when the file wasn't probed.
+func GetPlaybackDecision(c *gin.Context) {
+	kind, id := c.Param("type"), c.Param("id")
+	if kind != "movie" && kind != "episode" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown media type"})
+		return
+	}
+	if !allowPlayback(c, kind, id) {
+		return
+	}
+
+	ctx, cancel := getDBContext()
+	defer cancel()
+	var media struct {
+		FilePath  string               `bson:"filePath"`
+		Versions  []utils.MediaVersion `bson:"versions"`
+		MediaInfo *utils.ProbeResult   `bson:"mediaInfo"`
+	}
+	var err error
+	if kind == "movie" {
+		tmdbID, _ := strconv.Atoi(id)
+		err = utils.GetCollection("movies").FindOne(ctx, bson.M{"tmdbID": tmdbID}).Decode(&media)
+	} else {
+		objID, convErr := primitive.ObjectIDFromHex(id)
+		if convErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode id"})
+			return
+		}
+		err = utils.GetCollection("episodes").FindOne(ctx, bson.M{"_id": objID}).Decode(&media)
+	}
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": kind + " not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	version, ok := selectMediaVersion(c, media.FilePath, media.Versions)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+	prefs := utils.DefaultPreferences()
+	if user, ok := currentUser(c); ok {
+		prefs = user.Preferences
+	}
+	// Tracks are only known for the probed default file
+	var info *utils.ProbeResult
+	if version.FilePath == media.FilePath {
+		info = media.MediaInfo
+	}
+	audio, subtitle := utils.ChooseTracks(info, prefs)
+
+	// The HLS stream transcoding this version with this audio track
+	hls := url.Values{}
+	if !version.ID.IsZero() {
+		hls.Set("version", version.ID.Hex())
+	}
+	if audio != nil {
+		hls.Set("audio", strconv.Itoa(audio.Index))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hls":           withQuery("/hls/"+kind+"/"+id+"/master.m3u8", hls.Encode()),
+		"version":       version,
+		"audioTrack":    audio,
+		"subtitleTrack": subtitle,
+		"subtitleStyle": prefs.SubtitleStyle,
+		"autoplayNext":  prefs.AutoplayNext,
+	})
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Users rate movies, series and episodes from 1 to 10 or with thumbs. The
+// household average is kept on the media as householdRating.
+
+// ratedMedia resolves the :type/:id of a ratings route to a media the user
+// may watch, writing the error response itself
+func ratedMedia(c *gin.Context, ctx context.Context) (string, primitive.ObjectID, bool) {
+	typ, id := c.Param("type"), c.Param("id")
+	switch typ {
+	case "movie":
+		movie, ok := findMovie(c, ctx, id)
+		return typ, movie.ID, ok
+	case "series":
+		series, ok := findSeries(c, ctx, id)
+		return typ, series.ID, ok
+	case "episode":
+		episode, ok := findEpisode(c, ctx, id)
+		return typ, episode.ID, ok
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "unknown media type"})
+	return typ, primitive.NilObjectID, false
+}
+
+// PUT /ratings/:type/:id {score | thumb, note} - rate a movie (ObjectID or
+// tmdbID), a series (same) or an episode
+func RateMedia(c *gin.Context) {
+	var input struct {
+		Score int    `json:"score"`
+		Thumb string `json:"thumb"`
+		Note  string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rating := utils.UserRating{Score: input.Score, Thumb: input.Thumb, Note: input.Note}
+	if err := utils.ValidateRating(&rating); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := getDBContext()
+	defer cancel()
+	typ, mediaID, ok := ratedMedia(c, ctx)
+	if !ok {
+		return
+	}
+
+	set := bson.M{"score": rating.Score, "updated": time.Now()}
+	unset := bson.M{}
+	for field, value := range map[string]string{"thumb": rating.Thumb, "note": rating.Note} {
+		if value == "" {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	update := bson.M{"$set": set, "$setOnInsert": bson.M{"_id": primitive.NewObjectID()}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	err := utils.GetCollection("ratings").FindOneAndUpdate(ctx,
+		bson.M{"user": userID, "type": typ, "media": mediaID}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&rating)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := utils.UpdateRatingSummary(ctx, typ, mediaID); err != nil {
+		fmt.Println("Rating summary error:", err)
+	}
+	c.JSON(http.StatusOK, rating)
+}
+
+// DELETE /ratings/:type/:id - remove the user's rating
+func DeleteRating(c *gin.Context) {
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := getDBContext()
+	defer cancel()
+	typ, mediaID, ok := ratedMedia(c, ctx)
+	if !ok {
+		return
+	}
+	res, err := utils.GetCollection("ratings").DeleteOne(ctx, bson.M{"user": userID, "type": typ, "media": mediaID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if res.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rating not found"})
+		return
+	}
+	if err := utils.UpdateRatingSummary(ctx, typ, mediaID); err != nil {
+		fmt.Println("Rating summary error:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": 1})
+}
+
+// GET /ratings/:type/:id - the user's rating, the household average and the
+// ratings of every profile with their notes, most recent first
+func GetMediaRatings(c *gin.Context) {
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := getDBContext()
+	defer cancel()
+	typ, mediaID, ok := ratedMedia(c, ctx)
+	if !ok {
+		return
+	}
+
+	cursor, err := utils.GetCollection("ratings").Find(ctx, bson.M{"type": typ, "media": mediaID},
+		options.Find().SetSort(bson.D{{Key: "updated", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var ratings []utils.UserRating
+	if err := cursor.All(ctx, &ratings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	userIDs := make([]primitive.ObjectID, len(ratings))
+	for i, r := range ratings {
+		userIDs[i] = r.UserID
+	}
+	names := map[primitive.ObjectID]string{}
+	if err := loadByIDs(ctx, "users", userIDs, func(u utils.User) { names[u.ID] = u.Name }); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var mine *utils.UserRating
+	var summary *utils.RatingSummary
+	total := 0
+	reviews := []gin.H{}
+	for i, r := range ratings {
+		if r.UserID == userID {
+			mine = &ratings[i]
+		}
+		total += r.Score
+		reviews = append(reviews, gin.H{
+			"user": r.UserID, "name": names[r.UserID], "score": r.Score,
+			"thumb": r.Thumb, "note": r.Note, "updated": r.Updated,
+		})
+	}
+	if len(ratings) > 0 {
+		summary = &utils.RatingSummary{Average: utils.RoundRating(float64(total) / float64(len(ratings))), Count: len(ratings)}
+	}
+	c.JSON(http.StatusOK, gin.H{"mine": mine, "household": summary, "reviews": reviews})
+}
+
+// GET /ratings?type=movie|series|episode - the user's ratings, most recent first
+func GetMyRatings(c *gin.Context) {
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	filter := bson.M{"user": userID}
+	if typ := c.Query("type"); typ != "" {
+		if _, err := utils.RatedCollection(typ); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter["type"] = typ
+	}
+	ctx, cancel := getDBContext()
+	defer cancel()
+	cursor, err := utils.GetCollection("ratings").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "updated", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var ratings []utils.UserRating
+	if err := cursor.All(ctx, &ratings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Ratings left by media deleted without cleanup aren't listed
+	ids := map[string][]primitive.ObjectID{}
+	for _, r := range ratings {
+		ids[r.Type] = append(ids[r.Type], r.MediaID)
+	}
+	type media struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	exists := map[primitive.ObjectID]bool{}
+	for typ, mediaIDs := range ids {
+		coll, err := utils.RatedCollection(typ)
+		if err != nil {
+			continue
+		}
+		if err := loadByIDs(ctx, coll, mediaIDs, func(m media) { exists[m.ID] = true }); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	out := make([]utils.UserRating, 0, len(ratings))
+	for _, r := range ratings {
+		if exists[r.MediaID] {
+			out = append(out, r)
+		}
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// purgeRatings removes the ratings of deleted media (typ "movie" | "series" |
+// "episode"), their averages went with them
+func purgeRatings(ctx context.Context, typ string, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := utils.GetCollection("ratings").DeleteMany(ctx, bson.M{"type": typ, "media": bson.M{"$in": ids}})
+	return err
+}
+
+// deleteUserRatings removes the ratings of a deleted user and updates the
+// averages they counted in
+func deleteUserRatings(ctx context.Context, userID primitive.ObjectID) error {
+	coll := utils.GetCollection("ratings")
+	cursor, err := coll.Find(ctx, bson.M{"user": userID})
+	if err != nil {
+		return err
+	}
+	var ratings []utils.UserRating
+	if err := cursor.All(ctx, &ratings); err != nil {
+		return err
+	}
+	if _, err := coll.DeleteMany(ctx, bson.M{"user": userID}); err != nil {
+		return err
+	}
+	for _, r := range ratings {
+		if err := utils.UpdateRatingSummary(ctx, r.Type, r.MediaID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
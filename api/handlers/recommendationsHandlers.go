@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fields of the catalogue the rows don't need
+var recommendationProjection = bson.M{"versions": 0, "mediaInfo": 0, "chapters": 0, "processing": 0, "filePath": 0, "overview": 0}
+
+// recommendationRow is a row of the home screen. Rows without items are left out.
+type recommendationRow struct {
+	Key    string  `json:"key"` // Stable, for the client to pick a translated title
+	Title  string  `json:"title"`
+	Source gin.H   `json:"source,omitempty"` // The movie a "because you watched" row comes from
+	Items  []gin.H `json:"items"`
+}
+
+func movieItem(m utils.Movie) gin.H {
+	return gin.H{"type": "movie", "id": m.ID, "tmdbID": m.TmdbID, "title": m.Title, "poster": m.Poster, "genres": m.Genres, "householdRating": m.HouseholdRating}
+}
+
+func seriesItem(s utils.Series) gin.H {
+	return gin.H{"type": "series", "id": s.ID, "tmdbID": s.TmdbID, "title": s.Title, "poster": s.Poster, "householdRating": s.HouseholdRating}
+}
+
+// findCatalogue loads the visible movies or series (coll) into out
+func findCatalogue(c *gin.Context, ctx context.Context, coll string, out any) error {
+	filter := bson.M{}
+	restrictCatalogue(c, filter, "")
+	cursor, err := utils.GetCollection(coll).Find(ctx, filter, options.Find().SetProjection(recommendationProjection))
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, out)
+}
+
+// GET /recommendations?limit= - personalised rows for the user: because you
+// watched, top rated in the household, favourite genres, new episodes of
+// followed series and rediscoveries. limit is the size of each row.
+func GetRecommendations(c *gin.Context) {
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = utils.RECOMMENDATION_ROW_SIZE
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var movies []utils.Movie
+	var seriesList []utils.Series
+	var history []utils.WatchRecord
+	var ratings []utils.UserRating
+	if err := findCatalogue(c, ctx, "movies", &movies); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := findCatalogue(c, ctx, "series", &seriesList); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	cursor, err := utils.GetCollection("watch_history").Find(ctx, bson.M{"user": userID, "watched": true},
+		options.Find().SetSort(bson.D{{Key: "lastWatched", Value: -1}}))
+	if err == nil {
+		err = cursor.All(ctx, &history)
+	}
+	if err == nil {
+		cursor, err = utils.GetCollection("ratings").Find(ctx, bson.M{"user": userID, "type": "movie"})
+	}
+	if err == nil {
+		err = cursor.All(ctx, &ratings)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// What the user watched, most recent first
+	movieByID := map[primitive.ObjectID]utils.Movie{}
+	for _, m := range movies {
+		movieByID[m.ID] = m
+	}
+	watchedMovies := map[primitive.ObjectID]time.Time{}
+	watchedEpisodes := map[primitive.ObjectID]bool{}
+	followed := map[primitive.ObjectID]time.Time{} // Series -> last episode watched
+	recent := []utils.Movie{}
+	for _, r := range history {
+		if r.Type == "movie" {
+			watchedMovies[r.MediaID] = r.LastWatched
+			if m, ok := movieByID[r.MediaID]; ok {
+				recent = append(recent, m)
+			}
+			continue
+		}
+		watchedEpisodes[r.MediaID] = true
+		if r.LastWatched.After(followed[r.SeriesID]) {
+			followed[r.SeriesID] = r.LastWatched
+		}
+	}
+	scores := map[primitive.ObjectID]int{}
+	for _, r := range ratings {
+		scores[r.MediaID] = r.Score
+	}
+	unwatched := []utils.Movie{}
+	for _, m := range movies {
+		if _, seen := watchedMovies[m.ID]; !seen {
+			unwatched = append(unwatched, m)
+		}
+	}
+
+	rows := []recommendationRow{}
+	add := func(row recommendationRow) {
+		if len(row.Items) > limit {
+			row.Items = row.Items[:limit]
+		}
+		if len(row.Items) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	// Because you watched: movies close to the last ones watched
+	for _, source := range recent[:min(len(recent), utils.BECAUSE_YOU_WATCHED_ROWS)] {
+		add(recommendationRow{
+			Key:    "because_you_watched",
+			Title:  "Because you watched " + source.Title,
+			Source: movieItem(source),
+			Items:  similarMovies(source, unwatched),
+		})
+	}
+
+	add(recommendationRow{Key: "top_rated", Title: "Top rated at home", Items: topRated(movies, seriesList)})
+
+	// Unwatched movies of the favourite genres
+	watchedList := make([]utils.Movie, 0, len(watchedMovies))
+	for id := range watchedMovies {
+		if m, ok := movieByID[id]; ok {
+			watchedList = append(watchedList, m)
+		}
+	}
+	if genres := utils.TopGenres(utils.GenreWeights(watchedList, scores), utils.FAVOURITE_GENRES); len(genres) > 0 {
+		add(recommendationRow{Key: "favourite_genres", Title: "More " + strings.Join(genres, ", "), Items: genreMovies(genres, unwatched)})
+	}
+
+	newEpisodes, err := newEpisodesRow(ctx, seriesList, followed, watchedEpisodes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	add(recommendationRow{Key: "new_episodes", Title: "New episodes", Items: newEpisodes})
+
+	add(recommendationRow{Key: "rediscover", Title: "Rediscover", Items: rediscoveries(userID, movies, seriesList, watchedMovies, followed)})
+
+	c.JSON(http.StatusOK, gin.H{"rows": rows})
+}
+
+// similarMovies sorts the candidates close to source, most similar first
+func similarMovies(source utils.Movie, candidates []utils.Movie) []gin.H {
+	type scored struct {
+		movie utils.Movie
+		score float64
+	}
+	matches := []scored{}
+	for _, m := range candidates {
+		if m.ID == source.ID {
+			continue
+		}
+		if s := utils.Similarity(source, m); s > 0 {
+			matches = append(matches, scored{m, s})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	items := make([]gin.H, len(matches))
+	for i, m := range matches {
+		items[i] = movieItem(m.movie)
+	}
+	return items
+}
+
+// topRated sorts the movies and series rated in the household, best average first
+func topRated(movies []utils.Movie, seriesList []utils.Series) []gin.H {
+	type rated struct {
+		item    gin.H
+		summary *utils.RatingSummary
+	}
+	all := []rated{}
+	for _, m := range movies {
+		if m.HouseholdRating != nil {
+			all = append(all, rated{movieItem(m), m.HouseholdRating})
+		}
+	}
+	for _, s := range seriesList {
+		if s.HouseholdRating != nil {
+			all = append(all, rated{seriesItem(s), s.HouseholdRating})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].summary.Average != all[j].summary.Average {
+			return all[i].summary.Average > all[j].summary.Average
+		}
+		return all[i].summary.Count > all[j].summary.Count
+	})
+	items := make([]gin.H, len(all))
+	for i, r := range all {
+		items[i] = r.item
+	}
+	return items
+}
+
+// genreMovies returns the candidates having the most of genres, then the
+// best rated and most recently added
+func genreMovies(genres []string, candidates []utils.Movie) []gin.H {
+	type scored struct {
+		movie   utils.Movie
+		matches int
+	}
+	matches := []scored{}
+	for _, m := range candidates {
+		n := 0
+		for _, g := range m.Genres {
+			for _, fav := range genres {
+				if g == fav {
+					n++
+				}
+			}
+		}
+		if n > 0 {
+			matches = append(matches, scored{m, n})
+		}
+	}
+	average := func(m utils.Movie) float64 {
+		if m.HouseholdRating == nil {
+			return 0
+		}
+		return m.HouseholdRating.Average
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.matches != b.matches {
+			return a.matches > b.matches
+		}
+		if average(a.movie) != average(b.movie) {
+			return average(a.movie) > average(b.movie)
+		}
+		return a.movie.Date > b.movie.Date
+	})
+	items := make([]gin.H, len(matches))
+	for i, m := range matches {
+		items[i] = movieItem(m.movie)
+	}
+	return items
+}
+
+// newEpisodesRow lists the followed series having episodes added since the
+// user last watched one, with the first of them, most recent additions first
+func newEpisodesRow(ctx context.Context, seriesList []utils.Series, followed map[primitive.ObjectID]time.Time, watched map[primitive.ObjectID]bool) ([]gin.H, error) {
+	ids := []primitive.ObjectID{}
+	since := time.Now()
+	for _, s := range seriesList {
+		if last, ok := followed[s.ID]; ok {
+			ids = append(ids, s.ID)
+			if last.Before(since) {
+				since = last
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	cursor, err := utils.GetCollection("episodes").Find(ctx,
+		bson.M{"seriesID": bson.M{"$in": ids}, "date": bson.M{"$gt": primitive.NewDateTimeFromTime(since)}},
+		options.Find().SetProjection(bson.M{"title": 1, "seriesID": 1, "seasonNumber": 1, "episodeNumber": 1, "date": 1}).
+			SetSort(bson.D{{Key: "seasonNumber", Value: 1}, {Key: "episodeNumber", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var episodes []utils.Episode
+	if err := cursor.All(ctx, &episodes); err != nil {
+		return nil, err
+	}
+
+	type fresh struct {
+		first  utils.Episode
+		count  int
+		latest primitive.DateTime
+	}
+	bySeries := map[primitive.ObjectID]*fresh{}
+	for _, ep := range episodes {
+		if watched[ep.ID] || ep.Date.Time().Before(followed[ep.SeriesID]) {
+			continue
+		}
+		f, ok := bySeries[ep.SeriesID]
+		if !ok {
+			f = &fresh{first: ep}
+			bySeries[ep.SeriesID] = f
+		}
+		f.count++
+		f.latest = max(f.latest, ep.Date)
+	}
+
+	matches := []utils.Series{}
+	for _, s := range seriesList {
+		if bySeries[s.ID] != nil {
+			matches = append(matches, s)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return bySeries[matches[i].ID].latest > bySeries[matches[j].ID].latest })
+	items := make([]gin.H, len(matches))
+	for i, s := range matches {
+		f := bySeries[s.ID]
+		item := seriesItem(s)
+		item["newEpisodes"] = f.count
+		item["episode"] = gin.H{"id": f.first.ID, "title": f.first.Title, "seasonNumber": f.first.SeasonNumber, "episodeNumber": f.first.EpisodeNumber}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// rediscoveries picks at random, once a day, among movies watched long ago
+// and titles added long ago that the user never started
+func rediscoveries(userID primitive.ObjectID, movies []utils.Movie, seriesList []utils.Series, watchedMovies, followed map[primitive.ObjectID]time.Time) []gin.H {
+	before := time.Now().Add(-utils.REDISCOVER_AFTER)
+	items := []gin.H{}
+	for _, m := range movies {
+		last, seen := watchedMovies[m.ID]
+		if (seen && last.Before(before)) || (!seen && m.Date.Time().Before(before)) {
+			items = append(items, movieItem(m))
+		}
+	}
+	for _, s := range seriesList {
+		if _, seen := followed[s.ID]; !seen && s.Date.Time().Before(before) {
+			items = append(items, seriesItem(s))
+		}
+	}
+	utils.DailyRand(userID).Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+	return items
+}
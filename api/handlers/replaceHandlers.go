@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// POST /movie/:id/file - swap the default file of an existing movie (better
+// rip), keeping its ID and users' progress. Form fields: file, keepOld ("true"
+// to keep the previous file as another version), and optional label, edition,
+// resolution, source describing the new file.
+func ReplaceMovieFile(c *gin.Context) { replaceFile(c, "movie") }
+
+// POST /episode/:id/file - same as ReplaceMovieFile for an episode
+func ReplaceEpisodeFile(c *gin.Context) { replaceFile(c, "episode") }
+
+func replaceFile(c *gin.Context, kind string) {
+	findCtx, findCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	coll, media, ok := loadVersionedMedia(c, findCtx, kind)
+	findCancel()
+	if !ok {
+		return
+	}
+
+	keepOld := c.PostForm("keepOld") == "true"
+	swap, err := replaceMediaFile(c, media.FilePath, keepOld)
+	newPath := swap.newPath
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	// The default version now points to the new file
+	versions := utils.MaterializeVersions(media.FilePath, media.Versions)
+	idx := findVersionIndex(versions, media.FilePath, "default")
+	if idx < 0 {
+		idx = 0
+	}
+	previous := versions[idx]
+	versions[idx] = utils.MediaVersion{
+		ID:         previous.ID,
+		Label:      firstNonEmpty(c.PostForm("label"), previous.Label),
+		Edition:    firstNonEmpty(c.PostForm("edition"), previous.Edition),
+		Resolution: c.PostForm("resolution"),
+		Source:     c.PostForm("source"),
+		FilePath:   newPath,
+		Format:     filepath.Ext(newPath),
+		Date:       primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if keepOld {
+		previous.ID = primitive.NewObjectID()
+		previous.Label = strings.TrimSpace(previous.Label + " (ancienne version)")
+		previous.FilePath = swap.aside
+		versions = append(versions, previous)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set := bson.M{"filePath": newPath, "versions": versions}
+	if kind == "movie" {
+		set["format"] = filepath.Ext(newPath)
+	}
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": media.ID}, bson.M{"$set": set}); err != nil {
+		swap.rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	swap.commit()
+
+	// Probe, chapters, thumbnails... describe the previous file
+	removeHLSCache(kind, media.hlsKey(kind))
+	jobID := refreshFileSteps(kind, media.ID)
+
+	c.JSON(http.StatusOK, gin.H{"id": media.ID, "filePath": newPath, "versions": versions, "jobId": jobID})
+}
+
+// fileSwap is a file replaced on disk. Until the DB points to the new file,
+// rollback puts the previous one back; commit then drops it unless kept.
+type fileSwap struct {
+	currentPath string
+	newPath     string
+	aside       string // Where the previous file was moved
+	keep        bool
+}
+
+func (s fileSwap) rollback() {
+	if err := os.Remove(s.newPath); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Replace rollback error:", err)
+	}
+	if err := os.Rename(s.aside, s.currentPath); err != nil {
+		fmt.Println("Replace rollback error:", err)
+	}
+}
+
+func (s fileSwap) commit() {
+	if s.keep {
+		return
+	}
+	if err := os.Remove(s.aside); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Replaced file cleanup error:", err)
+	}
+}
+
+// replaceMediaFile writes the uploaded "file" next to currentPath then swaps
+// it in with a rename, so players never see a partial file. The new file keeps
+// the current name with the uploaded extension. The current file is first
+// moved aside: to "<name>.old-<timestamp><ext>" with keepOld, else to a hidden
+// file removed by commit.
+func replaceMediaFile(c *gin.Context, currentPath string, keepOld bool) (fileSwap, error) {
+	swap := fileSwap{currentPath: currentPath, keep: keepOld}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		return swap, fmt.Errorf("failed to get file: %w", err)
+	}
+	defer file.Close()
+	if err := checkUploadFor(currentPath, header); err != nil {
+		return swap, err
+	}
+
+	dir := filepath.Dir(currentPath)
+	oldExt := videoExt(currentPath)
+	base := strings.TrimSuffix(filepath.Base(currentPath), oldExt)
+	ext := videoExt(header.Filename)
+	if ext == "" {
+		ext = oldExt
+	}
+	swap.newPath = filepath.Join(dir, base+ext)
+
+	tmp, err := writeTempFile(dir, file)
+	if err != nil {
+		return swap, err
+	}
+	if _, err := probeUpload(tmp, header.Filename); err != nil {
+		os.Remove(tmp)
+		return swap, err
+	}
+
+	stamp := time.Now().Format("20060102-150405")
+	swap.aside = filepath.Join(dir, fmt.Sprintf(".%s.replaced-%s%s", base, stamp, oldExt))
+	if keepOld {
+		swap.aside = filepath.Join(dir, fmt.Sprintf("%s.old-%s%s", base, stamp, oldExt))
+	}
+	if err := os.Rename(currentPath, swap.aside); err != nil {
+		os.Remove(tmp)
+		return swap, fmt.Errorf("failed to keep previous file: %w", err)
+	}
+
+	if err := os.Rename(tmp, swap.newPath); err != nil {
+		os.Remove(tmp)
+		_ = os.Rename(swap.aside, currentPath)
+		return swap, fmt.Errorf("failed to swap file: %w", err)
+	}
+	return swap, nil
+}
+
+// writeTempFile copies src into a hidden temporary file of dir and returns its path
+func writeTempFile(dir string, src io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	return tmp.Name(), nil
+}
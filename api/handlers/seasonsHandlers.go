@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GET /series/:id/seasons/:season - season record with its episodes
+func GetSeason(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("season"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid season number"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var season utils.Season
+	if err := utils.GetCollection("seasons").FindOne(ctx, bson.M{"seriesID": series.ID, "seasonNumber": number}).Decode(&season); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Season not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	if season.Name == "" {
+		season.Name = utils.DefaultSeasonName(number) // not fetched from TMDB yet
+	}
+
+	cursor, err := utils.GetCollection("episodes").Find(ctx,
+		bson.M{"seriesID": series.ID, "seasonNumber": number},
+		options.Find().SetSort(bson.D{{Key: "episodeNumber", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episodes"})
+		return
+	}
+	season.Episodes = []utils.Episode{}
+	if err := cursor.All(ctx, &season.Episodes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode episodes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, season)
+}
+
+// POST /series/:id/seasons/refresh - re-sync every season, refetching TMDB metadata
+func RefreshSeasons(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+	if err := utils.SyncSeriesSeasons(ctx, series, true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	seasons, err := utils.GetSeasons(ctx, series.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, seasons)
+}
+
+// syncSeasons keeps season records consistent after episodes of a series were
+// added, moved or removed. Errors are only logged: episodes are the source of truth.
+func syncSeasons(ctx context.Context, seriesID primitive.ObjectID, numbers ...int) {
+	var series utils.Series
+	if err := utils.GetCollection("series").FindOne(ctx, bson.M{"_id": seriesID}).Decode(&series); err != nil {
+		// Series deleted: drop its seasons
+		if err == mongo.ErrNoDocuments {
+			_, _ = utils.GetCollection("seasons").DeleteMany(ctx, bson.M{"seriesID": seriesID})
+		}
+		return
+	}
+	done := map[int]bool{}
+	for _, n := range numbers {
+		if done[n] {
+			continue
+		}
+		done[n] = true
+		if err := utils.SyncSeason(ctx, series, n, false); err != nil {
+			fmt.Println("Season sync error:", err)
+		}
+	}
+}
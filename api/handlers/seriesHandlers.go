@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -21,11 +20,13 @@ import (
 )
 
 type EpisodeMeta struct {
-	FileName      string `json:"fileName" binding:"required"`
-	Index         int    `json:"index" binding:"required"`
-	Title         string `json:"title" binding:"required"`
-	SeasonNumber  int    `json:"seasonNumber" binding:"required"`
-	EpisodeNumber int    `json:"episodeNumber" binding:"required"`
+	FileName         string `json:"fileName" binding:"required"`
+	Index            int    `json:"index" binding:"required"`
+	Title            string `json:"title" binding:"required"`
+	SeasonNumber     int    `json:"seasonNumber"` // 0 for specials
+	EpisodeNumber    int    `json:"episodeNumber" binding:"required"`
+	EpisodeNumberEnd int    `json:"episodeNumberEnd"` // Multi-episode file
+	AbsoluteNumber   int    `json:"absoluteNumber"`
 }
 
 type Metadata struct {
@@ -35,6 +36,8 @@ type Metadata struct {
 	IsDocu      string        `json:"isDocu" binding:"required"`
 	IsKids      string        `json:"isKids" binding:"required"`
 	CustomTitle string        `json:"customTitle" binding:"required"`
+	Library     string        `json:"library"`
+	Ordering    string        `json:"ordering"` // utils.SERIES_ORDERING_*
 	Episodes    []EpisodeMeta `json:"episodes"`
 }
 
@@ -62,30 +65,57 @@ func CreateSeries(c *gin.Context) {
 	}
 
 	// get files
-	files := form.File["files[]"]
+	files := []incomingFile{}
+	for _, fh := range form.File["files[]"] {
+		files = append(files, uploadedFile(fh))
+	}
+
+	episodes, job, ok := ingestSeries(c, metadata, files)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"status": "ok", "jobId": job.ID, "episodes": episodes})
+}
+
+// ingestSeries validates new episode files, matched with metadata.Episodes
+// by index, and hands them over to an ingest job, like ingestMovie
+func ingestSeries(c *gin.Context, metadata Metadata, files []incomingFile) ([]utils.Episode, *utils.Job, bool) {
 	if len(files) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no files provided"})
-		return
+		return nil, nil, false
 	}
 
 	// security
 	if len(metadata.Episodes) != len(files) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "episodes and files length mismatch"})
-		return
+		return nil, nil, false
 	}
 
 	// Find or create series by tmdbID
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	if err := checkOrdering(metadata.Ordering); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	lib, err := resolveUploadLibrary(metadata.Library, utils.LIBRARY_TYPE_SERIES, metadata.IsDocu == "true", metadata.IsKids == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid library: " + err.Error()})
+		return nil, nil, false
+	}
+
 	var series utils.Series
+	created := false
 	if findErr := utils.GetCollection("series").FindOne(ctx, bson.M{"tmdbID": metadata.TmdbID}).Decode(&series); findErr != nil {
 		if findErr != mongo.ErrNoDocuments {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error: " + findErr.Error()})
-			return
+			return nil, nil, false
 		}
 
-		// Create new series
+		// New series, only stored with its first episode (see runSeriesIngest)
+		created = true
 		series = utils.Series{
 			ID:          primitive.NewObjectID(),
 			Title:       firstNonEmpty(metadata.Title, fmt.Sprintf("Series %d", metadata.TmdbID)),
@@ -93,156 +123,188 @@ func CreateSeries(c *gin.Context) {
 			TmdbID:      metadata.TmdbID,
 			Poster:      metadata.Poster,
 			Date:        primitive.NewDateTimeFromTime(time.Now()),
+			Library:     lib.Key,
+			Ordering:    metadata.Ordering,
 		}
-		if _, err := utils.GetCollection("series").InsertOne(ctx, series); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create series: " + err.Error()})
-			return
-		}
+	} else if existing, err := utils.GetLibrary(series.Library); err == nil {
+		// Existing series keep their library, whatever the upload flags say
+		lib = existing
 	}
 
-	// Iterate files in order, match with epMetas by index
-	for index, fileheader := range files {
-		meta := metadata.Episodes[index]
+	// Validate every file before writing anything, on the root
+	// getDstForEpisode will pick
+	root, err := lib.PickRoot(seriesFolder(series))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	if err := checkUploadSize(lib, root, files...); err != nil {
+		respondUploadError(c, err)
+		return nil, nil, false
+	}
+	for _, file := range files {
+		if err := sniffUpload(file); err != nil {
+			respondUploadError(c, err)
+			return nil, nil, false
+		}
+	}
 
+	// Check the numbering of every file before staging anything
+	for index, meta := range metadata.Episodes {
 		// sanity check
-		if meta.SeasonNumber <= 0 || meta.EpisodeNumber <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid season/episode for file %d", index)})
-			return
+		if err := checkEpisodeNumbers(meta.SeasonNumber, meta.EpisodeNumber, meta.EpisodeNumberEnd, meta.AbsoluteNumber); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file %d: %v", index, err)})
+			return nil, nil, false
 		}
-
-		// Save temp file
-		src, err := fileheader.Open()
+		overlap, err := episodeOverlaps(ctx, series.ID, meta.SeasonNumber, meta.EpisodeNumber, meta.EpisodeNumberEnd, primitive.NilObjectID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to open file %s: %v", fileheader.Filename, err)})
-			return
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error: " + err.Error()})
+			return nil, nil, false
 		}
-		defer src.Close()
+		if overlap {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("file %d: episode already in library", index)})
+			return nil, nil, false
+		}
+	}
+
+	if !created && metadata.Ordering != "" && metadata.Ordering != series.Ordering {
+		if _, err := utils.GetCollection("series").UpdateOne(ctx, bson.M{"_id": series.ID}, bson.M{"$set": bson.M{"ordering": metadata.Ordering}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error: " + err.Error()})
+			return nil, nil, false
+		}
+		series.Ordering = metadata.Ordering
+	}
+
+	// Iterate files in order, match with epMetas by index, and keep them
+	// past this request for the ingest job
+	items := make([]episodeIngest, 0, len(files))
+	abort := func() {
+		for _, it := range items {
+			it.src.discard()
+			removeEmptyDirs(it.dst)
+		}
+	}
+	for index, file := range files {
+		meta := metadata.Episodes[index]
 
 		// get dst
-		dst, err := getDstForEpisode(meta, metadata, series)
+		dst, err := getDstForEpisode(meta, lib, series)
 		if err != nil {
+			abort()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to get destination path: %v", err)})
-			return
+			return nil, nil, false
 		}
-
-		// save file
-		out, err := os.Create(dst)
+		src, err := file.stage()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to create temp file: %v", err)})
-			return
-		}
-		if _, err := io.Copy(out, src); err != nil {
-			out.Close()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to write temp file: %v", err)})
-			return
-		}
-		out.Close()
-
-		// save episode in db
-		ep := utils.Episode{
-			ID:            primitive.NewObjectID(),
-			TmdbID:        series.TmdbID,
-			EpisodeNumber: meta.EpisodeNumber,
-			SeasonNumber:  meta.SeasonNumber,
-			SeriesID:      series.ID,
-			Title:         meta.Title,
-			FilePath:      dst,
-			Date:          primitive.NewDateTimeFromTime(time.Now()),
+			abort()
+			removeEmptyDirs(dst)
+			respondUploadError(c, err)
+			return nil, nil, false
 		}
+		items = append(items, episodeIngest{meta: meta, src: src, fileName: file.name, dst: dst})
+	}
 
-		if _, err := utils.GetCollection("episodes").InsertOne(ctx, ep); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create episode: " + err.Error()})
-			return
-		}
+	job := utils.NewJob("series", series.Title)
+	if wantsAsync(c) {
+		go runSeriesIngest(job, series, created, items)
+		c.JSON(http.StatusAccepted, gin.H{"jobId": job.ID, "job": job.Snapshot()})
+		return nil, job, false
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"status": "ok"})
+	episodes, err := runSeriesIngest(job, series, created, items)
+	if err != nil {
+		respondUploadError(c, err)
+		return episodes, job, false
+	}
+	return episodes, job, true
 }
 
-func getDstForEpisode(meta EpisodeMeta, metadata Metadata, series utils.Series) (string, error) {
+// getDstForEpisode returns where a new episode is written, following the
+// configured layout, and creates its folder
+func getDstForEpisode(meta EpisodeMeta, lib utils.Library, series utils.Series) (string, error) {
 	// get ext
-	ext := strings.ToLower(filepath.Ext(meta.FileName))
+	ext := videoExt(meta.FileName)
 	if ext == "" {
 		ext = ".mp4"
 	}
 
-	// get destination folder
-	fileName := fmt.Sprintf("%02d%02d - %s%s", meta.SeasonNumber, meta.EpisodeNumber, sanitizeName(meta.Title), ext)
-	var serieFolder string
-	if metadata.IsDocu == "true" {
-		serieFolder = filepath.Join(utils.SERIES_DOCU_DIR, series.CustomTitle)
-	} else if metadata.IsKids == "true" {
-		serieFolder = filepath.Join(utils.SERIES_KID_DIR, series.CustomTitle)
-	} else {
-		serieFolder = filepath.Join(utils.SERIES_DIR, series.CustomTitle)
+	ep := utils.Episode{
+		SeasonNumber:     meta.SeasonNumber,
+		EpisodeNumber:    meta.EpisodeNumber,
+		EpisodeNumberEnd: meta.EpisodeNumberEnd,
+		AbsoluteNumber:   meta.AbsoluteNumber,
+		Title:            meta.Title,
 	}
-
-	var dst string
-
-	// set hasExistingFiles
-	entries, err := os.ReadDir(serieFolder)
-	hasExistingFiles := err == nil && len(entries) > 0
-	fileCount := 0
-	if hasExistingFiles {
-		for _, entry := range entries {
-			if !entry.IsDir() {
-				fileCount++
-			}
-		}
-		hasExistingFiles = fileCount > 0
+	rel, err := utils.CurrentLayout().EpisodePath(series, ep, ext)
+	if err != nil {
+		return "", err
 	}
+	// Stay on the root already holding the series folder
+	root, err := lib.PickRoot(seriesFolder(series))
+	if err != nil {
+		return "", err
+	}
+	dst, err := utils.SafeJoin(root, rel)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
 
-	if hasExistingFiles {
-		if meta.SeasonNumber <= 1 {
-			// Saison 1 : fichier directement dans serieFolder
-			dst = filepath.Join(serieFolder, fileName)
-		} else {
-			season1Dir := filepath.Join(serieFolder, "Saison 1")
-			if err := os.MkdirAll(season1Dir, 0755); err != nil {
-				return "", err
-			}
-
-			// move everything into Saison 1
-			for _, entry := range entries {
-				if !entry.IsDir() {
-					oldPath := filepath.Join(serieFolder, entry.Name())
-					newPath := filepath.Join(season1Dir, entry.Name())
-					if err := os.Rename(oldPath, newPath); err != nil {
-						return "", err
-					}
-				}
-			}
-
-			// copy new file into Saison [X]
-			seasonDir := filepath.Join(serieFolder, fmt.Sprintf("Saison %d", meta.SeasonNumber))
-			if err := os.MkdirAll(seasonDir, 0755); err != nil {
-				return "", err
-			}
-			dst = filepath.Join(seasonDir, fileName)
-		}
-	} else {
-		// Pas de fichiers existants :  directement dans Saison [X]
-		seasonDir := filepath.Join(serieFolder, fmt.Sprintf("Saison %d", meta.SeasonNumber))
-		if err := os.MkdirAll(seasonDir, 0755); err != nil {
-			return "", err
-		}
-		dst = filepath.Join(seasonDir, fileName)
+// seriesFolder is the folder the layout gives to a series, the first level of
+// its episode paths, or "" when episodes lie directly in the library root
+func seriesFolder(series utils.Series) string {
+	rel, err := utils.CurrentLayout().EpisodePath(series, utils.Episode{SeasonNumber: 1, EpisodeNumber: 1, Title: "Episode"}, ".mkv")
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) < 2 {
+		return ""
 	}
+	return parts[0]
+}
 
-	return dst, nil
+// checkEpisodeNumbers validates the numbering of an episode, season 0 holding specials
+func checkEpisodeNumbers(season, episode, episodeEnd, absolute int) error {
+	if season < 0 || episode <= 0 || absolute < 0 {
+		return fmt.Errorf("invalid season/episode")
+	}
+	if episodeEnd != 0 && episodeEnd < episode {
+		return fmt.Errorf("episodeNumberEnd must be greater than episodeNumber")
+	}
+	return nil
 }
 
-// sanitizeName performs minimal filename sanitization
-func sanitizeName(name string) string {
-	n := strings.TrimSpace(name)
-	replacers := []string{"/", "_", "\\", "_", ":", " - ", "*", "-", "?", "", "\"", "", "'", "", "<", "", ">", "", "|", "-"}
-	for i := 0; i+1 < len(replacers); i += 2 {
-		n = strings.ReplaceAll(n, replacers[i], replacers[i+1])
+// checkOrdering validates the episode ordering of a series, empty meaning aired
+func checkOrdering(ordering string) error {
+	switch ordering {
+	case "", utils.SERIES_ORDERING_AIRED, utils.SERIES_ORDERING_ABSOLUTE:
+		return nil
 	}
-	if n == "" {
-		n = "untitled"
+	return fmt.Errorf("invalid ordering %q", ordering)
+}
+
+// episodeOverlaps tells whether another episode of the season already covers
+// one of the numbers start..end (end 0 for single-episode files)
+func episodeOverlaps(ctx context.Context, seriesID primitive.ObjectID, season, start, end int, exclude primitive.ObjectID) (bool, error) {
+	if end < start {
+		end = start
 	}
-	return n
+	count, err := utils.GetCollection("episodes").CountDocuments(ctx, bson.M{
+		"_id":           bson.M{"$ne": exclude},
+		"seriesID":      seriesID,
+		"seasonNumber":  season,
+		"episodeNumber": bson.M{"$lte": end},
+		"$or": bson.A{
+			bson.M{"episodeNumber": bson.M{"$gte": start}},
+			bson.M{"episodeNumberEnd": bson.M{"$gte": start}},
+		},
+	})
+	return count > 0, err
 }
 
 func firstNonEmpty(a, b string) string {
@@ -252,13 +314,15 @@ func firstNonEmpty(a, b string) string {
 	return b
 }
 
-// GET /series - Get all series
+// GET /series - Get all series, optionally filtered by ?library=
 func GetAllSeries(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	filter := bson.M{}
+	restrictCatalogue(c, filter, c.Query("library"))
 	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}})
-	cursor, err := utils.GetCollection("series").Find(ctx, bson.M{}, opts)
+	cursor, err := utils.GetCollection("series").Find(ctx, filter, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch series"})
 		return
@@ -292,6 +356,10 @@ func GetSeriesByID(c *gin.Context) {
 		}
 		return
 	}
+	if !canWatch(c, series.Library, series.MinAge) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Series not found"})
+		return
+	}
 
 	// Get all episodes for this series
 	cursor, err := utils.GetCollection("episodes").Find(ctx, bson.M{"seriesID": series.ID}, options.Find().SetSort(bson.D{{Key: "seasonNumber", Value: 1}, {Key: "episodeNumber", Value: 1}}))
@@ -307,13 +375,23 @@ func GetSeriesByID(c *gin.Context) {
 		return
 	}
 
+	seasons, err := utils.GetSeasons(ctx, series.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seasons"})
+		return
+	}
+	series.Seasons = seasons
+
 	c.JSON(http.StatusOK, gin.H{
 		"series":   series,
+		"seasons":  seasons,
 		"episodes": episodes,
 	})
 }
 
-// GET /episode/:id - Get episode by ID
+// GET /episode/:id - Get episode by ID with its navigation context: previous
+// and next episodes in playback order, position within the season and the
+// series, and series/season summaries
 func GetEpisodeByID(c *gin.Context) {
 	id := c.Param("id")
 
@@ -338,34 +416,55 @@ func GetEpisodeByID(c *gin.Context) {
 		return
 	}
 
-	// Build prev/next within same series (prefer same season, otherwise cross-season)
-	coll := utils.GetCollection("episodes")
+	var series utils.Series
+	if err := utils.GetCollection("series").FindOne(ctx, bson.M{"_id": episode.SeriesID}).Decode(&series); err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !canWatch(c, series.Library, series.MinAge) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+		return
+	}
 
-	var nextEp utils.Episode
-	nextFound := false
-	// 1) next in same season (min episodeNumber > current)
-	nextFilterSame := bson.M{
-		"seriesID":      episode.SeriesID,
-		"seasonNumber":  episode.SeasonNumber,
-		"episodeNumber": bson.M{"$gt": episode.EpisodeNumber},
-	}
-	if err := coll.FindOne(ctx, nextFilterSame, options.FindOne().SetSort(bson.D{{Key: "episodeNumber", Value: 1}})).Decode(&nextEp); err == nil {
-		nextFound = true
-	} else if err != mongo.ErrNoDocuments {
+	// Every sibling in one query, without the heavy fields
+	cursor, err := utils.GetCollection("episodes").Find(ctx, bson.M{"seriesID": episode.SeriesID},
+		options.Find().SetProjection(bson.M{"versions": 0}))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
-	} else {
-		// 2) next season: take the first episode of the nearest higher season
-		nextFilterCross := bson.M{"seriesID": episode.SeriesID, "seasonNumber": bson.M{"$gt": episode.SeasonNumber}}
-		if err2 := coll.FindOne(ctx, nextFilterCross, options.FindOne().SetSort(bson.D{{Key: "seasonNumber", Value: 1}, {Key: "episodeNumber", Value: 1}})).Decode(&nextEp); err2 == nil {
-			nextFound = true
-		} else if err2 != mongo.ErrNoDocuments {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			return
+	}
+	var siblings []utils.Episode
+	if err := cursor.All(ctx, &siblings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	chain := utils.PlaybackChain(siblings, series.Ordering, episode)
+	index := -1
+	for i, ep := range chain {
+		if ep.ID == episode.ID {
+			index = i
+			break
 		}
 	}
 
-	// Compose response: flatten episode fields and attach prev/next
+	seasonIndex, seasonCount := 0, 0
+	seasonNumbers := map[int]bool{}
+	for _, ep := range siblings {
+		seasonNumbers[ep.SeasonNumber] = true
+		if ep.SeasonNumber != episode.SeasonNumber {
+			continue
+		}
+		seasonCount++
+		if ep.EpisodeNumber <= episode.EpisodeNumber {
+			seasonIndex++
+		}
+	}
+
+	var season utils.Season
+	_ = utils.GetCollection("seasons").FindOne(ctx, bson.M{"seriesID": episode.SeriesID, "seasonNumber": episode.SeasonNumber}).Decode(&season)
+
+	// Compose response: flatten episode fields and attach navigation
 	var resp map[string]interface{}
 	// marshal then unmarshal to map
 	if b, err := json.Marshal(episode); err == nil {
@@ -374,8 +473,440 @@ func GetEpisodeByID(c *gin.Context) {
 	if resp == nil {
 		resp = map[string]interface{}{}
 	}
-	if nextFound {
-		resp["nextEpisode"] = nextEp
+	if index > 0 {
+		resp["previousEpisode"] = chain[index-1]
+	}
+	if index >= 0 && index+1 < len(chain) {
+		resp["nextEpisode"] = chain[index+1]
+	}
+	resp["position"] = gin.H{
+		"season": gin.H{"index": seasonIndex, "count": seasonCount},
+		"series": gin.H{"index": index + 1, "count": len(chain)},
+	}
+	resp["series"] = gin.H{
+		"id":          series.ID,
+		"title":       series.Title,
+		"tmdbID":      series.TmdbID,
+		"poster":      series.Poster,
+		"ordering":    series.Ordering,
+		"seasonCount": len(seasonNumbers),
+	}
+	resp["season"] = gin.H{
+		"seasonNumber": episode.SeasonNumber,
+		"name":         firstNonEmpty(season.Name, utils.CurrentLayout().SeasonFolder(episode.SeasonNumber)),
+		"poster":       season.Poster,
+		"airDate":      season.AirDate,
+		"episodeCount": seasonCount,
 	}
 	c.JSON(http.StatusOK, resp)
 }
+
+// findEpisode loads an episode the user may watch, writing the error response itself
+func findEpisode(c *gin.Context, ctx context.Context, id string) (utils.Episode, bool) {
+	var episode utils.Episode
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode id"})
+		return episode, false
+	}
+	if err := utils.GetCollection("episodes").FindOne(ctx, bson.M{"_id": objID}).Decode(&episode); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return episode, false
+	}
+	var series utils.Series
+	if err := utils.GetCollection("series").FindOne(ctx, bson.M{"_id": episode.SeriesID}).Decode(&series); err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return episode, false
+	}
+	if !canWatch(c, series.Library, series.MinAge) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+		return episode, false
+	}
+	return episode, true
+}
+
+// findSeries loads a series by Mongo ID or tmdbID, writing the error response itself
+func findSeries(c *gin.Context, ctx context.Context, id string) (utils.Series, bool) {
+	var series utils.Series
+	filter, err := mediaFilter(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return series, false
+	}
+	if err := utils.GetCollection("series").FindOne(ctx, filter).Decode(&series); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Series not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return series, false
+	}
+	if !canWatch(c, series.Library, series.MinAge) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Series not found"})
+		return series, false
+	}
+	return series, true
+}
+
+// PUT /series/:id - edit metadata, re-match to another tmdbID and/or rename the
+// series folder after customTitle
+func UpdateSeries(c *gin.Context) {
+	var input struct {
+		Title       *string `json:"title"`
+		CustomTitle *string `json:"customTitle"`
+		Poster      *string `json:"poster"`
+		TmdbID      *int    `json:"tmdbID"`
+		Ordering    *string `json:"ordering"`
+
+		Certifications *map[string]string `json:"certifications"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	set := bson.M{}
+	if input.Title != nil {
+		set["title"] = *input.Title
+	}
+	if input.Poster != nil {
+		set["poster"] = *input.Poster
+	}
+	if input.Ordering != nil {
+		if err := checkOrdering(*input.Ordering); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		set["ordering"] = *input.Ordering
+	}
+	if input.Certifications != nil {
+		for k, v := range utils.CertificationFields(*input.Certifications) {
+			set[k] = v
+		}
+	}
+	if input.TmdbID != nil && *input.TmdbID != series.TmdbID {
+		count, err := utils.GetCollection("series").CountDocuments(ctx, bson.M{"tmdbID": *input.TmdbID, "_id": bson.M{"$ne": series.ID}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "another series already uses this tmdbID"})
+			return
+		}
+		set["tmdbID"] = *input.TmdbID
+	}
+	var moves []layoutMove
+	if input.CustomTitle != nil && strings.TrimSpace(*input.CustomTitle) != series.CustomTitle {
+		newTitle := strings.TrimSpace(*input.CustomTitle)
+		if err := checkFileName(newTitle); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		renamed := series
+		renamed.CustomTitle = newTitle
+		planned, err := planSeriesLayout(ctx, renamed)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		moves = planned
+		set["customTitle"] = newTitle
+	}
+
+	if len(set) == 0 {
+		c.JSON(http.StatusOK, series)
+		return
+	}
+	// Episode files move with the custom title; the series is only updated once they all did
+	update := func() error {
+		return utils.GetCollection("series").FindOneAndUpdate(ctx, bson.M{"_id": series.ID}, bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&series)
+	}
+	if err := applyLayout(ctx, utils.GetCollection("episodes"), moves, update); err != nil {
+		c.JSON(layoutErrorStatus(err), gin.H{"error": "failed to update series: " + err.Error()})
+		return
+	}
+	if len(moves) > 0 {
+		syncSeasons(ctx, series.ID, movedSeasons(ctx, moves)...)
+	}
+
+	if tmdbID, changed := set["tmdbID"]; changed {
+		// Episodes and progress records carry the series tmdbID
+		if _, err := utils.GetCollection("episodes").UpdateMany(ctx, bson.M{"seriesID": series.ID}, bson.M{"$set": bson.M{"tmdbID": tmdbID}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update episodes: " + err.Error()})
+			return
+		}
+		if _, err := utils.GetCollection("ongoing_episodes").UpdateMany(ctx, bson.M{"series": series.ID}, bson.M{"$set": bson.M{"tmdbID": tmdbID}}); err != nil {
+			fmt.Println("Progress update error:", err)
+		}
+		// Season metadata comes from the previous match
+		if err := utils.SyncSeriesSeasons(ctx, series, true); err != nil {
+			fmt.Println("Season sync error:", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+// DELETE /series/:id?deleteFiles=true - remove a whole series
+func DeleteSeries(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	deleted, err := deleteEpisodes(ctx, bson.M{"seriesID": series.ID}, c.Query("deleteFiles") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := utils.GetCollection("series").DeleteOne(ctx, bson.M{"_id": series.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, err := utils.GetCollection("seasons").DeleteMany(ctx, bson.M{"seriesID": series.ID}); err != nil {
+		fmt.Println("Season cleanup error:", err)
+	}
+	if err := utils.WatchlistRemoved(ctx, "series", series.TmdbID); err != nil {
+		fmt.Println("Watchlist update error:", err)
+	}
+	if err := purgeRatings(ctx, "series", []primitive.ObjectID{series.ID}); err != nil {
+		fmt.Println("Ratings cleanup error:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": 1, "episodes": deleted})
+}
+
+// DELETE /series/:id/seasons/:season?deleteFiles=true - remove every episode of a season
+func DeleteSeason(c *gin.Context) {
+	seasonNumber, err := strconv.Atoi(c.Param("season"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid season number"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	series, ok := findSeries(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	deleted, err := deleteEpisodes(ctx, bson.M{"seriesID": series.ID, "seasonNumber": seasonNumber}, c.Query("deleteFiles") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Season not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// PUT /episode/:id - edit title or numbering, renaming the file accordingly
+func UpdateEpisode(c *gin.Context) {
+	var input struct {
+		Title            *string `json:"title"`
+		SeasonNumber     *int    `json:"seasonNumber"`
+		EpisodeNumber    *int    `json:"episodeNumber"`
+		EpisodeNumberEnd *int    `json:"episodeNumberEnd"`
+		AbsoluteNumber   *int    `json:"absoluteNumber"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := utils.GetCollection("episodes")
+	var ep utils.Episode
+	if err := coll.FindOne(ctx, bson.M{"_id": objID}).Decode(&ep); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	updated := ep
+	if input.Title != nil {
+		updated.Title = *input.Title
+	}
+	if input.SeasonNumber != nil {
+		updated.SeasonNumber = *input.SeasonNumber
+	}
+	if input.EpisodeNumber != nil {
+		updated.EpisodeNumber = *input.EpisodeNumber
+	}
+	if input.EpisodeNumberEnd != nil {
+		updated.EpisodeNumberEnd = *input.EpisodeNumberEnd
+	}
+	if input.AbsoluteNumber != nil {
+		updated.AbsoluteNumber = *input.AbsoluteNumber
+	}
+	if err := checkEpisodeNumbers(updated.SeasonNumber, updated.EpisodeNumber, updated.EpisodeNumberEnd, updated.AbsoluteNumber); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if updated.SeasonNumber != ep.SeasonNumber || updated.EpisodeNumber != ep.EpisodeNumber || updated.EpisodeNumberEnd != ep.EpisodeNumberEnd {
+		overlap, err := episodeOverlaps(ctx, ep.SeriesID, updated.SeasonNumber, updated.EpisodeNumber, updated.EpisodeNumberEnd, ep.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if overlap {
+			c.JSON(http.StatusConflict, gin.H{"error": "another episode already has this number"})
+			return
+		}
+	}
+
+	// Rename the file after the layout, moving it to its season folder when the season changes
+	var series utils.Series
+	if err := utils.GetCollection("series").FindOne(ctx, bson.M{"_id": ep.SeriesID}).Decode(&series); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	ext := videoExt(ep.FilePath)
+	if ext == "" {
+		ext = ".mp4"
+	}
+	updated.FilePath, err = episodePath(currentRoot(ep.FilePath), series, updated, ext)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	versions, renamed, err := renameVersionFiles(ep.FilePath, updated.FilePath, ep.Versions)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "failed to rename file: " + err.Error()})
+		return
+	}
+	updated.Versions = versions
+
+	set := bson.M{
+		"title":            updated.Title,
+		"seasonNumber":     updated.SeasonNumber,
+		"episodeNumber":    updated.EpisodeNumber,
+		"episodeNumberEnd": updated.EpisodeNumberEnd,
+		"absoluteNumber":   updated.AbsoluteNumber,
+		"filePath":         updated.FilePath,
+		"versions":         updated.Versions,
+	}
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": ep.ID}, bson.M{"$set": set}); err != nil {
+		rollbackRenames(renamed)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if filepath.Dir(updated.FilePath) != filepath.Dir(ep.FilePath) {
+		removeEmptyDirs(ep.FilePath)
+	}
+	syncSeasons(ctx, ep.SeriesID, ep.SeasonNumber, updated.SeasonNumber)
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DELETE /episode/:id?deleteFiles=true
+func DeleteEpisode(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	deleted, err := deleteEpisodes(ctx, bson.M{"_id": objID}, c.Query("deleteFiles") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// deleteEpisodes removes the matching episodes with their progress records and
+// HLS caches, and optionally their files
+func deleteEpisodes(ctx context.Context, filter bson.M, deleteFiles bool) (int64, error) {
+	coll := utils.GetCollection("episodes")
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var episodes []utils.Episode
+	if err := cursor.All(ctx, &episodes); err != nil {
+		return 0, err
+	}
+	if len(episodes) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(episodes))
+	for _, ep := range episodes {
+		ids = append(ids, ep.ID)
+	}
+	res, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	if err := purgeProgress(ctx, "episode", ids); err != nil {
+		fmt.Println("Progress cleanup error:", err)
+	}
+	if err := purgeHistory(ctx, "episode", ids); err != nil {
+		fmt.Println("History cleanup error:", err)
+	}
+	if err := purgeRatings(ctx, "episode", ids); err != nil {
+		fmt.Println("Ratings cleanup error:", err)
+	}
+	touched := map[primitive.ObjectID][]int{}
+	for _, ep := range episodes {
+		touched[ep.SeriesID] = append(touched[ep.SeriesID], ep.SeasonNumber)
+	}
+	for seriesID, numbers := range touched {
+		syncSeasons(ctx, seriesID, numbers...)
+	}
+
+	for _, ep := range episodes {
+		removeHLSCache("episode", ep.ID.Hex())
+		removeTrickplay("episode", ep.ID.Hex())
+		if !deleteFiles {
+			continue
+		}
+		for _, v := range utils.MediaVersions(ep.FilePath, ep.Versions) {
+			if err := removeMediaFile(v.FilePath); err != nil {
+				return res.DeletedCount, fmt.Errorf("failed to remove %s: %w", v.FilePath, err)
+			}
+		}
+	}
+	return res.DeletedCount, nil
+}
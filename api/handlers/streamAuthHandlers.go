@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"api/utils"
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// streamAuthParams are the query parameters carried over from a playlist
+// request to the URLs it lists. Session tokens never are.
+var streamAuthParams = []string{"uid", "exp", "sig"}
+
+// CTX_STREAM_QUERY is the signed query appended to the URLs of a playlist
+const CTX_STREAM_QUERY = "streamQuery"
+
+// RequireStreamAccess guards the stream endpoints of a media: a URL signed
+// by GET /stream/:type/:id for this very media, or a session. kind is "movie"
+// or "episode", empty to read it from the :type param.
+func RequireStreamAccess(kind string) gin.HandlerFunc {
+	requireAuth := RequireAuth()
+	return func(c *gin.Context) {
+		if utils.AuthDisabled() {
+			c.Next()
+			return
+		}
+		k := kind
+		if k == "" {
+			k = c.Param("type")
+		}
+		if c.Query("sig") == "" {
+			requireAuth(c)
+			if user, ok := currentUser(c); ok && !c.IsAborted() {
+				// Playlists hand out signed URLs instead of the session token
+				expires := time.Now().Add(utils.StreamURLTTL())
+				c.Set(CTX_STREAM_QUERY, utils.SignStream(k, c.Param("id"), user.ID.Hex(), expires).Encode())
+			}
+			return
+		}
+		userID, err := utils.VerifyStream(k, c.Param("id"), c.Request.URL.Query())
+		if err != nil {
+			abortUnauthorized(c, err.Error())
+			return
+		}
+		// The profile is reloaded so parental controls changed since the
+		// URL was issued still apply
+		objID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			abortUnauthorized(c, utils.ErrStreamSignature.Error())
+			return
+		}
+		ctx, cancel := getDBContext()
+		defer cancel()
+		var user utils.User
+		if err := utils.GetCollection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+			abortUnauthorized(c, utils.ErrInvalidToken.Error())
+			return
+		}
+		c.Set(CTX_USER, user)
+		c.Next()
+	}
+}
+
+// streamAuthQuery returns the signature of the request, or one signed for
+// the session user, to append to the URLs of a playlist ("" when there is none)
+func streamAuthQuery(c *gin.Context) string {
+	if query := c.GetString(CTX_STREAM_QUERY); query != "" {
+		return query
+	}
+	q := url.Values{}
+	for _, name := range streamAuthParams {
+		if v := c.Query(name); v != "" {
+			q.Set(name, v)
+		}
+	}
+	return q.Encode()
+}
+
+// withQuery appends an encoded query to a relative or absolute URL
+func withQuery(u, query string) string {
+	if query == "" {
+		return u
+	}
+	if strings.Contains(u, "?") {
+		return u + "&" + query
+	}
+	return u + "?" + query
+}
+
+var playlistURIAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// rewritePlaylist appends query to every URL of an HLS playlist: the
+// variant playlists and segments lines, and the URI="" attributes of tags
+// (EXT-X-MEDIA, EXT-X-MAP, EXT-X-KEY...)
+func rewritePlaylist(playlist []byte, query string) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#"):
+			line = playlistURIAttr.ReplaceAllStringFunc(line, func(attr string) string {
+				uri := playlistURIAttr.FindStringSubmatch(attr)[1]
+				return `URI="` + withQuery(uri, query) + `"`
+			})
+		default:
+			line = withQuery(trimmed, query)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// servePlaylist serves an HLS playlist, its URLs carrying a signature so that
+// players can fetch segments without a session
+func servePlaylist(c *gin.Context, path string) {
+	query := streamAuthQuery(c)
+	if query == "" {
+		c.File(path)
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", rewritePlaylist(data, query))
+}
+
+// GET /stream/:type/:id - signed URLs of the streams of a movie (by tmdbID) or
+// an episode, valid STREAM_URL_TTL. Trickplay sprites take the same "query".
+func GetStreamURLs(c *gin.Context) {
+	kind, id := c.Param("type"), c.Param("id")
+	if kind != "movie" && kind != "episode" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown media type"})
+		return
+	}
+	if !allowPlayback(c, kind, id) {
+		return
+	}
+
+	ctx, cancel := getDBContext()
+	defer cancel()
+	var count int64
+	var err error
+	if kind == "movie" {
+		tmdbID, convErr := strconv.Atoi(id)
+		if convErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tmdbID"})
+			return
+		}
+		count, err = utils.GetCollection("movies").CountDocuments(ctx, bson.M{"tmdbID": tmdbID})
+	} else {
+		objID, convErr := primitive.ObjectIDFromHex(id)
+		if convErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode id"})
+			return
+		}
+		count, err = utils.GetCollection("episodes").CountDocuments(ctx, bson.M{"_id": objID})
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": kind + " not found"})
+		return
+	}
+
+	userID := ""
+	if user, ok := currentUser(c); ok {
+		userID = user.ID.Hex()
+	}
+	expires := time.Now().Add(utils.StreamURLTTL())
+	query := utils.SignStream(kind, id, userID, expires).Encode()
+
+	video := "/video/" + id
+	if kind == "episode" {
+		video = "/video/episode/" + id
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"expires":   expires,
+		"query":     query,
+		"video":     withQuery(video, query),
+		"chapters":  withQuery(video+"/chapters", query),
+		"hls":       withQuery("/hls/"+kind+"/"+id+"/master.m3u8", query),
+		"trickplay": withQuery("/trickplay/"+kind+"/"+id+"/index.json", query),
+	})
+}
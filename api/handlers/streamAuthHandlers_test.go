@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamAuthQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		query  string
+		signed string // Set by RequireStreamAccess for session requests
+		want   string
+	}{
+		{"signed URL", "uid=u&exp=1&sig=s", "", "exp=1&sig=s&uid=u"},
+		{"session token dropped", "token=secret&uid=u&exp=1&sig=s", "", "exp=1&sig=s&uid=u"},
+		{"session only", "token=secret", "", ""},
+		{"session signed", "token=secret", "exp=2&sig=t&uid=v", "exp=2&sig=t&uid=v"},
+		{"unrelated params", "version=abc", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/hls/movie/603/master.m3u8?"+tt.query, nil)
+			if tt.signed != "" {
+				c.Set(CTX_STREAM_QUERY, tt.signed)
+			}
+			got := streamAuthQuery(c)
+			if got != tt.want {
+				t.Errorf("streamAuthQuery() = %q, want %q", got, tt.want)
+			}
+			if strings.Contains(got, "token") {
+				t.Errorf("streamAuthQuery() leaks the session token: %q", got)
+			}
+		})
+	}
+}
+
+func TestRewritePlaylist(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6.0,\nseg0.ts\n\nv/1/index.m3u8?x=1\n"
+	want := "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4?sig=s\"\n#EXTINF:6.0,\nseg0.ts?sig=s\n\nv/1/index.m3u8?x=1&sig=s\n"
+	if got := string(rewritePlaylist([]byte(playlist), "sig=s")); got != want {
+		t.Errorf("rewritePlaylist() = %q, want %q", got, want)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -17,6 +18,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Helper pour gérer les timeouts de DB de manière plus souple (10s au lieu de 5s)
@@ -27,6 +29,9 @@ func getDBContext() (context.Context, context.CancelFunc) {
 // GET /video/:id - Stream movie video
 func VideoStreamHandler(c *gin.Context) {
 	tmdbID := c.Param("id")
+	if !allowPlayback(c, "movie", tmdbID) {
+		return
+	}
 
 	ctx, cancel := getDBContext()
 	defer cancel()
@@ -45,17 +50,21 @@ func VideoStreamHandler(c *gin.Context) {
 		return
 	}
 
-	if movie.FilePath == "" {
+	version, ok := selectMediaVersion(c, movie.FilePath, movie.Versions)
+	if !ok {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Fichier vidéo non référencé"})
 		return
 	}
 
-	serveVideoStream(movie.FilePath, c)
+	serveVideoStream(version.FilePath, c)
 }
 
 // GET /video/episode/:id - Stream episode video
 func EpisodeStreamHandler(c *gin.Context) {
 	id := c.Param("id")
+	if !allowPlayback(c, "episode", id) {
+		return
+	}
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid episode ID"})
@@ -77,17 +86,21 @@ func EpisodeStreamHandler(c *gin.Context) {
 		return
 	}
 
-	if episode.FilePath == "" {
+	version, ok := selectMediaVersion(c, episode.FilePath, episode.Versions)
+	if !ok {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Video file not found"})
 		return
 	}
 
-	serveVideoStream(episode.FilePath, c)
+	serveVideoStream(version.FilePath, c)
 }
 
 // GET /video/:id/chapters
 func MovieChaptersHandler(c *gin.Context) {
 	tmdbID := c.Param("id")
+	if !allowPlayback(c, "movie", tmdbID) {
+		return
+	}
 	ctx, cancel := getDBContext()
 	defer cancel()
 
@@ -98,7 +111,12 @@ func MovieChaptersHandler(c *gin.Context) {
 		return
 	}
 
-	chapters, err := ffprobeChapters(movie.FilePath)
+	version, _ := selectMediaVersion(c, movie.FilePath, movie.Versions)
+	if version.FilePath == movie.FilePath && movie.Chapters != nil {
+		c.JSON(http.StatusOK, gin.H{"chapters": movie.Chapters})
+		return
+	}
+	chapters, err := ffprobeChapters(version.FilePath)
 	if err != nil {
 		fmt.Println("Chapters Error:", err)
 		c.JSON(http.StatusOK, gin.H{"chapters": []any{}})
@@ -110,6 +128,9 @@ func MovieChaptersHandler(c *gin.Context) {
 // GET /video/episode/:id/chapters
 func EpisodeChaptersHandler(c *gin.Context) {
 	id := c.Param("id")
+	if !allowPlayback(c, "episode", id) {
+		return
+	}
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid episode ID"})
@@ -124,7 +145,12 @@ func EpisodeChaptersHandler(c *gin.Context) {
 		return
 	}
 
-	chapters, err := ffprobeChapters(ep.FilePath)
+	version, _ := selectMediaVersion(c, ep.FilePath, ep.Versions)
+	if version.FilePath == ep.FilePath && ep.Chapters != nil {
+		c.JSON(http.StatusOK, gin.H{"chapters": ep.Chapters})
+		return
+	}
+	chapters, err := ffprobeChapters(version.FilePath)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"chapters": []any{}})
 		return
@@ -136,54 +162,121 @@ func EpisodeChaptersHandler(c *gin.Context) {
 
 func HLSMovieAsset(c *gin.Context) {
 	id := c.Param("id")
-	handleHLSRequest(c, "movie", id, func() (string, error) {
+	if !allowPlayback(c, "movie", id) {
+		return
+	}
+	handleHLSRequest(c, "movie", id, func() (versionedMedia, error) {
 		idInt, _ := strconv.Atoi(id)
-		var movie utils.Movie
+		var movie versionedMedia
 		ctx, cancel := getDBContext()
 		defer cancel()
 		err := utils.GetCollection("movies").FindOne(ctx, bson.M{"tmdbID": idInt}).Decode(&movie)
-		return movie.FilePath, err
+		return movie, err
 	})
 }
 
 func HLSEpisodeAsset(c *gin.Context) {
 	id := c.Param("id")
-	handleHLSRequest(c, "episode", id, func() (string, error) {
+	if !allowPlayback(c, "episode", id) {
+		return
+	}
+	handleHLSRequest(c, "episode", id, func() (versionedMedia, error) {
+		var ep versionedMedia
 		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			return "", err
+			return ep, err
 		}
-		var ep utils.Episode
 		ctx, cancel := getDBContext()
 		defer cancel()
 		err = utils.GetCollection("episodes").FindOne(ctx, bson.M{"_id": objID}).Decode(&ep)
-		return ep.FilePath, err
+		return ep, err
 	})
 }
 
-// Logique générique pour HLS afin d'éviter la duplication de code
-func handleHLSRequest(c *gin.Context, typeMedia, id string, getPath func() (string, error)) {
-	base := os.Getenv("HLS_DIR")
-	if base == "" {
-		base = "./hls_cache"
-	}
-	outDir := filepath.Join(base, typeMedia, id)
+// Logique générique pour HLS afin d'éviter la duplication de code.
+// Les médias à plusieurs versions ont un cache par version sous v/<versionID>/ :
+// la master playlist racine redirige vers la version choisie pour le client.
+// De même, une piste audio autre que celle par défaut (?audio=<index>, ou
+// choisie d'après les préférences du profil) a son cache sous a/<index>/.
+func handleHLSRequest(c *gin.Context, typeMedia, id string, getMedia func() (versionedMedia, error)) {
+	outDir := hlsCacheDir(typeMedia, id)
 	asset := strings.TrimPrefix(c.Param("asset"), "/")
 
+	versionID := ""
+	if strings.HasPrefix(asset, "v/") {
+		parts := strings.SplitN(strings.TrimPrefix(asset, "v/"), "/", 2)
+		versionID = parts[0]
+		if !primitive.IsValidObjectID(versionID) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		asset = ""
+		if len(parts) == 2 {
+			asset = parts[1]
+		}
+		outDir = filepath.Join(outDir, "v", versionID)
+	}
+	audio := -1 // ffmpeg's default track
+	if strings.HasPrefix(asset, "a/") {
+		parts := strings.SplitN(strings.TrimPrefix(asset, "a/"), "/", 2)
+		n, err := strconv.Atoi(parts[0])
+		if err != nil || n < 0 || strconv.Itoa(n) != parts[0] {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		audio = n
+		asset = ""
+		if len(parts) == 2 {
+			asset = parts[1]
+		}
+		outDir = filepath.Join(outDir, "a", parts[0])
+	}
+
 	// Si demande master playlist ou racine
 	if asset == "" || asset == "master.m3u8" {
 		asset = "master.m3u8"
 		// On vérifie si le fichier existe DÉJÀ avant de taper la DB
-		if _, err := os.Stat(filepath.Join(outDir, asset)); os.IsNotExist(err) {
-			inputPath, err := getPath()
+		// (la racine n'est générée que pour les médias à une seule version)
+		_, statErr := os.Stat(filepath.Join(outDir, asset))
+		chooseAudio := audio < 0 && (c.Query("audio") != "" || preferredAudio(c) != "")
+		if os.IsNotExist(statErr) || (versionID == "" && c.Query("version") != "") || chooseAudio {
+			media, err := getMedia()
 			if err != nil {
 				c.Status(http.StatusNotFound)
 				return
 			}
-			if err2 := ensureHLS(inputPath, outDir); err2 != nil {
-				fmt.Println("HLS Generation Error:", err2)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate HLS"})
-				return
+			inputPath := media.FilePath
+			if versionID != "" {
+				v, ok := utils.SelectVersion(media.FilePath, media.Versions, versionID, 0)
+				if !ok {
+					c.Status(http.StatusNotFound)
+					return
+				}
+				inputPath = v.FilePath
+			} else if len(media.Versions) > 1 || c.Query("version") != "" {
+				v, ok := selectMediaVersion(c, media.FilePath, media.Versions)
+				if !ok {
+					c.Status(http.StatusNotFound)
+					return
+				}
+				if !v.ID.IsZero() {
+					// Relative to .../:id/, keeps the nginx /api prefix
+					c.Redirect(http.StatusFound, withQuery("v/"+v.ID.Hex()+"/master.m3u8", hlsRedirectQuery(c)))
+					return
+				}
+			}
+			if chooseAudio {
+				if n, ok := hlsAudioTrack(c, media, inputPath); ok {
+					c.Redirect(http.StatusFound, withQuery("a/"+strconv.Itoa(n)+"/master.m3u8", streamAuthQuery(c)))
+					return
+				}
+			}
+			if _, err := os.Stat(filepath.Join(outDir, asset)); os.IsNotExist(err) {
+				if err2 := ensureHLS(inputPath, outDir, audio); err2 != nil {
+					fmt.Println("HLS Generation Error:", err2)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate HLS"})
+					return
+				}
 			}
 		}
 	}
@@ -206,9 +299,11 @@ func handleHLSRequest(c *gin.Context, typeMedia, id string, getPath func() (stri
 	case ".m3u8":
 		c.Header("Content-Type", "application/vnd.apple.mpegurl")
 		c.Header("Cache-Control", "no-cache") // Playlist ne doit pas être cachée
+		servePlaylist(c, path)
+		return
 	case ".ts":
 		c.Header("Content-Type", "video/mp2t")
-		c.Header("Cache-Control", "public, max-age=31536000") // Segments cachés longtemps
+		c.Header("Cache-Control", "private, max-age=31536000") // Segments cachés longtemps, par le client seul
 	}
 
 	c.File(path)
@@ -230,7 +325,60 @@ func serveVideoStream(filePath string, c *gin.Context) {
 	c.File(filePath)
 }
 
-func ensureHLS(inputPath, outDir string) error {
+// hlsRedirectQuery is the query kept when the master playlist redirects to
+// a version: the signature and the requested audio track
+func hlsRedirectQuery(c *gin.Context) string {
+	query := streamAuthQuery(c)
+	if n := c.Query("audio"); n != "" {
+		audio := url.Values{"audio": {n}}.Encode()
+		if query == "" {
+			return audio
+		}
+		return query + "&" + audio
+	}
+	return query
+}
+
+// preferredAudio is the audio language of the profile, "" when it has none
+func preferredAudio(c *gin.Context) string {
+	if user, ok := currentUser(c); ok {
+		return user.Preferences.AudioLanguage
+	}
+	return ""
+}
+
+// hlsAudioTrack returns the audio track to transcode: ?audio=<index>, or the
+// one matching the profile's preferences (see GetPlaybackDecision) when the
+// file has several. Tracks are only known for the probed default file.
+func hlsAudioTrack(c *gin.Context, media versionedMedia, inputPath string) (int, bool) {
+	var info *utils.ProbeResult
+	if inputPath == media.FilePath {
+		info = media.MediaInfo
+	}
+	if v := c.Query("audio"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || (info != nil && n >= len(info.AudioTracks)) {
+			return 0, false
+		}
+		return n, true
+	}
+	if info == nil || len(info.AudioTracks) < 2 {
+		return 0, false
+	}
+	prefs := utils.DefaultPreferences()
+	if user, ok := currentUser(c); ok {
+		prefs = user.Preferences
+	}
+	audio, _ := utils.ChooseTracks(info, prefs)
+	if audio == nil {
+		return 0, false
+	}
+	return audio.Index, true
+}
+
+// ensureHLS transcodes inputPath to HLS in outDir, with the audio track of
+// that index (-1 for the one ffmpeg picks)
+func ensureHLS(inputPath, outDir string, audio int) error {
 	// Double check rapide
 	if _, err := os.Stat(filepath.Join(outDir, "master.m3u8")); err == nil {
 		return nil
@@ -246,9 +394,11 @@ func ensureHLS(inputPath, outDir string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx,
-		"ffmpeg", "-y",
-		"-i", inputPath,
+	args := []string{"-y", "-i", inputPath}
+	if audio >= 0 {
+		args = append(args, "-map", "0:v:0", "-map", fmt.Sprintf("0:a:%d", audio))
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(args,
 		"-hide_banner", "-loglevel", "error",
 		"-preset", "veryfast",
 		"-g", "48", "-keyint_min", "48", "-sc_threshold", "0",
@@ -257,11 +407,11 @@ func ensureHLS(inputPath, outDir string) error {
 		"-hls_flags", "independent_segments",
 		"-c:v", "h264", "-c:a", "aac",
 		master,
-	)
+	)...)
 	return cmd.Run()
 }
 
-func ffprobeChapters(inputPath string) ([]map[string]interface{}, error) {
+func ffprobeChapters(inputPath string) ([]utils.Chapter, error) {
 	// Ajout timeout pour éviter de bloquer indéfiniment
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -284,28 +434,48 @@ func ffprobeChapters(inputPath string) ([]map[string]interface{}, error) {
 		return nil, err
 	}
 
-	res := make([]map[string]interface{}, 0, len(parsed.Chapters))
+	res := make([]utils.Chapter, 0, len(parsed.Chapters))
 	for _, ch := range parsed.Chapters {
 		st, _ := strconv.ParseFloat(ch.StartTime, 64)
 		et, _ := strconv.ParseFloat(ch.EndTime, 64)
-		title := ""
-		if ch.Tags != nil {
-			if t, ok := ch.Tags["title"]; ok {
-				title = t
-			}
-		}
-		res = append(res, map[string]interface{}{
-			"start": st,
-			"end":   et,
-			"title": title,
-		})
+		res = append(res, utils.Chapter{Start: st, End: et, Title: ch.Tags["title"]})
 	}
 	return res, nil
 }
 
+// GET /poster/:id - local poster of a movie, by TMDB ID
 func PosterHandler(c *gin.Context) {
-	tmdbID := c.Param("id")
-	posterFile := filepath.Join("uploads", tmdbID+".jpg")
+	servePoster(c, "movie")
+}
+
+// GET /series/:id/poster - local poster of a series, id being its ObjectID
+// like the other /series/:id routes, or its TMDB ID
+func SeriesPosterHandler(c *gin.Context) {
+	if oid, err := primitive.ObjectIDFromHex(c.Param("id")); err == nil {
+		ctx, cancel := getDBContext()
+		defer cancel()
+		var series utils.Series
+		if err := utils.GetCollection("series").FindOne(ctx, bson.M{"_id": oid}, options.FindOne().SetProjection(bson.M{"tmdbID": 1})).Decode(&series); err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		servePosterFile(c, "series", series.TmdbID)
+		return
+	}
+	servePoster(c, "series")
+}
+
+func servePoster(c *gin.Context, kind string) {
+	tmdbID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	servePosterFile(c, kind, tmdbID)
+}
+
+func servePosterFile(c *gin.Context, kind string, tmdbID int) {
+	posterFile := utils.ArtworkPath(kind, tmdbID)
 	if _, err := os.Stat(posterFile); os.IsNotExist(err) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Codes of the upload errors, for the frontend to show a proper message
+const (
+	UPLOAD_TOO_LARGE       = "FILE_TOO_LARGE"
+	UPLOAD_NO_SPACE        = "INSUFFICIENT_STORAGE"
+	UPLOAD_NOT_VIDEO       = "UNSUPPORTED_FORMAT"
+	UPLOAD_NO_VIDEO_STREAM = "NO_VIDEO_STREAM"
+	UPLOAD_PROBE_FAILED    = "UNREADABLE_FILE"
+	UPLOAD_FAILED          = "UPLOAD_FAILED"
+)
+
+// uploadError is answered as {"error", "code", "file", ...details}
+type uploadError struct {
+	status  int
+	code    string
+	message string
+	file    string
+	details gin.H
+}
+
+func (e *uploadError) Error() string { return e.message }
+
+// respondUploadError writes an upload error, structured when possible
+func respondUploadError(c *gin.Context, err error) {
+	var ue *uploadError
+	if !errors.As(err, &ue) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "code": UPLOAD_FAILED})
+		return
+	}
+	body := gin.H{"error": ue.message, "code": ue.code}
+	if ue.file != "" {
+		body["file"] = ue.file
+	}
+	for k, v := range ue.details {
+		body[k] = v
+	}
+	c.JSON(ue.status, body)
+}
+
+// incomingFile is a file about to enter a library, uploaded or imported from
+// the inbox
+type incomingFile struct {
+	name  string // Original file name
+	size  int64
+	path  string // Inbox file, "" for uploads
+	open  func() (io.ReadCloser, error)
+	stage func() (ingestSource, error) // Hands the file over to the ingest job
+}
+
+// uploadedFile wraps a multipart upload
+func uploadedFile(fh *multipart.FileHeader) incomingFile {
+	return incomingFile{
+		name:  fh.Filename,
+		size:  fh.Size,
+		open:  func() (io.ReadCloser, error) { return fh.Open() },
+		stage: func() (ingestSource, error) { return stageUpload(fh) },
+	}
+}
+
+// checkUploadSize verifies, before anything is written, that the files respect
+// the library max size and leave the library min free space on root. Inbox
+// files on the same filesystem as root are moved or linked, taking no space.
+func checkUploadSize(lib utils.Library, root string, files ...incomingFile) error {
+	maxSize, minFree := lib.UploadLimits()
+	var total int64
+	for _, f := range files {
+		if maxSize > 0 && f.size > maxSize {
+			return &uploadError{
+				status:  http.StatusRequestEntityTooLarge,
+				code:    UPLOAD_TOO_LARGE,
+				message: fmt.Sprintf("%s is too large (max %d bytes)", f.name, maxSize),
+				file:    f.name,
+				details: gin.H{"size": f.size, "maxSize": maxSize},
+			}
+		}
+		if f.path == "" || !utils.SameFilesystem(f.path, root) {
+			total += f.size
+		}
+	}
+
+	free, err := utils.DiskFree(root)
+	if err != nil {
+		// Unknown free space: let the write fail by itself if needed
+		return nil
+	}
+	if uint64(total+minFree) > free {
+		return &uploadError{
+			status:  http.StatusInsufficientStorage,
+			code:    UPLOAD_NO_SPACE,
+			message: fmt.Sprintf("not enough free space in library %q", lib.Key),
+			details: gin.H{"required": total + minFree, "available": free},
+		}
+	}
+	return nil
+}
+
+// sniffUpload rejects files whose first bytes are not a known video container
+func sniffUpload(file incomingFile) error {
+	f, err := file.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if !utils.SniffVideo(head[:n]) {
+		return &uploadError{
+			status:  http.StatusUnsupportedMediaType,
+			code:    UPLOAD_NOT_VIDEO,
+			message: fmt.Sprintf("%s is not a supported video file", file.name),
+			file:    file.name,
+		}
+	}
+	return nil
+}
+
+var probeWarning sync.Once
+
+// probeUpload checks with ffprobe that a written upload holds a video stream
+func probeUpload(path, fileName string) (utils.ProbeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := utils.ProbeFile(ctx, path)
+	if errors.Is(err, utils.ErrProbeUnavailable) {
+		probeWarning.Do(func() { log.Printf("ffprobe not found, uploads are only checked by their magic bytes") })
+		return res, nil
+	}
+	if err != nil {
+		return res, &uploadError{
+			status:  http.StatusUnprocessableEntity,
+			code:    UPLOAD_PROBE_FAILED,
+			message: fmt.Sprintf("%s can't be read: %v", fileName, err),
+			file:    fileName,
+		}
+	}
+	if res.VideoCodec == "" {
+		return res, &uploadError{
+			status:  http.StatusUnsupportedMediaType,
+			code:    UPLOAD_NO_VIDEO_STREAM,
+			message: fmt.Sprintf("%s has no video stream", fileName),
+			file:    fileName,
+			details: gin.H{"format": res.Format},
+		}
+	}
+	return res, nil
+}
+
+// storeUpload writes src to a temporary file next to dst, validates it with
+// ffprobe then moves it to dst, or to the next free name. It returns the
+// final path.
+func storeUpload(src io.Reader, fileName, dst string) (string, utils.ProbeResult, error) {
+	tmp, err := writeTempFile(filepath.Dir(dst), src)
+	if err != nil {
+		return "", utils.ProbeResult{}, err
+	}
+	probe, err := probeUpload(tmp, fileName)
+	if err != nil {
+		os.Remove(tmp)
+		return "", probe, err
+	}
+	final, err := utils.RenameUnique(tmp, dst)
+	if err != nil {
+		os.Remove(tmp)
+		return "", probe, err
+	}
+	return final, probe, nil
+}
+
+// checkUploadFor runs the size and magic bytes checks of a file added to an
+// existing media, using the library holding its current file
+func checkUploadFor(currentPath string, fh *multipart.FileHeader) error {
+	file := uploadedFile(fh)
+	if lib, ok := utils.LibraryOf(currentPath); ok {
+		if err := checkUploadSize(lib, utils.LibraryRootOf(currentPath), file); err != nil {
+			return err
+		}
+	}
+	return sniffUpload(file)
+}
@@ -3,26 +3,72 @@ package handlers
 import (
 	"api/utils"
 	"context"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// POST /users
+// POST /users {name, role, maxRating, auth, secret} - auth "password" or
+// "pin" protects the profile with secret. Admins need one. During setup the
+// profile created with one becomes the admin.
 func CreateUser(c *gin.Context) {
-	var user utils.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var input struct {
+		Name      string `json:"name"`
+		Role      string `json:"role"`
+		MaxRating string `json:"maxRating"`
+		Auth      string `json:"auth"`
+		Secret    string `json:"secret"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Role == "" {
+		input.Role = utils.ROLE_STANDARD
+	}
+	if err := utils.ValidateRole(input.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkMaxRating(input.MaxRating); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	user.OnGoingMediasID = []primitive.ObjectID{}
+	user := utils.User{Name: input.Name, Role: input.Role, MaxRating: input.MaxRating, OnGoingMediasID: []primitive.ObjectID{}, Preferences: utils.DefaultPreferences()}
+	if input.Auth != utils.AUTH_NONE {
+		if err := utils.ValidateSecret(input.Auth, input.Secret); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hash, err := utils.HashSecret(input.Secret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		user.Auth, user.SecretHash = input.Auth, hash
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	setup, err := setupPending(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if setup && user.Auth != utils.AUTH_NONE {
+		user.Role = utils.ROLE_ADMIN
+	}
+	if user.Role == utils.ROLE_ADMIN && user.Auth == utils.AUTH_NONE {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "an admin needs a password or PIN"})
+		return
+	}
+
 	res, err := utils.GetCollection("users").InsertOne(ctx, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -37,12 +83,22 @@ func CreateUser(c *gin.Context) {
 	c.JSON(http.StatusCreated, user)
 }
 
-// GET /users
+// profileSummary is what the public profile picker gets: the full profile
+// (role, rating, preferences) stays behind GET /users/:id and /auth/me
+type profileSummary struct {
+	ID           primitive.ObjectID `json:"id"`
+	Name         string             `json:"name"`
+	Avatar       string             `json:"avatar,omitempty"`
+	AuthRequired bool               `json:"authRequired"`
+}
+
+// GET /users - profils affichés sur l'écran de choix
 func GetUsers(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := utils.GetCollection("users").Find(ctx, bson.M{})
+	opts := options.Find().SetProjection(bson.M{"name": 1, "avatar": 1, "auth": 1})
+	cursor, err := utils.GetCollection("users").Find(ctx, bson.M{}, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -52,7 +108,16 @@ func GetUsers(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, users)
+	profiles := make([]profileSummary, 0, len(users))
+	for _, u := range users {
+		profiles = append(profiles, profileSummary{
+			ID:           u.ID,
+			Name:         u.Name,
+			Avatar:       u.Avatar,
+			AuthRequired: u.Auth != utils.AUTH_NONE,
+		})
+	}
+	c.JSON(http.StatusOK, profiles)
 }
 
 // GET /users/:id
@@ -84,11 +149,28 @@ func DeleteUser(c *gin.Context) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if last, err := lastAdmin(ctx, objID); err != nil || last {
+		c.JSON(http.StatusConflict, gin.H{"error": "Impossible de supprimer le dernier administrateur"})
+		return
+	}
 	res, err := utils.GetCollection("users").DeleteOne(ctx, bson.M{"_id": objID})
 	if err != nil || res.DeletedCount == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Suppression impossible"})
 		return
 	}
+	if err := utils.DeleteSessions(ctx, bson.M{"userID": objID}); err != nil {
+		fmt.Println("Session cleanup error:", err)
+	}
+	if _, err := utils.GetCollection("watch_history").DeleteMany(ctx, bson.M{"user": objID}); err != nil {
+		fmt.Println("Watch history cleanup error:", err)
+	}
+	if _, err := utils.GetCollection("watchlist").DeleteMany(ctx, bson.M{"user": objID}); err != nil {
+		fmt.Println("Watchlist cleanup error:", err)
+	}
+	if err := deleteUserRatings(ctx, objID); err != nil {
+		fmt.Println("Ratings cleanup error:", err)
+	}
+	utils.RemoveAvatarFiles(objID)
 	c.JSON(http.StatusOK, gin.H{"deleted": res.DeletedCount})
 }
 
@@ -100,6 +182,9 @@ func ChangeUserName(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalide"})
 		return
 	}
+	if !requireSelf(c, objID) {
+		return
+	}
 
 	var input struct {
 		Name string `json:"name"`
@@ -119,3 +204,121 @@ func ChangeUserName(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Nom mis à jour"})
 }
+
+// PUT /users/:id/role {role}
+func UpdateUserRole(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalide"})
+		return
+	}
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ValidateRole(input.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if input.Role != utils.ROLE_ADMIN {
+		if last, err := lastAdmin(ctx, objID); err != nil || last {
+			c.JSON(http.StatusConflict, gin.H{"error": "Il faut garder au moins un administrateur"})
+			return
+		}
+	} else {
+		n, err := utils.GetCollection("users").CountDocuments(ctx, bson.M{"_id": objID, "auth": bson.M{"$in": protectedAuths}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if n == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "an admin needs a password or PIN"})
+			return
+		}
+	}
+	res, err := utils.GetCollection("users").UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"role": input.Role}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Utilisateur non trouvé"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": input.Role})
+}
+
+// PUT /users/:id/max_rating {maxRating} - highest certification the profile
+// may watch, ex: "FR:-12", "" for no limit
+func UpdateUserMaxRating(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalide"})
+		return
+	}
+	var input struct {
+		MaxRating string `json:"maxRating"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkMaxRating(input.MaxRating); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	update := bson.M{"$set": bson.M{"maxRating": input.MaxRating}}
+	if input.MaxRating == "" {
+		update = bson.M{"$unset": bson.M{"maxRating": ""}}
+	}
+	res, err := utils.GetCollection("users").UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Utilisateur non trouvé"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"maxRating": input.MaxRating})
+}
+
+// checkMaxRating validates a maximum rating, empty meaning no limit
+func checkMaxRating(rating string) error {
+	if _, ok := utils.CertificationAge(rating); rating != "" && !ok {
+		return fmt.Errorf("unknown certification %q (expected ex: FR:-12, US:PG-13)", rating)
+	}
+	return nil
+}
+
+// protectedAuths are the auth kinds of profiles able to log in
+var protectedAuths = bson.A{utils.AUTH_PASSWORD, utils.AUTH_PIN}
+
+// setupPending tells whether no admin able to log in exists yet, the first
+// one being then created without a session
+func setupPending(ctx context.Context) (bool, error) {
+	n, err := utils.GetCollection("users").CountDocuments(ctx, bson.M{"role": utils.ROLE_ADMIN, "auth": bson.M{"$in": protectedAuths}})
+	return n == 0, err
+}
+
+// lastAdmin tells whether the user is the only admin left
+func lastAdmin(ctx context.Context, userID primitive.ObjectID) (bool, error) {
+	admins, err := utils.GetCollection("users").CountDocuments(ctx, bson.M{"role": utils.ROLE_ADMIN, "_id": bson.M{"$ne": userID}})
+	if err != nil {
+		return false, err
+	}
+	if admins > 0 {
+		return false, nil
+	}
+	n, err := utils.GetCollection("users").CountDocuments(ctx, bson.M{"_id": userID, "role": utils.ROLE_ADMIN})
+	return n > 0, err
+}
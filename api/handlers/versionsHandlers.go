@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// versionedMedia is the part of a movie or episode document shared by the
+// file and version endpoints
+type versionedMedia struct {
+	ID        primitive.ObjectID   `bson:"_id"`
+	TmdbID    int                  `bson:"tmdbID"`
+	FilePath  string               `bson:"filePath"`
+	Versions  []utils.MediaVersion `bson:"versions"`
+	MediaInfo *utils.ProbeResult   `bson:"mediaInfo"`
+}
+
+// hlsKey is the id used for the media HLS cache folder
+func (m versionedMedia) hlsKey(kind string) string {
+	if kind == "movie" {
+		return strconv.Itoa(m.TmdbID)
+	}
+	return m.ID.Hex()
+}
+
+// loadVersionedMedia loads the movie (by Mongo ID or tmdbID) or episode of the
+// :id param, writing the error response itself
+func loadVersionedMedia(c *gin.Context, ctx context.Context, kind string) (*mongo.Collection, versionedMedia, bool) {
+	var media versionedMedia
+	coll := utils.GetCollection("movies")
+	var filter bson.M
+	if kind == "movie" {
+		f, err := mediaFilter(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, media, false
+		}
+		filter = f
+	} else {
+		coll = utils.GetCollection("episodes")
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode id"})
+			return nil, media, false
+		}
+		filter = bson.M{"_id": objID}
+	}
+
+	if err := coll.FindOne(ctx, filter).Decode(&media); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": kind + " not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil, media, false
+	}
+	return coll, media, true
+}
+
+// clientMaxHeight returns the highest video resolution the client wants: the
+// maxHeight query param, the X-Max-Height header, or 1080 for mobile devices
+func clientMaxHeight(c *gin.Context) int {
+	if h, err := strconv.Atoi(c.Query("maxHeight")); err == nil {
+		return h
+	}
+	if h, err := strconv.Atoi(c.GetHeader("X-Max-Height")); err == nil {
+		return h
+	}
+	ua := c.GetHeader("User-Agent")
+	if strings.Contains(ua, "Mobile") || strings.Contains(ua, "Android") || strings.Contains(ua, "iPhone") {
+		return 1080
+	}
+	return 0
+}
+
+// selectMediaVersion applies the ?version= selector or the client defaults
+func selectMediaVersion(c *gin.Context, defaultPath string, versions []utils.MediaVersion) (utils.MediaVersion, bool) {
+	return utils.SelectVersion(defaultPath, versions, c.Query("version"), clientMaxHeight(c))
+}
+
+// findVersionIndex looks up a version by ID, "default" designating the one at defaultPath
+func findVersionIndex(versions []utils.MediaVersion, defaultPath, id string) int {
+	for i, v := range versions {
+		if v.ID.Hex() == id || (id == "default" && v.FilePath == defaultPath) {
+			return i
+		}
+	}
+	return -1
+}
+
+// POST /movie/:id/versions, /episode/:id/versions
+func AddMovieVersion(c *gin.Context)   { addVersion(c, "movie") }
+func AddEpisodeVersion(c *gin.Context) { addVersion(c, "episode") }
+
+// PUT /movie/:id/versions/:version, /episode/:id/versions/:version
+func UpdateMovieVersion(c *gin.Context)   { updateVersion(c, "movie") }
+func UpdateEpisodeVersion(c *gin.Context) { updateVersion(c, "episode") }
+
+// DELETE /movie/:id/versions/:version, /episode/:id/versions/:version
+func DeleteMovieVersion(c *gin.Context)   { deleteVersion(c, "movie") }
+func DeleteEpisodeVersion(c *gin.Context) { deleteVersion(c, "episode") }
+
+// addVersion stores an extra file next to the default one, named
+// "<name> - <label><ext>". Form fields: file, label, edition, resolution,
+// source, default ("true" to make it the default version).
+func addVersion(c *gin.Context, kind string) {
+	label := strings.TrimSpace(c.PostForm("label"))
+	if label == "" {
+		label = strings.TrimSpace(c.PostForm("edition") + " " + c.PostForm("resolution"))
+	}
+	if label == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label is required"})
+		return
+	}
+
+	findCtx, findCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	coll, media, ok := loadVersionedMedia(c, findCtx, kind)
+	findCancel()
+	if !ok {
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to get file: %s", err.Error())})
+		return
+	}
+	defer file.Close()
+	if err := checkUploadFor(media.FilePath, header); err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	dir := filepath.Dir(media.FilePath)
+	base := strings.TrimSuffix(filepath.Base(media.FilePath), videoExt(media.FilePath))
+	ext := videoExt(header.Filename)
+	name := utils.TruncateName(fmt.Sprintf("%s - %s%s", base, utils.SanitizeName(label), ext), utils.MaxNameBytes)
+	dst := filepath.Join(dir, name)
+
+	dst, _, err = storeUpload(file, header.Filename, dst)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	version := utils.MediaVersion{
+		ID:         primitive.NewObjectID(),
+		Label:      label,
+		Edition:    c.PostForm("edition"),
+		Resolution: c.PostForm("resolution"),
+		Source:     c.PostForm("source"),
+		FilePath:   dst,
+		Format:     ext,
+		Date:       primitive.NewDateTimeFromTime(time.Now()),
+	}
+	versions := append(utils.MaterializeVersions(media.FilePath, media.Versions), version)
+	set := bson.M{"versions": versions}
+	if c.PostForm("default") == "true" {
+		set["filePath"] = dst
+		if kind == "movie" {
+			set["format"] = ext
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": media.ID}, bson.M{"$set": set}); err != nil {
+		os.Remove(dst)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// The root HLS cache only serves single-version media
+	removeHLSCache(kind, media.hlsKey(kind))
+
+	c.JSON(http.StatusCreated, version)
+}
+
+// updateVersion edits the labels of a version or makes it the default one
+func updateVersion(c *gin.Context, kind string) {
+	var input struct {
+		Label      *string `json:"label"`
+		Edition    *string `json:"edition"`
+		Resolution *string `json:"resolution"`
+		Source     *string `json:"source"`
+		Default    bool    `json:"default"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	coll, media, ok := loadVersionedMedia(c, ctx, kind)
+	if !ok {
+		return
+	}
+	versions := utils.MaterializeVersions(media.FilePath, media.Versions)
+	idx := findVersionIndex(versions, media.FilePath, c.Param("version"))
+	if idx < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+
+	v := &versions[idx]
+	if input.Label != nil {
+		v.Label = *input.Label
+	}
+	if input.Edition != nil {
+		v.Edition = *input.Edition
+	}
+	if input.Resolution != nil {
+		v.Resolution = *input.Resolution
+	}
+	if input.Source != nil {
+		v.Source = *input.Source
+	}
+	set := bson.M{"versions": versions}
+	if input.Default && v.FilePath != media.FilePath {
+		set["filePath"] = v.FilePath
+		if kind == "movie" {
+			set["format"] = v.Format
+		}
+	}
+
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": media.ID}, bson.M{"$set": set}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, isDefault := set["filePath"]; isDefault {
+		removeHLSCache(kind, media.hlsKey(kind))
+		refreshFileSteps(kind, media.ID)
+	}
+
+	c.JSON(http.StatusOK, versions[idx])
+}
+
+// deleteVersion removes a version (never the last one) and optionally its file
+// with ?deleteFiles=true. Deleting the default promotes the first remaining one.
+func deleteVersion(c *gin.Context, kind string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	coll, media, ok := loadVersionedMedia(c, ctx, kind)
+	if !ok {
+		return
+	}
+	versions := utils.MaterializeVersions(media.FilePath, media.Versions)
+	idx := findVersionIndex(versions, media.FilePath, c.Param("version"))
+	if idx < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+	if len(versions) == 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot delete the only version, delete the " + kind + " instead"})
+		return
+	}
+
+	removed := versions[idx]
+	versions = append(versions[:idx], versions[idx+1:]...)
+	set := bson.M{"versions": versions}
+	if removed.FilePath == media.FilePath {
+		set["filePath"] = versions[0].FilePath
+		if kind == "movie" {
+			set["format"] = versions[0].Format
+		}
+	}
+
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": media.ID}, bson.M{"$set": set}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, isDefault := set["filePath"]; isDefault {
+		removeHLSCache(kind, media.hlsKey(kind))
+		refreshFileSteps(kind, media.ID)
+	}
+	removeHLSCache(kind, filepath.Join(media.hlsKey(kind), "v", removed.ID.Hex()))
+
+	if c.Query("deleteFiles") == "true" {
+		if err := removeMediaFile(removed.FilePath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "version deleted but failed to remove file: " + err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": 1})
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"api/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// libraryTitle is what the watchlist needs of a movie or series of the library
+type libraryTitle struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Title   string             `bson:"title"`
+	TmdbID  int                `bson:"tmdbID"`
+	Poster  string             `bson:"poster"`
+	Library string             `bson:"library"`
+	MinAge  *int               `bson:"minAge"`
+}
+
+// watchlistCollection is the library collection of a watchlist type
+func watchlistCollection(typ string) string {
+	if typ == "series" {
+		return "series"
+	}
+	return "movies"
+}
+
+// libraryTitles loads the library titles of the given type and TMDB IDs, by TMDB ID
+func libraryTitles(ctx context.Context, typ string, tmdbIDs []int) (map[int]libraryTitle, error) {
+	titles := map[int]libraryTitle{}
+	if len(tmdbIDs) == 0 {
+		return titles, nil
+	}
+	cursor, err := utils.GetCollection(watchlistCollection(typ)).Find(ctx, bson.M{"tmdbID": bson.M{"$in": tmdbIDs}},
+		options.Find().SetProjection(bson.M{"title": 1, "tmdbID": 1, "poster": 1, "library": 1, "minAge": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []libraryTitle
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	for _, d := range docs {
+		titles[d.TmdbID] = d
+	}
+	return titles, nil
+}
+
+// GET /watchlist?type=movie|series&available=true|false - the user's list, in order
+func GetWatchlist(c *gin.Context) {
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	filter := bson.M{"user": userID}
+	switch typ := c.Query("type"); typ {
+	case "":
+	case "movie", "series":
+		filter["type"] = typ
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be movie or series"})
+		return
+	}
+	switch c.Query("available") {
+	case "":
+	case "true":
+		filter["available"] = true
+	case "false":
+		filter["available"] = false
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "available must be true or false"})
+		return
+	}
+
+	ctx, cancel := getDBContext()
+	defer cancel()
+	cursor, err := utils.GetCollection("watchlist").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "position", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var items []utils.WatchlistItem
+	if err := cursor.All(ctx, &items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tmdbIDs := map[string][]int{}
+	for _, it := range items {
+		if it.Available {
+			tmdbIDs[it.Type] = append(tmdbIDs[it.Type], it.TmdbID)
+		}
+	}
+	titles := map[string]map[int]libraryTitle{}
+	for _, typ := range []string{"movie", "series"} {
+		if titles[typ], err = libraryTitles(ctx, typ, tmdbIDs[typ]); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	out := []gin.H{}
+	for _, it := range items {
+		entry := gin.H{
+			"id": it.ID, "type": it.Type, "tmdbID": it.TmdbID, "title": it.Title, "poster": it.Poster,
+			"position": it.Position, "available": it.Available, "imported": it.Imported, "added": it.Added,
+		}
+		if t, ok := titles[it.Type][it.TmdbID]; ok {
+			// Titles the profile isn't allowed to see are left out
+			if !canWatch(c, t.Library, t.MinAge) {
+				continue
+			}
+			entry["mediaId"], entry["title"], entry["poster"], entry["library"] = t.ID, t.Title, t.Poster, t.Library
+		}
+		out = append(out, entry)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// errHiddenTitle is returned for a library title the profile isn't allowed to see
+var errHiddenTitle = errors.New("title not found")
+
+// watchlistMetadata returns the title and poster of a title, from the library
+// or else from TMDB, and whether it is in the library
+func watchlistMetadata(c *gin.Context, ctx context.Context, typ string, tmdbID int) (string, string, bool, error) {
+	titles, err := libraryTitles(ctx, typ, []int{tmdbID})
+	if err != nil {
+		return "", "", false, err
+	}
+	if t, ok := titles[tmdbID]; ok {
+		if !canWatch(c, t.Library, t.MinAge) {
+			return "", "", false, errHiddenTitle
+		}
+		return t.Title, t.Poster, true, nil
+	}
+
+	if typ == "series" {
+		show, err := utils.GetTmdbShow(ctx, tmdbID)
+		if err != nil {
+			return "", "", false, err
+		}
+		return show.Name, show.PosterPath, false, nil
+	}
+	movie, err := utils.GetTmdbMovie(ctx, tmdbID)
+	if err != nil {
+		return "", "", false, err
+	}
+	return movie.Title, movie.PosterPath, false, nil
+}
+
+// POST /watchlist {type, tmdbID, title, poster} - add a title at the end of
+// the list. title and poster are only used for titles missing from the
+// library when TMDB can't be reached.
+func AddToWatchlist(c *gin.Context) {
+	var input struct {
+		Type   string `json:"type" binding:"required,oneof=movie series"`
+		TmdbID int    `json:"tmdbID" binding:"required"`
+		Title  string `json:"title"`
+		Poster string `json:"poster"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	coll := utils.GetCollection("watchlist")
+	var existing utils.WatchlistItem
+	if err := coll.FindOne(ctx, bson.M{"user": userID, "type": input.Type, "tmdbID": input.TmdbID}).Decode(&existing); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "already in watchlist", "item": existing})
+		return
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	title, poster, available, err := watchlistMetadata(c, ctx, input.Type, input.TmdbID)
+	if errors.Is(err, errHiddenTitle) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		if input.Title == "" {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "TMDB lookup failed: " + err.Error()})
+			return
+		}
+		title, poster = input.Title, input.Poster
+	}
+
+	// Appended after the last item
+	position := 0
+	var last utils.WatchlistItem
+	if err := coll.FindOne(ctx, bson.M{"user": userID}, options.FindOne().SetSort(bson.D{{Key: "position", Value: -1}})).Decode(&last); err == nil {
+		position = last.Position + 1
+	}
+	item := utils.WatchlistItem{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Type:      input.Type,
+		TmdbID:    input.TmdbID,
+		Title:     title,
+		Poster:    poster,
+		Position:  position,
+		Available: available,
+		Added:     time.Now(),
+	}
+	if _, err := coll.InsertOne(ctx, item); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "already in watchlist"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, item)
+}
+
+// DELETE /watchlist/:id
+func RemoveFromWatchlist(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := getDBContext()
+	defer cancel()
+	res, err := utils.GetCollection("watchlist").DeleteOne(ctx, bson.M{"_id": objID, "user": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if res.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Watchlist item not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": 1})
+}
+
+// PUT /watchlist/order {ids} - the listed items first, in the given order;
+// the others keep their order after them
+func ReorderWatchlist(c *gin.Context) {
+	var input struct {
+		IDs []primitive.ObjectID `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := getDBContext()
+	defer cancel()
+	coll := utils.GetCollection("watchlist")
+	cursor, err := coll.Find(ctx, bson.M{"user": userID}, options.Find().SetSort(bson.D{{Key: "position", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var items []utils.WatchlistItem
+	if err := cursor.All(ctx, &items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	owned := map[primitive.ObjectID]bool{}
+	for _, it := range items {
+		owned[it.ID] = true
+	}
+	order := make([]primitive.ObjectID, 0, len(items))
+	placed := map[primitive.ObjectID]bool{}
+	for _, id := range input.IDs {
+		if !owned[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown watchlist item %s", id.Hex())})
+			return
+		}
+		if !placed[id] {
+			placed[id] = true
+			order = append(order, id)
+		}
+	}
+	for _, it := range items {
+		if !placed[it.ID] {
+			order = append(order, it.ID)
+		}
+	}
+	if len(order) == 0 {
+		c.JSON(http.StatusOK, gin.H{"ids": order})
+		return
+	}
+
+	models := make([]mongo.WriteModel, len(order))
+	for i, id := range order {
+		models[i] = mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": id, "user": userID}).SetUpdate(bson.M{"$set": bson.M{"position": i}})
+	}
+	if _, err := coll.BulkWrite(ctx, models); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ids": order})
+}
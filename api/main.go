@@ -2,6 +2,8 @@ package main
 
 import (
 	"api/handlers"
+	"api/utils"
+	"context"
 	"log"
 
 	"github.com/gin-contrib/cors"
@@ -16,12 +18,27 @@ func main() {
 		log.Printf("Note: .env file not found, using environment variables from system")
 	}
 
+	if err := utils.LoadLibraries(); err != nil {
+		log.Fatalf("failed to load libraries: %v", err)
+	}
+	if err := utils.ValidateLayout(); err != nil {
+		log.Fatalf("invalid layout: %v", err)
+	}
+	if err := utils.RunMigrations(); err != nil {
+		log.Fatalf("failed to migrate database: %v", err)
+	}
+	if utils.AuthDisabled() {
+		log.Printf("Warning: AUTH_DISABLED=true, anyone reaching the API can manage profiles and media")
+	}
+	go utils.FillSeasonNames(context.Background())
+	go handlers.PurgeStaleOnGoing(context.Background())
+
 	r := gin.Default()
 	corsCfg := cors.Config{
 		AllowAllOrigins: true,
 		AllowMethods:    []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:    []string{"Origin", "Content-Type", "Accept", "Last-Event-ID"},
-		ExposeHeaders:   []string{"Content-Type"},
+		AllowHeaders:    []string{"Origin", "Content-Type", "Accept", "Authorization", "Last-Event-ID"},
+		ExposeHeaders:   []string{"Content-Type", "Retry-After"},
 	}
 	r.Use(cors.New(corsCfg))
 
@@ -33,38 +50,143 @@ func main() {
 		c.Status(200)
 	})
 
-	// Users
-	r.POST("/users", handlers.CreateUser)
+	// Profiles are listed before login to pick one. Until an admin with a
+	// password or PIN exists, one can be created without a session.
 	r.GET("/users", handlers.GetUsers)
-	r.GET("/users/:id", handlers.GetUserByID)
-	r.DELETE("/users/:id", handlers.DeleteUser)
-	r.POST("/users/change_name/:id", handlers.ChangeUserName)
+	r.POST("/users", handlers.RequireAuthOrSetup(utils.ROLE_ADMIN), handlers.CreateUser)
+
+	// Auth (AUTH_DISABLED=true skips it)
+	r.GET("/auth/status", handlers.GetAuthStatus)
+	r.POST("/auth/login", handlers.Login)
+	r.POST("/auth/refresh", handlers.RefreshToken)
+
+	// Avatars, shown on the profile picker
+	r.GET("/avatars", handlers.GetAvatarPresets)
+	r.GET("/avatars/:key", handlers.AvatarPresetHandler)
+	r.GET("/users/:id/avatar", handlers.UserAvatarHandler)
+
+	// Posters downloaded at ingest
+	r.GET("/poster/:id", handlers.PosterHandler)
+	r.GET("/series/:id/poster", handlers.SeriesPosterHandler)
+
+	// Stream (?version= selects a movie/episode version, default chosen per client).
+	// A signed URL or a session is required.
+	movieStream, episodeStream := handlers.RequireStreamAccess("movie"), handlers.RequireStreamAccess("episode")
+	r.GET("/video/:id", movieStream, handlers.VideoStreamHandler)
+	r.GET("/video/episode/:id", episodeStream, handlers.EpisodeStreamHandler)
+	r.GET("/video/:id/chapters", movieStream, handlers.MovieChaptersHandler)
+	r.GET("/video/episode/:id/chapters", episodeStream, handlers.EpisodeChaptersHandler)
+
+	// HLS endpoints (master + assets via wildcard handler), playlists carry the signature
+	r.GET("/hls/movie/:id/*asset", movieStream, handlers.HLSMovieAsset)
+	r.GET("/hls/episode/:id/*asset", episodeStream, handlers.HLSEpisodeAsset)
+
+	// Seek thumbnails generated by the pipeline
+	r.GET("/trickplay/:type/:id/*asset", handlers.RequireStreamAccess(""), handlers.TrickplayAsset)
+
+	// Everything else needs a session
+	api := r.Group("/", handlers.RequireAuth())
+	api.POST("/auth/logout", handlers.Logout)
+	api.GET("/auth/me", handlers.GetMe)
+
+	// Ingestion, deletion and profile management are reserved to admins
+	admin := api.Group("", handlers.RequireRole(utils.ROLE_ADMIN))
+
+	// Users
+	api.GET("/users/:id", handlers.GetUserByID)
+	admin.DELETE("/users/:id", handlers.DeleteUser)
+	admin.PUT("/users/:id/role", handlers.UpdateUserRole)
+	admin.PUT("/users/:id/max_rating", handlers.UpdateUserMaxRating)
+	api.POST("/users/change_name/:id", handlers.ChangeUserName)
+	api.PUT("/users/:id/secret", handlers.UpdateUserSecret)
+	api.PUT("/users/:id/avatar", handlers.UpdateUserAvatar)
+	api.GET("/users/:id/preferences", handlers.GetUserPreferences)
+	api.PATCH("/users/:id/preferences", handlers.PatchUserPreferences)
+
+	// Libraries
+	api.GET("/libraries", handlers.GetLibraries)
+	admin.POST("/libraries", handlers.CreateLibrary)
+	admin.PUT("/libraries/:key", handlers.UpdateLibrary)
+	admin.DELETE("/libraries/:key", handlers.DeleteLibrary)
+	admin.POST("/libraries/:key/reorganize", handlers.ReorganizeLibrary)
 
 	// Movies
-	r.POST("/movies", handlers.UploadMovie)
-	r.GET("/movies", handlers.GetMovies)
-	r.GET("/movie/:id", handlers.GetMovieByID)
+	admin.POST("/movies", handlers.UploadMovie)
+	api.GET("/movies", handlers.GetMovies)
+	api.GET("/movie/:id", handlers.GetMovieByID)
+	admin.PUT("/movie/:id", handlers.UpdateMovie)
+	admin.DELETE("/movie/:id", handlers.DeleteMovie)
+	admin.POST("/movie/:id/file", handlers.ReplaceMovieFile)
+	admin.POST("/movie/:id/reorganize", handlers.ReorganizeMovie)
+	admin.POST("/movie/:id/pipeline/:step", handlers.RunMoviePipelineStep)
+	admin.POST("/movie/:id/versions", handlers.AddMovieVersion)
+	admin.PUT("/movie/:id/versions/:version", handlers.UpdateMovieVersion)
+	admin.DELETE("/movie/:id/versions/:version", handlers.DeleteMovieVersion)
 
 	// Series
-	r.POST("/series", handlers.CreateSeries)
-	r.GET("/series", handlers.GetAllSeries)
-	r.GET("/series/:id", handlers.GetSeriesByID)
-	r.GET("/episode/:id", handlers.GetEpisodeByID)
+	admin.POST("/series", handlers.CreateSeries)
+	api.GET("/series", handlers.GetAllSeries)
+	api.GET("/series/:id", handlers.GetSeriesByID)
+	admin.PUT("/series/:id", handlers.UpdateSeries)
+	admin.DELETE("/series/:id", handlers.DeleteSeries)
+	admin.POST("/series/:id/reorganize", handlers.ReorganizeSeries)
+	api.GET("/series/:id/missing", handlers.GetSeriesMissing)
+	api.GET("/missing", handlers.GetLibraryMissing)
+	admin.POST("/series/:id/seasons/refresh", handlers.RefreshSeasons)
+	api.GET("/series/:id/seasons/:season", handlers.GetSeason)
+	admin.DELETE("/series/:id/seasons/:season", handlers.DeleteSeason)
+	api.GET("/episode/:id", handlers.GetEpisodeByID)
+	admin.PUT("/episode/:id", handlers.UpdateEpisode)
+	admin.DELETE("/episode/:id", handlers.DeleteEpisode)
+	admin.POST("/episode/:id/file", handlers.ReplaceEpisodeFile)
+	admin.POST("/episode/:id/pipeline/:step", handlers.RunEpisodePipelineStep)
+	admin.POST("/episode/:id/versions", handlers.AddEpisodeVersion)
+	admin.PUT("/episode/:id/versions/:version", handlers.UpdateEpisodeVersion)
+	admin.DELETE("/episode/:id/versions/:version", handlers.DeleteEpisodeVersion)
+
+	// Signed stream URLs, for <video> elements and cast devices
+	api.GET("/stream/:type/:id", handlers.GetStreamURLs)
 
-	// Stream
-	r.GET("/video/:id", handlers.VideoStreamHandler)
-	r.GET("/video/episode/:id", handlers.EpisodeStreamHandler)
-	r.GET("/video/:id/chapters", handlers.MovieChaptersHandler)
-	r.GET("/video/episode/:id/chapters", handlers.EpisodeChaptersHandler)
+	// Version and tracks to play according to the user preferences
+	api.GET("/playback/:type/:id", handlers.GetPlaybackDecision)
 
-	// HLS endpoints (master + assets via wildcard handler)
-	r.GET("/hls/movie/:id/*asset", handlers.HLSMovieAsset)
-	r.GET("/hls/episode/:id/*asset", handlers.HLSEpisodeAsset)
+	// Imports from the server inbox (INBOX_DIR)
+	admin.GET("/import/inbox", handlers.GetInbox)
+	admin.POST("/import", handlers.ImportMedia)
+
+	// Jobs (ingest progress, ?async=true on uploads)
+	admin.GET("/jobs", handlers.GetJobs)
+	admin.GET("/jobs/:id", handlers.GetJob)
+	admin.DELETE("/jobs/:id", handlers.CancelJob)
+	admin.GET("/jobs/:id/events", handlers.JobEvents)
 
 	// Ongoing Media (unified)
-	r.POST("/ongoing_media", handlers.UpdateOnGoingMedia)
-	r.GET("/ongoing_media/:id", handlers.GetOnGoingMediaByUserID)
-	r.DELETE("/ongoing_media/:id", handlers.DeleteOnGoingMedia)
+	api.POST("/ongoing_media", handlers.UpdateOnGoingMedia)
+	api.GET("/ongoing_media/:id", handlers.GetOnGoingMediaByUserID)
+	api.DELETE("/ongoing_media/:id", handlers.DeleteOnGoingMedia)
+
+	// Watch history (per user)
+	api.PUT("/watched/movie/:id", handlers.SetMovieWatched)
+	api.PUT("/watched/episode/:id", handlers.SetEpisodeWatched)
+	api.PUT("/watched/series/:id", handlers.SetSeriesWatched)
+	api.PUT("/watched/series/:id/seasons/:season", handlers.SetSeasonWatched)
+	api.GET("/history", handlers.GetHistory)
+	api.GET("/history/series/:id", handlers.GetSeriesHistory)
+
+	// Ratings and notes (per user, household average on the media)
+	api.GET("/ratings", handlers.GetMyRatings)
+	api.GET("/ratings/:type/:id", handlers.GetMediaRatings)
+	api.PUT("/ratings/:type/:id", handlers.RateMedia)
+	api.DELETE("/ratings/:type/:id", handlers.DeleteRating)
+
+	// Home-screen rows
+	api.GET("/recommendations", handlers.GetRecommendations)
+
+	// Watchlist ("My List")
+	api.GET("/watchlist", handlers.GetWatchlist)
+	api.POST("/watchlist", handlers.AddToWatchlist)
+	api.PUT("/watchlist/order", handlers.ReorderWatchlist)
+	api.DELETE("/watchlist/:id", handlers.RemoveFromWatchlist)
 
 	log.Println("Server starting on :8080")
 	r.Run(":8080")
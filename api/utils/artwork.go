@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ArtworkPath is where the poster of a movie ("movie") or series ("series")
+// is kept, under POSTERS_DIR (default "uploads")
+func ArtworkPath(kind string, tmdbID int) string {
+	base := os.Getenv("POSTERS_DIR")
+	if base == "" {
+		base = "uploads"
+	}
+	if kind == "series" {
+		return filepath.Join(base, "series", strconv.Itoa(tmdbID)+".jpg")
+	}
+	return filepath.Join(base, strconv.Itoa(tmdbID)+".jpg")
+}
+
+// DownloadPoster stores a poster locally so the library doesn't depend on
+// TMDB being reachable. poster is a TMDB image path ("/abc.jpg") or a URL.
+func DownloadPoster(ctx context.Context, kind string, tmdbID int, poster string) (string, error) {
+	if poster == "" {
+		return "", fmt.Errorf("no poster")
+	}
+	url := poster
+	if !strings.HasPrefix(poster, "http://") && !strings.HasPrefix(poster, "https://") {
+		base := os.Getenv("TMDB_IMAGE_URL")
+		if base == "" {
+			base = "https://image.tmdb.org/t/p/original"
+		}
+		url = strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(poster, "/")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := tmdbClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("poster %s: %s", url, resp.Status)
+	}
+
+	dst := ArtworkPath(kind, tmdbID)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".poster-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return dst, nil
+}
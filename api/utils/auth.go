@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// How a profile proves its identity
+const (
+	AUTH_NONE     = ""         // Household profile, anyone can pick it
+	AUTH_PASSWORD = "password" // At least 6 characters
+	AUTH_PIN      = "pin"      // 4 to 8 digits
+)
+
+// Default lifetimes of the session tokens
+const (
+	DEFAULT_ACCESS_TTL  = time.Hour
+	DEFAULT_REFRESH_TTL = 30 * 24 * time.Hour
+)
+
+// ErrInvalidToken is returned for unknown, expired or revoked tokens
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Session is a login of a user. Only hashes of its tokens are stored, so a
+// database leak doesn't leak usable tokens.
+type Session struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	UserID         primitive.ObjectID `json:"userId" bson:"userID"`
+	AccessHash     string             `json:"-" bson:"accessHash"`
+	AccessExpires  time.Time          `json:"accessExpires" bson:"accessExpires"`
+	RefreshHash    string             `json:"-" bson:"refreshHash"`
+	RefreshExpires time.Time          `json:"refreshExpires" bson:"refreshExpires"` // TTL index: expired sessions are purged
+	UserAgent      string             `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	Created        time.Time          `json:"created" bson:"created"`
+}
+
+// SessionTokens are handed to the client once, at login or refresh
+type SessionTokens struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // Seconds of validity of the access token
+}
+
+// AuthDisabled turns authentication off (AUTH_DISABLED=true), for setups
+// behind their own authenticating proxy. It is on by default.
+func AuthDisabled() bool {
+	return os.Getenv("AUTH_DISABLED") == "true"
+}
+
+// sessionTTLs reads AUTH_ACCESS_TTL and AUTH_REFRESH_TTL (Go durations, ex: "2h")
+func sessionTTLs() (access, refresh time.Duration) {
+	access, refresh = DEFAULT_ACCESS_TTL, DEFAULT_REFRESH_TTL
+	if d, err := time.ParseDuration(os.Getenv("AUTH_ACCESS_TTL")); err == nil && d > 0 {
+		access = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUTH_REFRESH_TTL")); err == nil && d > access {
+		refresh = d
+	}
+	return access, refresh
+}
+
+// ValidateSecret checks a new password or PIN
+func ValidateSecret(kind, secret string) error {
+	switch kind {
+	case AUTH_PASSWORD:
+		if len([]rune(secret)) < 6 {
+			return errors.New("password must be at least 6 characters")
+		}
+	case AUTH_PIN:
+		if len(secret) < 4 || len(secret) > 8 || strings.IndexFunc(secret, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0 {
+			return errors.New("PIN must be 4 to 8 digits")
+		}
+	default:
+		return fmt.Errorf("invalid auth kind %q", kind)
+	}
+	return nil
+}
+
+// HashSecret hashes a password or PIN with bcrypt
+func HashSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckSecret tells whether secret matches the user's password or PIN. A
+// profile without one accepts any secret, but can't log in (see Login).
+func CheckSecret(user User, secret string) bool {
+	if user.Auth == AUTH_NONE {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.SecretHash), []byte(secret)) == nil
+}
+
+// newToken returns a random opaque token and its stored hash
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTokens fills a session with fresh tokens
+func newTokens(s *Session) (SessionTokens, error) {
+	access, accessHash, err := newToken()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	refresh, refreshHash, err := newToken()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	accessTTL, refreshTTL := sessionTTLs()
+	now := time.Now()
+	s.AccessHash, s.AccessExpires = accessHash, now.Add(accessTTL)
+	s.RefreshHash, s.RefreshExpires = refreshHash, now.Add(refreshTTL)
+	return SessionTokens{AccessToken: access, RefreshToken: refresh, ExpiresIn: int(accessTTL.Seconds())}, nil
+}
+
+// CreateSession logs a user in
+func CreateSession(ctx context.Context, userID primitive.ObjectID, userAgent string) (Session, SessionTokens, error) {
+	s := Session{ID: primitive.NewObjectID(), UserID: userID, UserAgent: userAgent, Created: time.Now()}
+	tokens, err := newTokens(&s)
+	if err != nil {
+		return s, tokens, err
+	}
+	_, err = GetCollection("sessions").InsertOne(ctx, s)
+	return s, tokens, err
+}
+
+// SessionFromAccessToken returns the session of a valid access token
+func SessionFromAccessToken(ctx context.Context, token string) (Session, error) {
+	var s Session
+	err := GetCollection("sessions").FindOne(ctx, bson.M{
+		"accessHash":    hashToken(token),
+		"accessExpires": bson.M{"$gt": time.Now()},
+	}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return s, ErrInvalidToken
+	}
+	return s, err
+}
+
+// RefreshSession exchanges a refresh token for new tokens. Refresh tokens are
+// single use: the old pair stops working.
+func RefreshSession(ctx context.Context, refreshToken string) (Session, SessionTokens, error) {
+	var s Session
+	err := GetCollection("sessions").FindOne(ctx, bson.M{
+		"refreshHash":    hashToken(refreshToken),
+		"refreshExpires": bson.M{"$gt": time.Now()},
+	}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return s, SessionTokens{}, ErrInvalidToken
+	}
+	if err != nil {
+		return s, SessionTokens{}, err
+	}
+
+	oldRefresh := s.RefreshHash
+	tokens, err := newTokens(&s)
+	if err != nil {
+		return s, tokens, err
+	}
+	// Matching on the old hash makes concurrent refreshes fail but one
+	res, err := GetCollection("sessions").UpdateOne(ctx, bson.M{"_id": s.ID, "refreshHash": oldRefresh}, bson.M{"$set": bson.M{
+		"accessHash": s.AccessHash, "accessExpires": s.AccessExpires,
+		"refreshHash": s.RefreshHash, "refreshExpires": s.RefreshExpires,
+	}})
+	if err != nil {
+		return s, SessionTokens{}, err
+	}
+	if res.MatchedCount == 0 {
+		return s, SessionTokens{}, ErrInvalidToken
+	}
+	return s, tokens, nil
+}
+
+// DeleteSessions revokes sessions, ex: at logout or when a password changes
+func DeleteSessions(ctx context.Context, filter bson.M) error {
+	_, err := GetCollection("sessions").DeleteMany(ctx, filter)
+	return err
+}
+
+// Login throttling: after LOGIN_MAX_FAILURES wrong secrets a profile is locked
+// for LOGIN_LOCK_DURATION, which makes guessing a PIN impractical
+const (
+	LOGIN_MAX_FAILURES  = 5
+	LOGIN_LOCK_DURATION = 15 * time.Minute
+)
+
+type loginFailures struct {
+	count int
+	since time.Time
+}
+
+var (
+	loginMu       sync.Mutex
+	loginAttempts = map[primitive.ObjectID]loginFailures{}
+)
+
+// LoginLocked returns how long a profile stays locked, 0 when it isn't
+func LoginLocked(userID primitive.ObjectID) time.Duration {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+	f, ok := loginAttempts[userID]
+	if !ok {
+		return 0
+	}
+	left := LOGIN_LOCK_DURATION - time.Since(f.since)
+	if left <= 0 {
+		delete(loginAttempts, userID)
+		return 0
+	}
+	if f.count < LOGIN_MAX_FAILURES {
+		return 0
+	}
+	return left
+}
+
+// RecordLogin counts a failed attempt, or clears the failures on success
+func RecordLogin(userID primitive.ObjectID, success bool) {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+	if success {
+		delete(loginAttempts, userID)
+		return
+	}
+	f := loginAttempts[userID]
+	if f.count == 0 {
+		f.since = time.Now()
+	}
+	f.count++
+	loginAttempts[userID] = f
+}
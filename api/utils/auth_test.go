@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckSecret(t *testing.T) {
+	hash, err := HashSecret("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		user   User
+		secret string
+		want   bool
+	}{
+		{"right PIN", User{Auth: AUTH_PIN, SecretHash: hash}, "1234", true},
+		{"wrong PIN", User{Auth: AUTH_PIN, SecretHash: hash}, "4321", false},
+		{"empty secret", User{Auth: AUTH_PIN, SecretHash: hash}, "", false},
+		{"missing hash", User{Auth: AUTH_PASSWORD}, "1234", false},
+		{"unprotected profile", User{Auth: AUTH_NONE}, "anything", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckSecret(tt.user, tt.secret); got != tt.want {
+				t.Errorf("CheckSecret() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSecret(t *testing.T) {
+	tests := []struct {
+		kind, secret string
+		ok           bool
+	}{
+		{AUTH_PIN, "1234", true},
+		{AUTH_PIN, "12345678", true},
+		{AUTH_PIN, "123", false},
+		{AUTH_PIN, "123456789", false},
+		{AUTH_PIN, "12a4", false},
+		{AUTH_PASSWORD, "secret", true},
+		{AUTH_PASSWORD, "short", false},
+		{AUTH_NONE, "", false},
+		{"token", "whatever", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateSecret(tt.kind, tt.secret); (err == nil) != tt.ok {
+			t.Errorf("ValidateSecret(%q, %q) = %v, want ok=%v", tt.kind, tt.secret, err, tt.ok)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		success  bool          // A successful login after the failures
+		age      time.Duration // Time elapsed since the first failure
+		locked   bool
+	}{
+		{"no failure", 0, false, 0, false},
+		{"below the limit", LOGIN_MAX_FAILURES - 1, false, 0, false},
+		{"at the limit", LOGIN_MAX_FAILURES, false, 0, true},
+		{"above the limit", LOGIN_MAX_FAILURES + 3, false, 0, true},
+		{"lock expired", LOGIN_MAX_FAILURES, false, LOGIN_LOCK_DURATION + time.Second, false},
+		{"cleared by a success", LOGIN_MAX_FAILURES - 1, true, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := primitive.NewObjectID()
+			for i := 0; i < tt.failures; i++ {
+				RecordLogin(userID, false)
+			}
+			if tt.success {
+				RecordLogin(userID, true)
+			}
+			if tt.age > 0 {
+				loginMu.Lock()
+				f := loginAttempts[userID]
+				f.since = time.Now().Add(-tt.age)
+				loginAttempts[userID] = f
+				loginMu.Unlock()
+			}
+			left := LoginLocked(userID)
+			if (left > 0) != tt.locked {
+				t.Errorf("LoginLocked() = %v, want locked=%v", left, tt.locked)
+			}
+			if left > LOGIN_LOCK_DURATION {
+				t.Errorf("LoginLocked() = %v, longer than %v", left, LOGIN_LOCK_DURATION)
+			}
+		})
+	}
+}
+
+func TestLoginLockoutPerProfile(t *testing.T) {
+	locked, other := primitive.NewObjectID(), primitive.NewObjectID()
+	for i := 0; i < LOGIN_MAX_FAILURES; i++ {
+		RecordLogin(locked, false)
+	}
+	if LoginLocked(locked) == 0 {
+		t.Fatal("profile should be locked")
+	}
+	if LoginLocked(other) != 0 {
+		t.Error("another profile is locked too")
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"", false},
+		{"false", false},
+		{"1", false},
+		{"true", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("AUTH_DISABLED", tt.value)
+		if got := AuthDisabled(); got != tt.want {
+			t.Errorf("AuthDisabled() with AUTH_DISABLED=%q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AvatarPreset is an avatar profiles can pick instead of uploading a picture
+type AvatarPreset struct {
+	Key   string `json:"key"`
+	Color string `json:"color"`
+}
+
+// AVATAR_CUSTOM is the avatar of a profile with an uploaded picture
+const AVATAR_CUSTOM = "custom"
+
+// AVATAR_MAX_SIZE is the largest picture accepted for an avatar
+const AVATAR_MAX_SIZE = 2 << 20
+
+var AvatarPresets = []AvatarPreset{
+	{Key: "red", Color: "#e50914"},
+	{Key: "orange", Color: "#f5a623"},
+	{Key: "yellow", Color: "#f8e71c"},
+	{Key: "green", Color: "#2ecc71"},
+	{Key: "teal", Color: "#1abc9c"},
+	{Key: "blue", Color: "#3498db"},
+	{Key: "purple", Color: "#9b59b6"},
+	{Key: "pink", Color: "#e84393"},
+}
+
+// FindAvatarPreset looks up a preset by key
+func FindAvatarPreset(key string) (AvatarPreset, bool) {
+	for _, p := range AvatarPresets {
+		if p.Key == key {
+			return p, true
+		}
+	}
+	return AvatarPreset{}, false
+}
+
+// DefaultAvatarPreset is the preset of a profile that never chose one,
+// stable for a given profile
+func DefaultAvatarPreset(userID primitive.ObjectID) AvatarPreset {
+	sum := 0
+	for _, b := range userID {
+		sum += int(b)
+	}
+	return AvatarPresets[sum%len(AvatarPresets)]
+}
+
+// AvatarSVG draws a preset with the initial of the profile name
+func AvatarSVG(preset AvatarPreset, name string) []byte {
+	initial := "?"
+	for _, r := range strings.TrimSpace(name) {
+		initial = string(unicode.ToUpper(r))
+		break
+	}
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 128 128" width="128" height="128">`+
+		`<rect width="128" height="128" rx="16" fill="%s"/>`+
+		`<text x="64" y="64" dy=".35em" text-anchor="middle" font-family="Helvetica, Arial, sans-serif" font-size="64" font-weight="bold" fill="#ffffff">%s</text>`+
+		`</svg>`, preset.Color, html.EscapeString(initial)))
+}
+
+// AvatarDir is where uploaded avatars are kept (AVATARS_DIR, default
+// "uploads/avatars"), one file per profile named after its ID
+func AvatarDir() string {
+	if dir := os.Getenv("AVATARS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join("uploads", "avatars")
+}
+
+// RemoveAvatarFiles deletes the uploaded avatars of a profile
+func RemoveAvatarFiles(userID primitive.ObjectID) {
+	matches, _ := filepath.Glob(filepath.Join(AvatarDir(), userID.Hex()+".*"))
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
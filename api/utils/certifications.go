@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Content certifications are written "<country>:<rating>", ex: "FR:-12",
+// "US:PG-13". They are compared through the minimum age they stand for, so a
+// limit set in one country applies to content rated in another.
+var certificationAges = map[string]map[string]int{
+	"FR": {"U": 0, "TP": 0, "-10": 10, "-12": 12, "-16": 16, "-18": 18},
+	"US": {
+		"G": 0, "PG": 8, "PG-13": 13, "R": 17, "NC-17": 18,
+		"TV-Y": 0, "TV-G": 0, "TV-Y7": 7, "TV-PG": 8, "TV-14": 14, "TV-MA": 17,
+	},
+	"GB": {"U": 0, "PG": 8, "12A": 12, "12": 12, "15": 15, "18": 18, "R18": 18},
+	"DE": {"0": 0, "6": 6, "12": 12, "16": 16, "18": 18},
+}
+
+// CertificationAge returns the minimum age of a certification, false when
+// its country or rating is unknown
+func CertificationAge(cert string) (int, bool) {
+	country, rating, ok := strings.Cut(strings.TrimSpace(cert), ":")
+	if !ok {
+		return 0, false
+	}
+	age, ok := certificationAges[strings.ToUpper(country)][strings.ToUpper(strings.TrimSpace(rating))]
+	return age, ok
+}
+
+// CertificationWithin tells whether content rated cert may be shown under
+// the limit max. Unknown certifications are refused.
+func CertificationWithin(cert, max string) bool {
+	age, ok := CertificationAge(cert)
+	if !ok {
+		return false
+	}
+	limit, ok := CertificationAge(max)
+	return ok && age <= limit
+}
+
+// CertificationCountry is the country whose certification rates the content
+// (CERTIFICATION_COUNTRY, default FR)
+func CertificationCountry() string {
+	if country := os.Getenv("CERTIFICATION_COUNTRY"); country != "" {
+		return strings.ToUpper(country)
+	}
+	return "FR"
+}
+
+// RatingAge returns the minimum age of content from its certifications by
+// country: the one of CERTIFICATION_COUNTRY, else the strictest known one
+func RatingAge(certs map[string]string) (int, bool) {
+	country := CertificationCountry()
+	if age, ok := CertificationAge(country + ":" + certs[country]); ok {
+		return age, true
+	}
+	max, found := 0, false
+	for country, rating := range certs {
+		if age, ok := CertificationAge(country + ":" + rating); ok && (!found || age > max) {
+			max, found = age, true
+		}
+	}
+	return max, found
+}
+
+// CertificationFields returns the fields to store with the certifications of
+// a movie or series, minAge being null when none is known
+func CertificationFields(certs map[string]string) bson.M {
+	fields := bson.M{"certifications": certs, "minAge": nil}
+	if age, ok := RatingAge(certs); ok {
+		fields["minAge"] = age
+	}
+	return fields
+}
+
+// libraryAllows tells whether content of lib without certification may be
+// shown under an age limit: the library rating applies, unrated kids
+// libraries being allowed
+func libraryAllows(lib Library, maxAge int) bool {
+	if lib.ContentRating == "" {
+		return lib.Kids
+	}
+	age, ok := CertificationAge(lib.ContentRating)
+	return ok && age <= maxAge
+}
+
+// RatingFilter matches the movies or series allowed under an age limit
+func RatingFilter(maxAge int) bson.M {
+	fallback := []string{}
+	for _, lib := range Libraries() {
+		if libraryAllows(lib, maxAge) {
+			fallback = append(fallback, lib.Key)
+		}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"minAge": bson.M{"$lte": maxAge}},
+		bson.M{"minAge": nil, "library": bson.M{"$in": fallback}},
+	}}
+}
+
+// KidsMaxCertification is the highest certification kid profiles may watch
+// (KIDS_MAX_CERTIFICATION, ex: "FR:-10"), empty for no limit
+func KidsMaxCertification() string {
+	return os.Getenv("KIDS_MAX_CERTIFICATION")
+}
@@ -2,6 +2,8 @@ package utils
 
 import "path/filepath"
 
+// Default libraries, used to seed the "libraries" collection when neither the
+// database nor LIBRARIES_FILE define any.
 var (
 	MOVIES_DIR      = filepath.Join(".", "movies")
 	SERIES_DIR      = filepath.Join(".", "series")
@@ -9,3 +11,30 @@ var (
 	SERIES_DOCU_DIR = filepath.Join(".", "series_docu")
 	SERIES_KID_DIR  = filepath.Join(".", "series_kid")
 )
+
+const (
+	LIBRARY_TYPE_MOVIE  = "movie"
+	LIBRARY_TYPE_SERIES = "series"
+)
+
+var DefaultLibraries = []Library{
+	{Key: "movies", Name: "Films", Type: LIBRARY_TYPE_MOVIE, Roots: []string{MOVIES_DIR}},
+	{Key: "movies_docu", Name: "Documentaires", Type: LIBRARY_TYPE_MOVIE, Roots: []string{MOVIES_DOCU_DIR}},
+	{Key: "series", Name: "Séries", Type: LIBRARY_TYPE_SERIES, Roots: []string{SERIES_DIR}},
+	{Key: "series_docu", Name: "Séries documentaires", Type: LIBRARY_TYPE_SERIES, Roots: []string{SERIES_DOCU_DIR}},
+	{Key: "series_kid", Name: "Séries enfants", Type: LIBRARY_TYPE_SERIES, Roots: []string{SERIES_KID_DIR}, Kids: true},
+}
+
+// Episode orderings of a series
+const (
+	SERIES_ORDERING_AIRED    = "aired"    // season then episode number
+	SERIES_ORDERING_ABSOLUTE = "absolute" // absolute number, for anime
+)
+
+// Statuses of a post-ingest pipeline step
+const (
+	STEP_RUNNING = "running"
+	STEP_DONE    = "done"
+	STEP_FAILED  = "failed"
+	STEP_SKIPPED = "skipped" // Disabled, or nothing to do
+)
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package utils
+
+import "syscall"
+
+// DiskFree returns the number of bytes available to unprivileged users on the
+// filesystem holding path
+func DiskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// SameFilesystem tells whether a and b live on the same filesystem, where a
+// rename or a hardlink takes no extra space
+func SameFilesystem(a, b string) bool {
+	var sa, sb syscall.Stat_t
+	if syscall.Stat(a, &sa) != nil || syscall.Stat(b, &sb) != nil {
+		return false
+	}
+	return sa.Dev == sb.Dev
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package utils
+
+import "errors"
+
+// DiskFree is not implemented on Windows; callers treat the error as unknown
+func DiskFree(path string) (uint64, error) {
+	return 0, errors.New("disk usage not supported on windows")
+}
+
+// SameFilesystem is not implemented on Windows; callers assume a copy
+func SameFilesystem(a, b string) bool {
+	return false
+}
@@ -0,0 +1,38 @@
+package utils
+
+import "sort"
+
+// PlaybackChain returns the episodes played one after the other with current,
+// in order. With the aired ordering, regular seasons chain into each other
+// while specials (season 0) form their own chain. With the absolute ordering,
+// episodes having an absolute number are ordered by it; the others fall back
+// to the aired ordering.
+func PlaybackChain(episodes []Episode, ordering string, current Episode) []Episode {
+	absolute := ordering == SERIES_ORDERING_ABSOLUTE && current.AbsoluteNumber > 0
+
+	chain := make([]Episode, 0, len(episodes))
+	for _, ep := range episodes {
+		switch {
+		case absolute:
+			if ep.AbsoluteNumber > 0 {
+				chain = append(chain, ep)
+			}
+		case ordering == SERIES_ORDERING_ABSOLUTE && ep.AbsoluteNumber > 0:
+			// Part of the absolute chain
+		case (ep.SeasonNumber == 0) == (current.SeasonNumber == 0):
+			chain = append(chain, ep)
+		}
+	}
+
+	sort.SliceStable(chain, func(i, j int) bool {
+		a, b := chain[i], chain[j]
+		if absolute && a.AbsoluteNumber != b.AbsoluteNumber {
+			return a.AbsoluteNumber < b.AbsoluteNumber
+		}
+		if a.SeasonNumber != b.SeasonNumber {
+			return a.SeasonNumber < b.SeasonNumber
+		}
+		return a.EpisodeNumber < b.EpisodeNumber
+	})
+	return chain
+}
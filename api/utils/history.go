@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DEFAULT_WATCHED_THRESHOLD is the share of the duration past which a movie
+// or episode counts as watched
+const DEFAULT_WATCHED_THRESHOLD = 0.9
+
+// DEFAULT_CONTINUE_WATCHING_DAYS is how long an untouched progress stays in
+// "continue watching"
+const DEFAULT_CONTINUE_WATCHING_DAYS = 90
+
+// WatchRecord is what a user watched, in the "watch_history" collection. It
+// outlives the progress records, deleted once something is finished.
+type WatchRecord struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user" bson:"user"`
+	Type        string             `json:"type" bson:"type"`                                   // "movie" | "episode"
+	MediaID     primitive.ObjectID `json:"mediaId" bson:"media"`                               // Movie or episode ID
+	SeriesID    primitive.ObjectID `json:"seriesId,omitempty" bson:"series,omitempty"`         // Episodes only
+	Watched     bool               `json:"watched" bson:"watched"`                             // False once marked unwatched
+	PlayCount   int                `json:"playCount" bson:"playCount"`                         // Times watched to the end
+	LastWatched time.Time          `json:"lastWatched,omitempty" bson:"lastWatched,omitempty"` // Last time it was finished
+	Updated     time.Time          `json:"updated" bson:"updated"`
+}
+
+// WatchedThreshold reads WATCHED_THRESHOLD (0 to 1, ex: 0.9)
+func WatchedThreshold() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("WATCHED_THRESHOLD"), 64); err == nil && v > 0 && v <= 1 {
+		return v
+	}
+	return DEFAULT_WATCHED_THRESHOLD
+}
+
+// PastThreshold tells whether a playback position finishes a media
+func PastThreshold(position, duration int) bool {
+	return duration > 0 && float64(position) >= WatchedThreshold()*float64(duration)
+}
+
+// ContinueWatchingMaxAge reads CONTINUE_WATCHING_DAYS, 0 when progress never expires
+func ContinueWatchingMaxAge() time.Duration {
+	days := DEFAULT_CONTINUE_WATCHING_DAYS
+	if v, err := strconv.Atoi(os.Getenv("CONTINUE_WATCHING_DAYS")); err == nil && v >= 0 {
+		days = v
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ProgressStale tells whether a progress last reported at updated has
+// expired. Records older than the field fall back to their ID's creation time.
+func ProgressStale(id primitive.ObjectID, updated time.Time, maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	if updated.IsZero() {
+		updated = id.Timestamp()
+	}
+	return time.Since(updated) > maxAge
+}
+
+// WatchedMedia identifies a movie or an episode (with its series) in the history
+type WatchedMedia struct {
+	Type     string
+	ID       primitive.ObjectID
+	SeriesID primitive.ObjectID
+}
+
+func historyFilter(userID primitive.ObjectID, m WatchedMedia) bson.M {
+	return bson.M{"user": userID, "type": m.Type, "media": m.ID}
+}
+
+func historyInsert(m WatchedMedia) bson.M {
+	insert := bson.M{"_id": primitive.NewObjectID()}
+	if m.SeriesID != primitive.NilObjectID {
+		insert["series"] = m.SeriesID
+	}
+	return insert
+}
+
+// RecordPlay counts a media as watched to the end once more
+func RecordPlay(ctx context.Context, userID primitive.ObjectID, m WatchedMedia) error {
+	now := time.Now()
+	_, err := GetCollection("watch_history").UpdateOne(ctx, historyFilter(userID, m), bson.M{
+		"$set":         bson.M{"watched": true, "lastWatched": now, "updated": now},
+		"$inc":         bson.M{"playCount": 1},
+		"$setOnInsert": historyInsert(m),
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// SetWatched marks media as watched (counted as played once if they never
+// were) or unwatched, keeping their play counts
+func SetWatched(ctx context.Context, userID primitive.ObjectID, media []WatchedMedia, watched bool) error {
+	return setWatchedAt(ctx, userID, media, watched, time.Now())
+}
+
+// setWatchedAt is SetWatched with the time the media were watched, for
+// records rebuilt from older data
+func setWatchedAt(ctx context.Context, userID primitive.ObjectID, media []WatchedMedia, watched bool, at time.Time) error {
+	if len(media) == 0 {
+		return nil
+	}
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(media))
+	for _, m := range media {
+		update := bson.M{
+			"$set":         bson.M{"watched": watched, "updated": now},
+			"$setOnInsert": historyInsert(m),
+		}
+		if watched {
+			update["$set"].(bson.M)["lastWatched"] = at
+			update["$max"] = bson.M{"playCount": 1}
+		} else {
+			update["$setOnInsert"].(bson.M)["playCount"] = 0
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(historyFilter(userID, m)).SetUpdate(update).SetUpsert(true))
+	}
+	_, err := GetCollection("watch_history").BulkWrite(ctx, models)
+	return err
+}
+
+// WatchedEpisodes returns the watched episodes of a series for a user
+func WatchedEpisodes(ctx context.Context, userID, seriesID primitive.ObjectID) (map[primitive.ObjectID]WatchRecord, error) {
+	cursor, err := GetCollection("watch_history").Find(ctx, bson.M{"user": userID, "type": "episode", "series": seriesID, "watched": true})
+	if err != nil {
+		return nil, err
+	}
+	var records []WatchRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	byEpisode := make(map[primitive.ObjectID]WatchRecord, len(records))
+	for _, r := range records {
+		byEpisode[r.MediaID] = r
+	}
+	return byEpisode, nil
+}
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	JOB_QUEUED   = "queued"
+	JOB_RUNNING  = "running"
+	JOB_DONE     = "done"
+	JOB_FAILED   = "failed"
+	JOB_CANCELED = "canceled"
+)
+
+// Finished jobs are kept this long for clients to read their outcome
+const jobRetention = 24 * time.Hour
+
+// JobEvent is one entry of a job log, sent as a server-sent event. IDs
+// increase by one per job so clients resume with Last-Event-ID.
+type JobEvent struct {
+	ID       int       `json:"id"`
+	Type     string    `json:"type"`               // "status", "step", "progress", "log"
+	Step     string    `json:"step,omitempty"`     // ex: "copy", "probe", "artwork", "hls"
+	Progress float64   `json:"progress,omitempty"` // 0..1 within the step
+	Message  string    `json:"message,omitempty"`
+	Data     any       `json:"data,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// JobInfo holds the serializable state of a job
+type JobInfo struct {
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"` // ex: "movie", "series"
+	Title   string    `json:"title"`
+	Status  string    `json:"status"`
+	Step    string    `json:"step,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Result  any       `json:"result,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Job is a background task (ingest, import...) with its event log. Jobs live
+// in memory: they are progress reports, the library itself is in MongoDB.
+type Job struct {
+	JobInfo
+
+	mu      sync.Mutex
+	events  []JobEvent
+	waiters []chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+var (
+	jobsMu sync.RWMutex
+	jobs   = map[string]*Job{}
+)
+
+// NewJob registers a queued job
+func NewJob(kind, title string) *Job {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		JobInfo: JobInfo{
+			ID:      hex.EncodeToString(b),
+			Kind:    kind,
+			Title:   title,
+			Status:  JOB_QUEUED,
+			Created: now,
+			Updated: now,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	jobsMu.Lock()
+	for id, j := range jobs {
+		if j.finished() && now.Sub(j.snapshot().Updated) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+	jobs[job.ID] = job
+	jobsMu.Unlock()
+
+	job.emit(JobEvent{Type: "status", Message: JOB_QUEUED})
+	return job
+}
+
+// GetJob returns a job by ID
+func GetJob(id string) (*Job, bool) {
+	jobsMu.RLock()
+	defer jobsMu.RUnlock()
+	job, ok := jobs[id]
+	return job, ok
+}
+
+// ListJobs returns a snapshot of every job, newest first
+func ListJobs() []JobInfo {
+	jobsMu.RLock()
+	list := make([]JobInfo, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, j.snapshot())
+	}
+	jobsMu.RUnlock()
+	sort.Slice(list, func(i, k int) bool { return list[i].Created.After(list[k].Created) })
+	return list
+}
+
+// Context is canceled when the job is canceled
+func (j *Job) Context() context.Context { return j.ctx }
+
+// Snapshot returns a copy of the job fields, safe to serialize
+func (j *Job) Snapshot() JobInfo { return j.snapshot() }
+
+func (j *Job) snapshot() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.JobInfo
+}
+
+func (j *Job) finished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Status == JOB_DONE || j.Status == JOB_FAILED || j.Status == JOB_CANCELED
+}
+
+// emit appends an event and wakes up the stream readers
+func (j *Job) emit(ev JobEvent) {
+	j.mu.Lock()
+	ev.ID = len(j.events) + 1
+	ev.Time = time.Now()
+	j.events = append(j.events, ev)
+	j.Updated = ev.Time
+	waiters := j.waiters
+	j.waiters = nil
+	j.mu.Unlock()
+	for _, w := range waiters {
+		close(w)
+	}
+}
+
+// Start marks the job running
+func (j *Job) Start() {
+	j.mu.Lock()
+	j.Status = JOB_RUNNING
+	j.mu.Unlock()
+	j.emit(JobEvent{Type: "status", Message: JOB_RUNNING})
+}
+
+// StartStep reports the beginning of a step
+func (j *Job) StartStep(step, message string) {
+	j.mu.Lock()
+	j.Step = step
+	j.mu.Unlock()
+	j.emit(JobEvent{Type: "step", Step: step, Message: message})
+}
+
+// Progress reports the advancement of the current step, between 0 and 1
+func (j *Job) Progress(progress float64, data any) {
+	j.mu.Lock()
+	step := j.Step
+	j.mu.Unlock()
+	j.emit(JobEvent{Type: "progress", Step: step, Progress: progress, Data: data})
+}
+
+// Log adds an informative message, ex: a non-fatal step failure
+func (j *Job) Log(message string) {
+	j.mu.Lock()
+	step := j.Step
+	j.mu.Unlock()
+	j.emit(JobEvent{Type: "log", Step: step, Message: message})
+}
+
+// Finish marks the job done with its result
+func (j *Job) Finish(result any) {
+	j.mu.Lock()
+	j.Status = JOB_DONE
+	j.Result = result
+	j.mu.Unlock()
+	j.emit(JobEvent{Type: "status", Message: JOB_DONE, Data: result})
+	j.cancel()
+}
+
+// Fail marks the job failed, or canceled when its context was canceled
+func (j *Job) Fail(err error) {
+	status := JOB_FAILED
+	if j.ctx.Err() != nil {
+		status = JOB_CANCELED
+	}
+	j.mu.Lock()
+	j.Status = status
+	j.Error = err.Error()
+	j.mu.Unlock()
+	j.emit(JobEvent{Type: "status", Message: status, Data: map[string]string{"error": err.Error()}})
+	j.cancel()
+}
+
+// Cancel asks a running job to stop; it reports false for a finished job
+func (j *Job) Cancel() bool {
+	if j.finished() {
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+// EventsSince returns the events after lastID, whether the job is finished,
+// and a channel closed when new events arrive
+func (j *Job) EventsSince(lastID int) ([]JobEvent, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if lastID < 0 {
+		lastID = 0
+	}
+	var events []JobEvent
+	if lastID < len(j.events) {
+		events = append(events, j.events[lastID:]...)
+	}
+	done := j.Status == JOB_DONE || j.Status == JOB_FAILED || j.Status == JOB_CANCELED
+	wait := make(chan struct{})
+	if done {
+		close(wait)
+	} else {
+		j.waiters = append(j.waiters, wait)
+	}
+	return events, done, wait
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Default path templates, relative to a library root. Placeholders:
+//
+//	{title}         movie or episode title
+//	{series}        series folder (custom title)
+//	{seasonFolder}  "Saison 2", "Season 2" or the specials folder
+//	{season}        season number
+//	{episodes}      episode number, "01-02" for a multi-episode file
+//	{absolute}      absolute episode number
+//	{tmdbID}        TMDB ID of the movie or series
+//	{ext}           file extension, with its dot
+//
+// Numbers accept a zero-padding width: {season:02}.
+const (
+	DEFAULT_MOVIE_PATH_TEMPLATE   = "{title}{ext}"
+	DEFAULT_EPISODE_PATH_TEMPLATE = "{series}/{seasonFolder}/{season:02}{episodes:02} - {title}{ext}"
+)
+
+var layoutToken = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+// Layout decides where media files live inside a library root
+type Layout struct {
+	MovieTemplate   string
+	EpisodeTemplate string
+	SeasonWord      string // "Saison" or "Season"
+	SpecialsFolder  string
+}
+
+// episodeRange is rendered by the {episodes} placeholder
+type episodeRange struct{ start, end int }
+
+// CurrentLayout reads the layout from MOVIE_PATH_TEMPLATE, EPISODE_PATH_TEMPLATE,
+// LAYOUT_LANG ("fr" by default, or "en") and SPECIALS_FOLDER
+func CurrentLayout() Layout {
+	l := Layout{
+		MovieTemplate:   os.Getenv("MOVIE_PATH_TEMPLATE"),
+		EpisodeTemplate: os.Getenv("EPISODE_PATH_TEMPLATE"),
+		SeasonWord:      "Saison",
+		SpecialsFolder:  os.Getenv("SPECIALS_FOLDER"),
+	}
+	if l.MovieTemplate == "" {
+		l.MovieTemplate = DEFAULT_MOVIE_PATH_TEMPLATE
+	}
+	if l.EpisodeTemplate == "" {
+		l.EpisodeTemplate = DEFAULT_EPISODE_PATH_TEMPLATE
+	}
+	if strings.HasPrefix(strings.ToLower(os.Getenv("LAYOUT_LANG")), "en") {
+		l.SeasonWord = "Season"
+	}
+	if l.SpecialsFolder == "" {
+		l.SpecialsFolder = "Specials"
+	}
+	return l
+}
+
+// ValidateLayout checks the configured templates and upload limits once at startup
+func ValidateLayout() error {
+	for _, name := range []string{"UPLOAD_MAX_SIZE", "UPLOAD_MIN_FREE_SPACE"} {
+		if v := os.Getenv(name); v != "" {
+			if _, err := ParseSize(v); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	l := CurrentLayout()
+	if _, err := l.MoviePath("Title", 1, ".mkv"); err != nil {
+		return fmt.Errorf("MOVIE_PATH_TEMPLATE: %w", err)
+	}
+	if _, err := l.EpisodePath(Series{CustomTitle: "Series", TmdbID: 1}, Episode{SeasonNumber: 1, EpisodeNumber: 1, Title: "Title"}, ".mkv"); err != nil {
+		return fmt.Errorf("EPISODE_PATH_TEMPLATE: %w", err)
+	}
+	return nil
+}
+
+// SeasonFolder is the folder name of a season, season 0 holding specials
+func (l Layout) SeasonFolder(season int) string {
+	if season == 0 {
+		return l.SpecialsFolder
+	}
+	return fmt.Sprintf("%s %d", l.SeasonWord, season)
+}
+
+// MoviePath returns the path of a movie file relative to its library root
+func (l Layout) MoviePath(title string, tmdbID int, ext string) (string, error) {
+	return expandLayout(l.MovieTemplate, map[string]any{
+		"title":  title,
+		"tmdbID": tmdbID,
+		"ext":    ext,
+	})
+}
+
+// EpisodePath returns the path of an episode file relative to its library root
+func (l Layout) EpisodePath(series Series, ep Episode, ext string) (string, error) {
+	return expandLayout(l.EpisodeTemplate, map[string]any{
+		"title":        ep.Title,
+		"series":       series.CustomTitle,
+		"seasonFolder": l.SeasonFolder(ep.SeasonNumber),
+		"season":       ep.SeasonNumber,
+		"episodes":     episodeRange{ep.EpisodeNumber, ep.LastEpisodeNumber()},
+		"absolute":     ep.AbsoluteNumber,
+		"tmdbID":       series.TmdbID,
+		"ext":          ext,
+	})
+}
+
+// expandLayout fills a template. Values are sanitized one by one so they can
+// never add a folder level; only the template's own "/" separate folders.
+func expandLayout(tpl string, values map[string]any) (string, error) {
+	var expandErr error
+	out := layoutToken.ReplaceAllStringFunc(tpl, func(token string) string {
+		m := layoutToken.FindStringSubmatch(token)
+		width := 0
+		fmt.Sscanf(m[2], "%d", &width)
+		switch v := values[m[1]].(type) {
+		case string:
+			if m[1] == "ext" {
+				return v
+			}
+			return SanitizeName(v)
+		case int:
+			return fmt.Sprintf("%0*d", width, v)
+		case episodeRange:
+			if v.end > v.start {
+				return fmt.Sprintf("%0*d-%0*d", width, v.start, width, v.end)
+			}
+			return fmt.Sprintf("%0*d", width, v.start)
+		}
+		expandErr = fmt.Errorf("unknown placeholder %s", token)
+		return ""
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	segments := strings.Split(filepath.ToSlash(out), "/")
+	for i, s := range segments {
+		if strings.TrimSpace(s) == "" || s == "." || s == ".." {
+			return "", fmt.Errorf("template %q produces an invalid path %q", tpl, out)
+		}
+		segments[i] = TruncateName(s, MaxNameBytes)
+	}
+	return filepath.Join(segments...), nil
+}
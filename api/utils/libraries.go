@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	librariesMu sync.RWMutex
+	libraries   []Library
+
+	ErrLibraryNotFound = errors.New("library not found")
+)
+
+// LoadLibraries reads the libraries from the DB into memory. When the
+// collection is empty it is seeded from LIBRARIES_FILE (JSON array), or from
+// DefaultLibraries if that file does not exist.
+func LoadLibraries() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := GetCollection("libraries")
+	count, err := coll.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		seed, err := readLibrariesFile()
+		if err != nil {
+			return err
+		}
+		for _, lib := range seed {
+			if err := ValidateLibrary(lib); err != nil {
+				return fmt.Errorf("library %q: %w", lib.Key, err)
+			}
+			if _, err := coll.InsertOne(ctx, lib); err != nil {
+				return err
+			}
+		}
+		log.Printf("Seeded %d libraries", len(seed))
+	}
+
+	return ReloadLibraries(ctx)
+}
+
+// ReloadLibraries refreshes the in-memory copy after an admin change
+func ReloadLibraries(ctx context.Context) error {
+	cursor, err := GetCollection("libraries").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "key", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	var libs []Library
+	if err := cursor.All(ctx, &libs); err != nil {
+		return err
+	}
+
+	librariesMu.Lock()
+	libraries = libs
+	librariesMu.Unlock()
+	return nil
+}
+
+func readLibrariesFile() ([]Library, error) {
+	path := os.Getenv("LIBRARIES_FILE")
+	if path == "" {
+		path = "libraries.json"
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DefaultLibraries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var libs []Library
+	if err := json.Unmarshal(data, &libs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return libs, nil
+}
+
+// ValidateLibrary checks the fields required to store files in a library
+func ValidateLibrary(lib Library) error {
+	if strings.TrimSpace(lib.Key) == "" {
+		return errors.New("key is required")
+	}
+	if lib.Type != LIBRARY_TYPE_MOVIE && lib.Type != LIBRARY_TYPE_SERIES {
+		return fmt.Errorf("invalid type %q (expected %q or %q)", lib.Type, LIBRARY_TYPE_MOVIE, LIBRARY_TYPE_SERIES)
+	}
+	if len(lib.Roots) == 0 {
+		return errors.New("at least one root path is required")
+	}
+	for _, root := range lib.Roots {
+		if strings.TrimSpace(root) == "" {
+			return errors.New("empty root path")
+		}
+	}
+	if lib.MaxFileSize < 0 || lib.MinFreeSpace < 0 {
+		return errors.New("size limits can't be negative")
+	}
+	return nil
+}
+
+// Libraries returns a copy of all configured libraries
+func Libraries() []Library {
+	librariesMu.RLock()
+	defer librariesMu.RUnlock()
+	return append([]Library(nil), libraries...)
+}
+
+// GetLibrary returns the library with the given key
+func GetLibrary(key string) (Library, error) {
+	librariesMu.RLock()
+	defer librariesMu.RUnlock()
+	for _, lib := range libraries {
+		if lib.Key == key {
+			return lib, nil
+		}
+	}
+	return Library{}, ErrLibraryNotFound
+}
+
+// ResolveLibrary returns the library identified by key, checking that it holds
+// the expected type. An empty key falls back to the first library of that type.
+func ResolveLibrary(key, typ string) (Library, error) {
+	if key != "" {
+		lib, err := GetLibrary(key)
+		if err != nil {
+			return Library{}, err
+		}
+		if lib.Type != typ {
+			return Library{}, fmt.Errorf("library %q does not hold %s content", key, typ)
+		}
+		return lib, nil
+	}
+
+	librariesMu.RLock()
+	defer librariesMu.RUnlock()
+	for _, lib := range libraries {
+		if lib.Type == typ {
+			return lib, nil
+		}
+	}
+	return Library{}, ErrLibraryNotFound
+}
+
+// PickRoot chooses where to store name (a file or a folder) in the library.
+// A root already containing name wins, so a series stays on a single disk;
+// otherwise the root with the most free space is used.
+func (lib Library) PickRoot(name string) (string, error) {
+	if name != "" {
+		for _, root := range lib.Roots {
+			if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+				return root, nil
+			}
+		}
+	}
+
+	best := ""
+	var bestFree uint64
+	for _, root := range lib.Roots {
+		if err := os.MkdirAll(root, 0755); err != nil {
+			log.Printf("library %s: root %s unavailable: %v", lib.Key, root, err)
+			continue
+		}
+		free, err := DiskFree(root)
+		if err != nil {
+			// Unknown free space: usable, but any measured root is preferred
+			if best == "" {
+				best = root
+			}
+			continue
+		}
+		if best == "" || free > bestFree {
+			best, bestFree = root, free
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("library %q has no available root", lib.Key)
+	}
+	return best, nil
+}
+
+// ParseSize reads a byte count with an optional K, M, G or T suffix (powers of 1024)
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = strings.TrimSpace(s[:len(s)-1])
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// UploadLimits returns the max file size (0 for unlimited) and the free space
+// to keep on a root of the library, falling back to UPLOAD_MAX_SIZE and
+// UPLOAD_MIN_FREE_SPACE (default 1G)
+func (lib Library) UploadLimits() (maxSize, minFree int64) {
+	maxSize, minFree = lib.MaxFileSize, lib.MinFreeSpace
+	// Values were checked by ValidateLayout at startup
+	if maxSize == 0 {
+		if v := os.Getenv("UPLOAD_MAX_SIZE"); v != "" {
+			maxSize, _ = ParseSize(v)
+		}
+	}
+	if minFree == 0 {
+		minFree = 1 << 30
+		if v := os.Getenv("UPLOAD_MIN_FREE_SPACE"); v != "" {
+			minFree, _ = ParseSize(v)
+		}
+	}
+	return maxSize, minFree
+}
+
+// LibraryOf returns the library having a root containing path
+func LibraryOf(path string) (Library, bool) {
+	root := LibraryRootOf(path)
+	librariesMu.RLock()
+	defer librariesMu.RUnlock()
+	for _, lib := range libraries {
+		for _, r := range lib.Roots {
+			if root != "" && filepath.Clean(r) == root {
+				return lib, true
+			}
+		}
+	}
+	return Library{}, false
+}
+
+// LibraryRootOf returns the configured root containing path, or "" if the
+// file lives outside every library
+func LibraryRootOf(path string) string {
+	clean := filepath.Clean(path)
+	librariesMu.RLock()
+	defer librariesMu.RUnlock()
+	for _, lib := range libraries {
+		for _, root := range lib.Roots {
+			r := filepath.Clean(root)
+			if strings.HasPrefix(clean, r+string(filepath.Separator)) {
+				return r
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,601 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a versioned change of the DB schema or data. Applied versions
+// are recorded in the "schema_migrations" collection; never renumber or edit
+// a released migration, add a new one instead.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+type appliedMigration struct {
+	Version   int                `bson:"_id"`
+	Name      string             `bson:"name"`
+	AppliedAt primitive.DateTime `bson:"appliedAt"`
+}
+
+// Versions are never reused: 2 was dropped before being released
+var migrations = []Migration{
+	{1, "backfill library keys", migrateBackfillLibraries},
+	{3, "merge duplicate movies", migrateMergeDuplicateMovies},
+	{4, "merge duplicate episodes", migrateMergeDuplicateEpisodes},
+	{5, "create indexes", migrateCreateIndexes},
+	{6, "season records", migrateSeasonRecords},
+	{7, "session indexes", migrateSessionIndexes},
+	{8, "user roles", migrateUserRoles},
+	{9, "user preferences", migrateUserPreferences},
+	{10, "watch history", migrateWatchHistory},
+	{11, "watchlist indexes", migrateWatchlistIndexes},
+	{12, "rating indexes", migrateRatingIndexes},
+}
+
+// RunMigrations applies the pending migrations in order. It stops at the first
+// failure so later migrations never run on a half-migrated DB.
+func RunMigrations() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	db := GetDatabase()
+	coll := db.Collection("schema_migrations")
+
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var applied []appliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return err
+	}
+	done := map[int]bool{}
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+
+	pending := append([]Migration(nil), migrations...)
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	for _, m := range pending {
+		if done[m.Version] {
+			continue
+		}
+		log.Printf("Applying migration %d: %s", m.Version, m.Name)
+		if err := m.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := coll.InsertOne(ctx, appliedMigration{
+			Version:   m.Version,
+			Name:      m.Name,
+			AppliedAt: primitive.NewDateTimeFromTime(time.Now()),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Media created before libraries existed are assigned to the library whose
+// roots contain their files, or to the first library of their type
+func migrateBackfillLibraries(ctx context.Context, db *mongo.Database) error {
+	libraryOf := func(path, typ string) string {
+		root := LibraryRootOf(path)
+		for _, lib := range Libraries() {
+			for _, r := range lib.Roots {
+				if root != "" && filepath.Clean(r) == root {
+					return lib.Key
+				}
+			}
+		}
+		if lib, err := ResolveLibrary("", typ); err == nil {
+			return lib.Key
+		}
+		return ""
+	}
+	missing := bson.M{"$or": bson.A{bson.M{"library": bson.M{"$exists": false}}, bson.M{"library": ""}}}
+
+	var movies []Movie
+	cursor, err := db.Collection("movies").Find(ctx, missing)
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &movies); err != nil {
+		return err
+	}
+	for _, m := range movies {
+		if _, err := db.Collection("movies").UpdateOne(ctx, bson.M{"_id": m.ID},
+			bson.M{"$set": bson.M{"library": libraryOf(m.FilePath, LIBRARY_TYPE_MOVIE)}}); err != nil {
+			return err
+		}
+	}
+
+	var seriesList []Series
+	cursor, err = db.Collection("series").Find(ctx, missing)
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &seriesList); err != nil {
+		return err
+	}
+	for _, s := range seriesList {
+		var ep Episode
+		path := ""
+		if err := db.Collection("episodes").FindOne(ctx, bson.M{"seriesID": s.ID}).Decode(&ep); err == nil {
+			path = ep.FilePath
+		}
+		if _, err := db.Collection("series").UpdateOne(ctx, bson.M{"_id": s.ID},
+			bson.M{"$set": bson.M{"library": libraryOf(path, LIBRARY_TYPE_SERIES)}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Duplicate uploads created several movies with the same tmdbID in a library:
+// the most recent one is kept and the others become versions of it, their
+// progress records being moved onto it
+func migrateMergeDuplicateMovies(ctx context.Context, db *mongo.Database) error {
+	coll := db.Collection("movies")
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "date", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"library": "$library", "tmdbID": "$tmdbID"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		var keep Movie
+		if err := coll.FindOne(ctx, bson.M{"_id": g.IDs[0]}).Decode(&keep); err != nil {
+			return err
+		}
+		versions := MaterializeVersions(keep.FilePath, keep.Versions)
+		for _, dupID := range g.IDs[1:] {
+			var dup Movie
+			if err := coll.FindOne(ctx, bson.M{"_id": dupID}).Decode(&dup); err != nil {
+				return err
+			}
+			versions = append(versions, mergedVersions(versions, dup.FilePath, dup.Versions)...)
+			if err := moveProgress(ctx, db, "ongoing_movies", "movie", dupID, keep.ID); err != nil {
+				return err
+			}
+			if _, err := coll.DeleteOne(ctx, bson.M{"_id": dupID}); err != nil {
+				return err
+			}
+		}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": keep.ID}, bson.M{"$set": bson.M{"versions": versions}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Same as migrateMergeDuplicateMovies for episodes sharing (series, season, episode)
+func migrateMergeDuplicateEpisodes(ctx context.Context, db *mongo.Database) error {
+	coll := db.Collection("episodes")
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "date", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"seriesID": "$seriesID", "seasonNumber": "$seasonNumber", "episodeNumber": "$episodeNumber"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		var keep Episode
+		if err := coll.FindOne(ctx, bson.M{"_id": g.IDs[0]}).Decode(&keep); err != nil {
+			return err
+		}
+		versions := MaterializeVersions(keep.FilePath, keep.Versions)
+		for _, dupID := range g.IDs[1:] {
+			var dup Episode
+			if err := coll.FindOne(ctx, bson.M{"_id": dupID}).Decode(&dup); err != nil {
+				return err
+			}
+			versions = append(versions, mergedVersions(versions, dup.FilePath, dup.Versions)...)
+			if err := moveProgress(ctx, db, "ongoing_episodes", "episode", dupID, keep.ID); err != nil {
+				return err
+			}
+			if _, err := coll.DeleteOne(ctx, bson.M{"_id": dupID}); err != nil {
+				return err
+			}
+		}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": keep.ID}, bson.M{"$set": bson.M{"versions": versions}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergedVersions returns the versions of a duplicate not already known by path
+func mergedVersions(known []MediaVersion, filePath string, versions []MediaVersion) []MediaVersion {
+	seen := map[string]bool{}
+	for _, v := range known {
+		seen[v.FilePath] = true
+	}
+	var out []MediaVersion
+	for _, v := range MaterializeVersions(filePath, versions) {
+		if !seen[v.FilePath] {
+			seen[v.FilePath] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// moveProgress points the progress records of a duplicate to the kept media.
+// A user having progress on both keeps the one on the kept media.
+func moveProgress(ctx context.Context, db *mongo.Database, collName, field string, from, to primitive.ObjectID) error {
+	coll := db.Collection(collName)
+	cursor, err := coll.Find(ctx, bson.M{field: from})
+	if err != nil {
+		return err
+	}
+	var records []bson.M
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		count, err := coll.CountDocuments(ctx, bson.M{field: to, "user": r["user"]})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			if err := dropProgress(ctx, db, field, []primitive.ObjectID{r["_id"].(primitive.ObjectID)}); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": r["_id"]}, bson.M{"$set": bson.M{field: to}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateCreateIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		"libraries": {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"movies": {
+			{Keys: bson.D{{Key: "library", Value: 1}, {Key: "tmdbID", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "tmdbID", Value: 1}}},
+			{Keys: bson.D{{Key: "date", Value: -1}}},
+		},
+		"series": {
+			{Keys: bson.D{{Key: "library", Value: 1}, {Key: "tmdbID", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "tmdbID", Value: 1}}},
+			{Keys: bson.D{{Key: "date", Value: -1}}},
+		},
+		"episodes": {
+			{Keys: bson.D{{Key: "seriesID", Value: 1}, {Key: "seasonNumber", Value: 1}, {Key: "episodeNumber", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"ongoing_movies": {
+			{Keys: bson.D{{Key: "user", Value: 1}, {Key: "movie", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "movie", Value: 1}}},
+		},
+		"ongoing_episodes": {
+			{Keys: bson.D{{Key: "user", Value: 1}, {Key: "episode", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "episode", Value: 1}}},
+			{Keys: bson.D{{Key: "series", Value: 1}}},
+		},
+		"ongoing_medias": {
+			{Keys: bson.D{{Key: "type", Value: 1}, {Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	}
+
+	// Concurrent upserts may have duplicated progress records; keep the first
+	// one, the wrappers and user references of the others going with them
+	for _, d := range []struct {
+		name string
+		keys []string
+		drop func(keep primitive.ObjectID, dups []primitive.ObjectID) error
+	}{
+		{"ongoing_movies", []string{"user", "movie"}, func(_ primitive.ObjectID, dups []primitive.ObjectID) error {
+			return dropProgress(ctx, db, "movie", dups)
+		}},
+		{"ongoing_episodes", []string{"user", "episode"}, func(_ primitive.ObjectID, dups []primitive.ObjectID) error {
+			return dropProgress(ctx, db, "episode", dups)
+		}},
+		{"ongoing_medias", []string{"type", "id"}, func(keep primitive.ObjectID, dups []primitive.ObjectID) error {
+			return mergeWrappers(ctx, db, keep, dups)
+		}},
+	} {
+		if err := dropDuplicates(ctx, db.Collection(d.name), d.keys, d.drop); err != nil {
+			return fmt.Errorf("%s: %w", d.name, err)
+		}
+	}
+
+	for name, models := range indexes {
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// dropDuplicates hands drop all but one document per combination of keys
+func dropDuplicates(ctx context.Context, coll *mongo.Collection, keys []string, drop func(keep primitive.ObjectID, dups []primitive.ObjectID) error) error {
+	group := bson.M{}
+	for _, k := range keys {
+		group[k] = "$" + k
+	}
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   group,
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if err := drop(g.IDs[0], g.IDs[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropProgress deletes progress records (typ "movie" | "episode") with their
+// ongoing_medias wrappers and the references users keep to them
+func dropProgress(ctx context.Context, db *mongo.Database, typ string, ids []primitive.ObjectID) error {
+	progressColl := "ongoing_movies"
+	if typ == "episode" {
+		progressColl = "ongoing_episodes"
+	}
+	cursor, err := db.Collection("ongoing_medias").Find(ctx, bson.M{"type": typ, "id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	var wrappers []bson.M
+	if err := cursor.All(ctx, &wrappers); err != nil {
+		return err
+	}
+	wrapperIDs := make([]primitive.ObjectID, 0, len(wrappers))
+	for _, w := range wrappers {
+		if oid, ok := w["_id"].(primitive.ObjectID); ok {
+			wrapperIDs = append(wrapperIDs, oid)
+		}
+	}
+	if len(wrapperIDs) > 0 {
+		if _, err := db.Collection("users").UpdateMany(ctx,
+			bson.M{"onGoingMedias": bson.M{"$in": wrapperIDs}},
+			bson.M{"$pull": bson.M{"onGoingMedias": bson.M{"$in": wrapperIDs}}},
+		); err != nil {
+			return err
+		}
+		if _, err := db.Collection("ongoing_medias").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": wrapperIDs}}); err != nil {
+			return err
+		}
+	}
+	_, err = db.Collection(progressColl).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+// mergeWrappers deletes duplicated ongoing_medias wrappers, users referencing
+// one of them being pointed to the kept one
+func mergeWrappers(ctx context.Context, db *mongo.Database, keep primitive.ObjectID, dups []primitive.ObjectID) error {
+	users := db.Collection("users")
+	if _, err := users.UpdateMany(ctx,
+		bson.M{"onGoingMedias": bson.M{"$in": dups}},
+		bson.M{"$addToSet": bson.M{"onGoingMedias": keep}},
+	); err != nil {
+		return err
+	}
+	if _, err := users.UpdateMany(ctx,
+		bson.M{"onGoingMedias": bson.M{"$in": dups}},
+		bson.M{"$pull": bson.M{"onGoingMedias": bson.M{"$in": dups}}},
+	); err != nil {
+		return err
+	}
+	_, err := db.Collection("ongoing_medias").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": dups}})
+	return err
+}
+
+// Seasons used to be an always-empty array on series documents
+func migrateSeasonRecords(ctx context.Context, db *mongo.Database) error {
+	if _, err := db.Collection("seasons").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "seriesID", Value: 1}, {Key: "seasonNumber", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	if _, err := db.Collection("series").UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"seasons": ""}}); err != nil {
+		return err
+	}
+
+	cursor, err := db.Collection("series").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var seriesList []Series
+	if err := cursor.All(ctx, &seriesList); err != nil {
+		return err
+	}
+	// TMDB metadata is fetched in the background by FillSeasonNames
+	for _, s := range seriesList {
+		if err := SeedSeriesSeasons(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSessionIndexes indexes the token hashes of the sessions, expired
+// sessions being purged by MongoDB
+func migrateSessionIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("sessions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "accessHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "refreshHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userID", Value: 1}}},
+		{Keys: bson.D{{Key: "refreshExpires", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// Profiles created before roles become standard ones, the oldest protected
+// one being made admin so someone can still manage the server
+func migrateUserRoles(ctx context.Context, db *mongo.Database) error {
+	coll := db.Collection("users")
+	if _, err := coll.UpdateMany(ctx, bson.M{"role": bson.M{"$in": bson.A{nil, ""}}}, bson.M{"$set": bson.M{"role": ROLE_STANDARD}}); err != nil {
+		return err
+	}
+	admins, err := coll.CountDocuments(ctx, bson.M{"role": ROLE_ADMIN})
+	if err != nil || admins > 0 {
+		return err
+	}
+	// Only a profile with a password or PIN can be trusted with the role;
+	// without one the server stays in setup until an admin is created
+	var oldest User
+	err = coll.FindOne(ctx, bson.M{"auth": bson.M{"$in": bson.A{AUTH_PASSWORD, AUTH_PIN}}}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}})).Decode(&oldest)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = coll.UpdateOne(ctx, bson.M{"_id": oldest.ID}, bson.M{"$set": bson.M{"role": ROLE_ADMIN}})
+	return err
+}
+
+// Profiles created before preferences get the defaults
+func migrateUserPreferences(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"preferences": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"preferences": DefaultPreferences()}})
+	return err
+}
+
+// The watch history starts with the progress records already past the
+// watched threshold
+func migrateWatchHistory(ctx context.Context, db *mongo.Database) error {
+	if _, err := db.Collection("watch_history").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "type", Value: 1}, {Key: "media", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "lastWatched", Value: -1}}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "series", Value: 1}}},
+		{Keys: bson.D{{Key: "media", Value: 1}}},
+	}); err != nil {
+		return err
+	}
+
+	var movies []OnGoingMovie
+	cursor, err := db.Collection("ongoing_movies").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &movies); err != nil {
+		return err
+	}
+	var complete []primitive.ObjectID
+	for _, p := range movies {
+		if PastThreshold(p.Position, p.Duration) {
+			if err := setWatchedAt(ctx, p.UserID, []WatchedMedia{{Type: "movie", ID: p.MovieID}}, true, progressTime(p.ID, p.Updated)); err != nil {
+				return err
+			}
+			complete = append(complete, p.ID)
+		}
+	}
+	if err := markComplete(ctx, db.Collection("ongoing_movies"), complete); err != nil {
+		return err
+	}
+
+	var episodes []OnGoingEpisode
+	cursor, err = db.Collection("ongoing_episodes").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &episodes); err != nil {
+		return err
+	}
+	complete = nil
+	for _, p := range episodes {
+		if PastThreshold(p.Position, p.Duration) {
+			if err := setWatchedAt(ctx, p.UserID, []WatchedMedia{{Type: "episode", ID: p.EpisodeID, SeriesID: p.SeriesID}}, true, progressTime(p.ID, p.Updated)); err != nil {
+				return err
+			}
+			complete = append(complete, p.ID)
+		}
+	}
+	return markComplete(ctx, db.Collection("ongoing_episodes"), complete)
+}
+
+// progressTime is when a progress record was last reported: its update time,
+// or its creation time (from the ObjectID) for records older than that field
+func progressTime(id primitive.ObjectID, updated time.Time) time.Time {
+	if !updated.IsZero() {
+		return updated
+	}
+	return id.Timestamp()
+}
+
+// markComplete flags progress records already counted in the history, so
+// the next progress report doesn't count them again
+func markComplete(ctx context.Context, coll *mongo.Collection, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := coll.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"complete": true}})
+	return err
+}
+
+func migrateWatchlistIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("watchlist").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "type", Value: 1}, {Key: "tmdbID", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "position", Value: 1}}},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "tmdbID", Value: 1}}},
+	})
+	return err
+}
+
+func migrateRatingIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("ratings").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "type", Value: 1}, {Key: "media", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "media", Value: 1}}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "updated", Value: -1}}},
+	})
+	return err
+}
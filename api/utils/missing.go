@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MissingEpisode is an episode listed by TMDB but absent from the library
+type MissingEpisode struct {
+	SeasonNumber  int    `json:"seasonNumber"`
+	EpisodeNumber int    `json:"episodeNumber"`
+	Title         string `json:"title"`
+	AirDate       string `json:"airDate,omitempty"`
+}
+
+// MissingReport compares a series with its TMDB listing. Missing episodes
+// have already aired, upcoming ones have a future or unknown air date.
+type MissingReport struct {
+	SeriesID primitive.ObjectID `json:"seriesID"`
+	Title    string             `json:"title"`
+	TmdbID   int                `json:"tmdbID"`
+	Status   string             `json:"status,omitempty"`
+	Missing  []MissingEpisode   `json:"missing"`
+	Upcoming []MissingEpisode   `json:"upcoming"`
+	Error    string             `json:"error,omitempty"` // TMDB failure, library-wide reports only
+}
+
+// FindMissingEpisodes builds the report of a series. Specials (season 0) are
+// only considered when includeSpecials is set, they are rarely all wanted.
+func FindMissingEpisodes(ctx context.Context, series Series, includeSpecials bool) (MissingReport, error) {
+	report := MissingReport{
+		SeriesID: series.ID,
+		Title:    series.Title,
+		TmdbID:   series.TmdbID,
+		Missing:  []MissingEpisode{},
+		Upcoming: []MissingEpisode{},
+	}
+
+	show, err := GetTmdbShow(ctx, series.TmdbID)
+	if err != nil {
+		return report, err
+	}
+	report.Status = show.Status
+
+	cursor, err := GetCollection("episodes").Find(ctx, bson.M{"seriesID": series.ID})
+	if err != nil {
+		return report, err
+	}
+	var episodes []Episode
+	if err := cursor.All(ctx, &episodes); err != nil {
+		return report, err
+	}
+	owned := map[[2]int]bool{}
+	for _, ep := range episodes {
+		for n := ep.EpisodeNumber; n <= ep.LastEpisodeNumber(); n++ {
+			owned[[2]int{ep.SeasonNumber, n}] = true
+		}
+	}
+
+	today := time.Now().Format("2006-01-02")
+	for _, s := range show.Seasons {
+		if s.SeasonNumber == 0 && !includeSpecials {
+			continue
+		}
+		season, err := GetTmdbSeason(ctx, series.TmdbID, s.SeasonNumber)
+		if err != nil {
+			return report, err
+		}
+		for _, ep := range season.Episodes {
+			if owned[[2]int{s.SeasonNumber, ep.EpisodeNumber}] {
+				continue
+			}
+			m := MissingEpisode{
+				SeasonNumber:  s.SeasonNumber,
+				EpisodeNumber: ep.EpisodeNumber,
+				Title:         ep.Name,
+				AirDate:       ep.AirDate,
+			}
+			// Dates are ISO formatted so they compare as strings
+			if ep.AirDate != "" && ep.AirDate <= today {
+				report.Missing = append(report.Missing, m)
+			} else {
+				report.Upcoming = append(report.Upcoming, m)
+			}
+		}
+	}
+	return report, nil
+}
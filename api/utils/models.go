@@ -1,11 +1,36 @@
 package utils
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type User struct {
 	ID              primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
 	Name            string               `json:"name" bson:"name"`
+	Role            string               `json:"role" bson:"role"`                               // ROLE_*
+	MaxRating       string               `json:"maxRating,omitempty" bson:"maxRating,omitempty"` // Highest certification allowed, ex: "FR:-12"
 	OnGoingMediasID []primitive.ObjectID `json:"onGoingMedias" bson:"onGoingMedias"`
+	Auth            string               `json:"auth" bson:"auth,omitempty"`               // AUTH_*, how the profile logs in
+	SecretHash      string               `json:"-" bson:"secretHash,omitempty"`            // bcrypt of the password or PIN
+	Avatar          string               `json:"avatar,omitempty" bson:"avatar,omitempty"` // Preset key or AVATAR_CUSTOM, default preset when empty
+	AvatarFile      string               `json:"-" bson:"avatarFile,omitempty"`            // Uploaded picture, under AvatarDir
+	Preferences     UserPreferences      `json:"preferences" bson:"preferences"`
+}
+
+// Library is a named group of root directories (possibly on several disks)
+// holding either movies or series
+type Library struct {
+	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Key           string             `json:"key" bson:"key"`   // Stable identifier, ex: "movies_docu"
+	Name          string             `json:"name" bson:"name"` // Display name
+	Type          string             `json:"type" bson:"type"` // "movie" | "series"
+	Roots         []string           `json:"roots" bson:"roots"`
+	Kids          bool               `json:"kids" bson:"kids"`
+	ContentRating string             `json:"contentRating,omitempty" bson:"contentRating,omitempty"` // ex: "FR:-12"
+	MaxFileSize   int64              `json:"maxFileSize,omitempty" bson:"maxFileSize,omitempty"`     // Bytes, UPLOAD_MAX_SIZE when 0
+	MinFreeSpace  int64              `json:"minFreeSpace,omitempty" bson:"minFreeSpace,omitempty"`   // Bytes kept free on a root, UPLOAD_MIN_FREE_SPACE when 0
 }
 
 type OnGoingMedia struct {
@@ -21,8 +46,38 @@ type Movie struct {
 	TmdbID      int                `json:"tmdbID" bson:"tmdbID"`
 	Date        primitive.DateTime `json:"date" bson:"date"`
 	Poster      string             `json:"poster" bson:"poster"`
-	Rating      float64            `json:"rating,omitempty" bson:"rating,omitempty"`
-	FilePath    string             `json:"filePath" bson:"filePath"` // Actual file location
+	Rating      float64            `json:"rating,omitempty" bson:"rating,omitempty"` // TMDB rating at upload time
+	FilePath    string             `json:"filePath" bson:"filePath"`                 // Actual file location
+	Library     string             `json:"library" bson:"library"`                   // Library key
+	Versions    []MediaVersion     `json:"versions,omitempty" bson:"versions,omitempty"`
+
+	// Filled by the post-ingest pipeline
+	Overview   string                `json:"overview,omitempty" bson:"overview,omitempty"`
+	Genres     []string              `json:"genres,omitempty" bson:"genres,omitempty"`
+	Cast       []string              `json:"cast,omitempty" bson:"cast,omitempty"`
+	Runtime    int                   `json:"runtime,omitempty" bson:"runtime,omitempty"` // Minutes
+	MediaInfo  *ProbeResult          `json:"mediaInfo,omitempty" bson:"mediaInfo,omitempty"`
+	Chapters   []Chapter             `json:"chapters,omitempty" bson:"chapters,omitempty"`
+	Processing map[string]StepStatus `json:"processing,omitempty" bson:"processing,omitempty"` // By pipeline step
+
+	// Parental controls
+	Certifications map[string]string `json:"certifications,omitempty" bson:"certifications,omitempty"` // By country, ex: {"FR": "-12", "US": "PG-13"}
+	MinAge         *int              `json:"minAge,omitempty" bson:"minAge,omitempty"`                 // From the certifications, see RatingAge
+
+	HouseholdRating *RatingSummary `json:"householdRating,omitempty" bson:"householdRating,omitempty"` // Average of the users' ratings
+}
+
+// MediaVersion is one file of a movie or episode (edition, quality...).
+// FilePath on the owning document always points to the default version.
+type MediaVersion struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Label      string             `json:"label" bson:"label"`                               // ex: "4K HDR"
+	Edition    string             `json:"edition,omitempty" bson:"edition,omitempty"`       // ex: "Director's Cut"
+	Resolution string             `json:"resolution,omitempty" bson:"resolution,omitempty"` // ex: "2160p"
+	Source     string             `json:"source,omitempty" bson:"source,omitempty"`         // ex: "Remux", "WEB-DL"
+	FilePath   string             `json:"filePath" bson:"filePath"`
+	Format     string             `json:"format" bson:"format"`
+	Date       primitive.DateTime `json:"date" bson:"date"` // When added
 }
 
 type OnGoingMovie struct {
@@ -32,13 +87,18 @@ type OnGoingMovie struct {
 	Duration int                `json:"duration" bson:"duration"` // en secondes
 	Position int                `json:"position" bson:"position"` // en secondes
 	UserID   primitive.ObjectID `json:"user" bson:"user"`
+	Complete bool               `json:"complete" bson:"complete"`         // Past the watched threshold, counted in the history
+	Updated  time.Time          `json:"updated" bson:"updated,omitempty"` // Last progress report
 }
 
 type MovieQuery struct {
 	Title   string `form:"title" json:"title" bson:"title"`
 	Genre   string `form:"genre" json:"genre" bson:"genre"`
-	OrderBy string `form:"orderBy" json:"orderBy" bson:"orderBy"`
+	Library string `form:"library" json:"library" bson:"library"`
+	OrderBy string `form:"orderBy" json:"orderBy" bson:"orderBy"` // field:asc|desc, "householdRating" sorts by the household average
 	Limit   int    `form:"limit" json:"limit" bson:"limit"`
+
+	MinHouseholdRating float64 `form:"minHouseholdRating" json:"minHouseholdRating" bson:"minHouseholdRating"` // Only movies rated at least this on average
 }
 
 // Series represents a TV show
@@ -48,30 +108,88 @@ type Series struct {
 	CustomTitle string             `json:"-" bson:"customTitle,omitempty"`
 	TmdbID      int                `json:"tmdbID" bson:"tmdbID"`
 	Poster      string             `json:"poster" bson:"poster"`
-	Date        primitive.DateTime `json:"date" bson:"date"` // When added to library
-	Seasons     []Season           `json:"seasons" bson:"seasons"`
+	Date        primitive.DateTime `json:"date" bson:"date"`                             // When added to library
+	Library     string             `json:"library" bson:"library"`                       // Library key
+	Ordering    string             `json:"ordering,omitempty" bson:"ordering,omitempty"` // SERIES_ORDERING_*, aired when empty
+	Seasons     []Season           `json:"seasons,omitempty" bson:"-"`                   // Loaded from the "seasons" collection
+
+	// Parental controls, as on Movie
+	Certifications map[string]string `json:"certifications,omitempty" bson:"certifications,omitempty"`
+	MinAge         *int              `json:"minAge,omitempty" bson:"minAge,omitempty"`
+
+	HouseholdRating *RatingSummary `json:"householdRating,omitempty" bson:"householdRating,omitempty"` // As on Movie
 }
 
-// Season represents a season within a series
+// Season represents a season within a series, stored in the "seasons"
+// collection and kept in sync with the episodes present on disk
 type Season struct {
-	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	SeriesID     primitive.ObjectID `json:"seriesID" bson:"seriesID"`
-	SeasonNumber int                `json:"seasonNumber" bson:"seasonNumber"`
-	FolderName   string             `json:"folderName" bson:"folderName"`
-	Episodes     []Episode          `json:"episodes" bson:"episodes"`
+	ID               primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	SeriesID         primitive.ObjectID `json:"seriesID" bson:"seriesID"`
+	SeasonNumber     int                `json:"seasonNumber" bson:"seasonNumber"`
+	Name             string             `json:"name" bson:"name"`
+	Overview         string             `json:"overview,omitempty" bson:"overview,omitempty"`
+	Poster           string             `json:"poster,omitempty" bson:"poster,omitempty"`
+	AirDate          string             `json:"airDate,omitempty" bson:"airDate,omitempty"` // YYYY-MM-DD
+	FolderName       string             `json:"folderName" bson:"folderName"`
+	EpisodeCount     int                `json:"episodeCount" bson:"episodeCount"`                             // Episodes in the library
+	ExpectedEpisodes int                `json:"expectedEpisodes,omitempty" bson:"expectedEpisodes,omitempty"` // Episodes listed by TMDB
+	Episodes         []Episode          `json:"episodes,omitempty" bson:"-"`
 }
 
 // Episode represents an episode
 type Episode struct {
-	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	TmdbID        int                `json:"tmdbID" bson:"tmdbID"`
-	EpisodeNumber int                `json:"episodeNumber" bson:"episodeNumber"`
-	SeasonNumber  int                `json:"seasonNumber" bson:"seasonNumber"`
-	SeriesID      primitive.ObjectID `json:"seriesID" bson:"seriesID"`
-	Title         string             `json:"title" bson:"title"`
-	Runtime       int                `json:"runtime,omitempty" bson:"runtime,omitempty"` // Minutes
-	FilePath      string             `json:"filePath" bson:"filePath"`                   // Actual video file location
-	Date          primitive.DateTime `json:"date" bson:"date"`                           // When added to library
+	ID               primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	TmdbID           int                `json:"tmdbID" bson:"tmdbID"`
+	EpisodeNumber    int                `json:"episodeNumber" bson:"episodeNumber"`
+	EpisodeNumberEnd int                `json:"episodeNumberEnd,omitempty" bson:"episodeNumberEnd,omitempty"` // Last episode of a multi-episode file (S01E01-E02)
+	AbsoluteNumber   int                `json:"absoluteNumber,omitempty" bson:"absoluteNumber,omitempty"`     // Number across the series, for the absolute ordering
+	SeasonNumber     int                `json:"seasonNumber" bson:"seasonNumber"`                             // 0 for specials
+	SeriesID         primitive.ObjectID `json:"seriesID" bson:"seriesID"`
+	Title            string             `json:"title" bson:"title"`
+	Runtime          int                `json:"runtime,omitempty" bson:"runtime,omitempty"` // Minutes
+	FilePath         string             `json:"filePath" bson:"filePath"`                   // Actual video file location
+	Date             primitive.DateTime `json:"date" bson:"date"`                           // When added to library
+	Versions         []MediaVersion     `json:"versions,omitempty" bson:"versions,omitempty"`
+
+	// Filled by the post-ingest pipeline
+	Overview   string                `json:"overview,omitempty" bson:"overview,omitempty"`
+	AirDate    string                `json:"airDate,omitempty" bson:"airDate,omitempty"` // YYYY-MM-DD
+	MediaInfo  *ProbeResult          `json:"mediaInfo,omitempty" bson:"mediaInfo,omitempty"`
+	Chapters   []Chapter             `json:"chapters,omitempty" bson:"chapters,omitempty"`
+	Processing map[string]StepStatus `json:"processing,omitempty" bson:"processing,omitempty"` // By pipeline step
+
+	HouseholdRating *RatingSummary `json:"householdRating,omitempty" bson:"householdRating,omitempty"` // As on Movie
+}
+
+// Chapter is a chapter marker of a video file
+type Chapter struct {
+	Start float64 `json:"start" bson:"start"` // Seconds
+	End   float64 `json:"end" bson:"end"`
+	Title string  `json:"title" bson:"title"`
+}
+
+// StepStatus is the outcome of a pipeline step on a movie or episode
+type StepStatus struct {
+	Status   string             `json:"status" bson:"status"`                       // STEP_*
+	Message  string             `json:"message,omitempty" bson:"message,omitempty"` // Error or skip reason
+	Attempts int                `json:"attempts" bson:"attempts"`
+	Updated  primitive.DateTime `json:"updated" bson:"updated"`
+}
+
+// LastEpisodeNumber is the last episode held by the file
+func (ep Episode) LastEpisodeNumber() int {
+	if ep.EpisodeNumberEnd > ep.EpisodeNumber {
+		return ep.EpisodeNumberEnd
+	}
+	return ep.EpisodeNumber
+}
+
+// LastAbsoluteNumber is the last absolute number held by the file, 0 when unknown
+func (ep Episode) LastAbsoluteNumber() int {
+	if ep.AbsoluteNumber == 0 {
+		return 0
+	}
+	return ep.AbsoluteNumber + ep.LastEpisodeNumber() - ep.EpisodeNumber
 }
 
 // OnGoingEpisode for episode progress tracking
@@ -83,4 +201,6 @@ type OnGoingEpisode struct {
 	Duration  int                `json:"duration" bson:"duration"` // Seconds
 	Position  int                `json:"position" bson:"position"` // Seconds
 	UserID    primitive.ObjectID `json:"user" bson:"user"`
+	Complete  bool               `json:"complete" bson:"complete"` // As on OnGoingMovie
+	Updated   time.Time          `json:"updated" bson:"updated,omitempty"`
 }
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// UserPreferences are the playback settings of a profile
+type UserPreferences struct {
+	AudioLanguage    string        `json:"audioLanguage" bson:"audioLanguage"`       // ISO 639-1, ex: "fr"; "" for the file default
+	SubtitleLanguage string        `json:"subtitleLanguage" bson:"subtitleLanguage"` // "" for no subtitles but forced ones
+	SubtitleStyle    SubtitleStyle `json:"subtitleStyle" bson:"subtitleStyle"`
+	AutoplayNext     bool          `json:"autoplayNext" bson:"autoplayNext"`
+	MaturityLevel    string        `json:"maturityLevel" bson:"maturityLevel"` // Certification chosen by the profile, can only lower maxRating
+}
+
+type SubtitleStyle struct {
+	Size       string `json:"size" bson:"size"`             // SUBTITLE_SIZE_*
+	Color      string `json:"color" bson:"color"`           // #rrggbb
+	Background string `json:"background" bson:"background"` // SUBTITLE_BACKGROUND_*
+}
+
+const (
+	SUBTITLE_SIZE_SMALL  = "small"
+	SUBTITLE_SIZE_MEDIUM = "medium"
+	SUBTITLE_SIZE_LARGE  = "large"
+
+	SUBTITLE_BACKGROUND_NONE   = "none"
+	SUBTITLE_BACKGROUND_SHADOW = "shadow"
+	SUBTITLE_BACKGROUND_BOX    = "box"
+)
+
+// DefaultPreferences are given to new profiles
+func DefaultPreferences() UserPreferences {
+	return UserPreferences{
+		SubtitleStyle: SubtitleStyle{Size: SUBTITLE_SIZE_MEDIUM, Color: "#ffffff", Background: SUBTITLE_BACKGROUND_SHADOW},
+		AutoplayNext:  true,
+	}
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Validate checks preferences sent by a client, normalizing languages
+func (p *UserPreferences) Validate() error {
+	for _, lang := range []*string{&p.AudioLanguage, &p.SubtitleLanguage} {
+		if *lang == "" {
+			continue
+		}
+		code, ok := LanguageCode(*lang)
+		if !ok {
+			return fmt.Errorf("unknown language %q", *lang)
+		}
+		*lang = code
+	}
+	switch p.SubtitleStyle.Size {
+	case SUBTITLE_SIZE_SMALL, SUBTITLE_SIZE_MEDIUM, SUBTITLE_SIZE_LARGE:
+	default:
+		return fmt.Errorf("invalid subtitle size %q", p.SubtitleStyle.Size)
+	}
+	switch p.SubtitleStyle.Background {
+	case SUBTITLE_BACKGROUND_NONE, SUBTITLE_BACKGROUND_SHADOW, SUBTITLE_BACKGROUND_BOX:
+	default:
+		return fmt.Errorf("invalid subtitle background %q", p.SubtitleStyle.Background)
+	}
+	if !hexColor.MatchString(p.SubtitleStyle.Color) {
+		return fmt.Errorf("invalid subtitle color %q (expected #rrggbb)", p.SubtitleStyle.Color)
+	}
+	if _, ok := CertificationAge(p.MaturityLevel); p.MaturityLevel != "" && !ok {
+		return fmt.Errorf("unknown certification %q", p.MaturityLevel)
+	}
+	return nil
+}
+
+// ISO 639-2 bibliographic codes, used by some files, that x/text doesn't know
+var bibliographicLanguages = map[string]string{
+	"alb": "sqi", "arm": "hye", "baq": "eus", "bur": "mya", "chi": "zho",
+	"cze": "ces", "dut": "nld", "fre": "fra", "geo": "kat", "ger": "deu",
+	"gre": "ell", "ice": "isl", "mac": "mkd", "mao": "mri", "may": "msa",
+	"per": "fas", "rum": "ron", "slo": "slk", "tib": "bod", "wel": "cym",
+}
+
+// LanguageCode normalizes a language tag of a file or a preference to its
+// ISO 639-1 code when there is one ("fre", "fra", "fr-FR" -> "fr")
+func LanguageCode(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if b, ok := bibliographicLanguages[tag]; ok {
+		tag = b
+	}
+	base, err := language.ParseBase(strings.SplitN(strings.ReplaceAll(tag, "_", "-"), "-", 2)[0])
+	if err != nil || base.String() == "und" {
+		return "", false
+	}
+	return base.String(), true
+}
+
+// SameLanguage tells whether a track language matches a preference
+func SameLanguage(track, pref string) bool {
+	a, ok := LanguageCode(track)
+	b, ok2 := LanguageCode(pref)
+	return ok && ok2 && a == b
+}
+
+// ChooseTracks picks the audio and subtitle tracks of a file for the
+// preferences: the audio in the preferred language, else the default one;
+// subtitles in the preferred language unless the audio already is, else the
+// forced subtitles of the audio language. Nil when there is nothing to pick.
+func ChooseTracks(info *ProbeResult, prefs UserPreferences) (audio, subtitle *Track) {
+	if info == nil {
+		return nil, nil
+	}
+	pick := func(tracks []Track, match func(Track) bool) *Track {
+		for i := range tracks {
+			if match(tracks[i]) {
+				return &tracks[i]
+			}
+		}
+		return nil
+	}
+
+	if prefs.AudioLanguage != "" {
+		audio = pick(info.AudioTracks, func(t Track) bool { return SameLanguage(t.Language, prefs.AudioLanguage) })
+	}
+	if audio == nil {
+		audio = pick(info.AudioTracks, func(t Track) bool { return t.Default })
+	}
+	if audio == nil && len(info.AudioTracks) > 0 {
+		audio = &info.AudioTracks[0]
+	}
+	audioLang := ""
+	if audio != nil {
+		audioLang = audio.Language
+	}
+
+	if prefs.SubtitleLanguage != "" && !SameLanguage(audioLang, prefs.SubtitleLanguage) {
+		subtitle = pick(info.SubtitleTracks, func(t Track) bool {
+			return !t.Forced && SameLanguage(t.Language, prefs.SubtitleLanguage)
+		})
+		if subtitle == nil {
+			subtitle = pick(info.SubtitleTracks, func(t Track) bool { return SameLanguage(t.Language, prefs.SubtitleLanguage) })
+		}
+	}
+	if subtitle == nil && audioLang != "" {
+		subtitle = pick(info.SubtitleTracks, func(t Track) bool { return t.Forced && SameLanguage(t.Language, audioLang) })
+	}
+	return audio, subtitle
+}
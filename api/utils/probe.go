@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrProbeUnavailable is returned when ffprobe is not installed; uploads are
+// then only checked by their magic bytes
+var ErrProbeUnavailable = errors.New("ffprobe not found")
+
+// ProbeResult describes a media file as seen by ffprobe
+type ProbeResult struct {
+	Format      string   `json:"format" bson:"format"`     // ffprobe format_name, ex: "matroska,webm"
+	Duration    float64  `json:"duration" bson:"duration"` // Seconds
+	Size        int64    `json:"size" bson:"size"`
+	VideoCodec  string   `json:"videoCodec" bson:"videoCodec"`
+	Width       int      `json:"width" bson:"width"`
+	Height      int      `json:"height" bson:"height"`
+	AudioCodecs []string `json:"audioCodecs" bson:"audioCodecs"`
+
+	AudioTracks    []Track `json:"audioTracks,omitempty" bson:"audioTracks,omitempty"`
+	SubtitleTracks []Track `json:"subtitleTracks,omitempty" bson:"subtitleTracks,omitempty"`
+}
+
+// Track is an audio or subtitle stream of a file
+type Track struct {
+	Index    int    `json:"index" bson:"index"` // Among the streams of its type, as ffmpeg's -map 0:a:<index>
+	Codec    string `json:"codec" bson:"codec"`
+	Language string `json:"language,omitempty" bson:"language,omitempty"` // ISO 639-2 as tagged, ex: "fre"
+	Title    string `json:"title,omitempty" bson:"title,omitempty"`
+	Default  bool   `json:"default,omitempty" bson:"default,omitempty"`
+	Forced   bool   `json:"forced,omitempty" bson:"forced,omitempty"`
+}
+
+// ProbeFile runs ffprobe on a file
+func ProbeFile(ctx context.Context, path string) (ProbeResult, error) {
+	var res ProbeResult
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return res, ErrProbeUnavailable
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return res, fmt.Errorf("ffprobe: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return res, err
+	}
+
+	var parsed struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			Size       string `json:"size"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType   string `json:"codec_type"`
+			CodecName   string `json:"codec_name"`
+			Width       int    `json:"width"`
+			Height      int    `json:"height"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+				Default     int `json:"default"`
+				Forced      int `json:"forced"`
+			} `json:"disposition"`
+			Tags struct {
+				Language string `json:"language"`
+				Title    string `json:"title"`
+			} `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return res, err
+	}
+
+	res.Format = parsed.Format.FormatName
+	res.Duration, _ = strconv.ParseFloat(parsed.Format.Duration, 64)
+	res.Size, _ = strconv.ParseInt(parsed.Format.Size, 10, 64)
+	res.AudioCodecs = []string{}
+	for _, s := range parsed.Streams {
+		switch s.CodecType {
+		case "video":
+			// Cover art embedded in the file is not a video track
+			if s.Disposition.AttachedPic == 0 && res.VideoCodec == "" {
+				res.VideoCodec, res.Width, res.Height = s.CodecName, s.Width, s.Height
+			}
+		case "audio":
+			res.AudioTracks = append(res.AudioTracks, Track{
+				Index: len(res.AudioCodecs), Codec: s.CodecName, Language: s.Tags.Language, Title: s.Tags.Title,
+				Default: s.Disposition.Default == 1, Forced: s.Disposition.Forced == 1,
+			})
+			res.AudioCodecs = append(res.AudioCodecs, s.CodecName)
+		case "subtitle":
+			res.SubtitleTracks = append(res.SubtitleTracks, Track{
+				Index: len(res.SubtitleTracks), Codec: s.CodecName, Language: s.Tags.Language, Title: s.Tags.Title,
+				Default: s.Disposition.Default == 1, Forced: s.Disposition.Forced == 1,
+			})
+		}
+	}
+	return res, nil
+}
+
+// SniffVideo tells whether the first bytes of a file look like a video
+// container (Matroska/WebM, MP4/MOV, AVI, MPEG-TS/PS, FLV, ASF/WMV, Ogg)
+func SniffVideo(head []byte) bool {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}): // Matroska, WebM
+		return true
+	case len(head) >= 8 && (string(head[4:8]) == "ftyp" || string(head[4:8]) == "moov" || string(head[4:8]) == "mdat" || string(head[4:8]) == "wide" || string(head[4:8]) == "free"): // MP4, MOV
+		return true
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+		return true
+	case len(head) >= 189 && head[0] == 0x47 && head[188] == 0x47: // MPEG-TS
+		return true
+	case bytes.HasPrefix(head, []byte{0x00, 0x00, 0x01, 0xBA}): // MPEG-PS
+		return true
+	case bytes.HasPrefix(head, []byte("FLV")):
+		return true
+	case bytes.HasPrefix(head, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}): // ASF, WMV
+		return true
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A rating is a score from 1 to 10, or thumbs stored as the extreme scores
+const (
+	RATING_MIN        = 1
+	RATING_MAX        = 10
+	THUMB_UP          = "up"
+	THUMB_DOWN        = "down"
+	RATING_NOTE_MAX   = 500 // Characters
+	RATING_THUMB_UP   = RATING_MAX
+	RATING_THUMB_DOWN = RATING_MIN
+)
+
+// UserRating is the rating of a movie, series or episode by a user, in the
+// "ratings" collection
+type UserRating struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID  primitive.ObjectID `json:"user" bson:"user"`
+	Type    string             `json:"type" bson:"type"` // "movie" | "series" | "episode"
+	MediaID primitive.ObjectID `json:"mediaId" bson:"media"`
+	Score   int                `json:"score" bson:"score"`                     // RATING_MIN to RATING_MAX
+	Thumb   string             `json:"thumb,omitempty" bson:"thumb,omitempty"` // THUMB_*, when rated with thumbs
+	Note    string             `json:"note,omitempty" bson:"note,omitempty"`
+	Updated time.Time          `json:"updated" bson:"updated"`
+}
+
+// RatingSummary is the household average kept on movies, series and episodes
+type RatingSummary struct {
+	Average float64 `json:"average" bson:"average"`
+	Count   int     `json:"count" bson:"count"`
+}
+
+// ValidateRating checks a rating and fills its score from the thumb
+func ValidateRating(r *UserRating) error {
+	switch r.Thumb {
+	case "":
+		if r.Score < RATING_MIN || r.Score > RATING_MAX {
+			return fmt.Errorf("score must be between %d and %d", RATING_MIN, RATING_MAX)
+		}
+	case THUMB_UP:
+		r.Score = RATING_THUMB_UP
+	case THUMB_DOWN:
+		r.Score = RATING_THUMB_DOWN
+	default:
+		return fmt.Errorf("invalid thumb %q", r.Thumb)
+	}
+	r.Note = strings.TrimSpace(r.Note)
+	if len([]rune(r.Note)) > RATING_NOTE_MAX {
+		return fmt.Errorf("note is limited to %d characters", RATING_NOTE_MAX)
+	}
+	return nil
+}
+
+// RoundRating rounds an average to one decimal
+func RoundRating(avg float64) float64 {
+	return math.Round(avg*10) / 10
+}
+
+// RatedCollection is the collection of a rated media type
+func RatedCollection(typ string) (string, error) {
+	switch typ {
+	case "movie":
+		return "movies", nil
+	case "series":
+		return "series", nil
+	case "episode":
+		return "episodes", nil
+	}
+	return "", errors.New("type must be movie, series or episode")
+}
+
+// UpdateRatingSummary recomputes the household average of a media from the
+// ratings, removing it once nobody rates the media anymore
+func UpdateRatingSummary(ctx context.Context, typ string, mediaID primitive.ObjectID) error {
+	coll, err := RatedCollection(typ)
+	if err != nil {
+		return err
+	}
+	cursor, err := GetCollection("ratings").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"type": typ, "media": mediaID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "average": bson.M{"$avg": "$score"}, "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []RatingSummary
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	update := bson.M{"$unset": bson.M{"householdRating": ""}}
+	if len(groups) > 0 {
+		s := groups[0]
+		s.Average = RoundRating(s.Average)
+		update = bson.M{"$set": bson.M{"householdRating": s}}
+	}
+	_, err = GetCollection(coll).UpdateOne(ctx, bson.M{"_id": mediaID}, update)
+	return err
+}
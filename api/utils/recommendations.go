@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Home-screen rows, computed from the watch history, the ratings and the
+// metadata stored by the pipeline
+const (
+	RECOMMENDATION_ROW_SIZE  = 20
+	BECAUSE_YOU_WATCHED_ROWS = 3                    // Most recently watched movies getting a row
+	FAVOURITE_GENRES         = 3                    // Genres of the "favourite genres" row
+	REDISCOVER_AFTER         = 180 * 24 * time.Hour // Titles watched or added at least that long ago
+)
+
+// Similarity scores how close two movies are: the share of genres in common
+// (Jaccard) plus a quarter per shared cast member, up to 1
+func Similarity(a, b Movie) float64 {
+	score := 0.0
+	if len(a.Genres) > 0 && len(b.Genres) > 0 {
+		union := map[string]bool{}
+		shared := 0
+		for _, g := range a.Genres {
+			union[g] = true
+		}
+		for _, g := range b.Genres {
+			if union[g] {
+				shared++
+			}
+			union[g] = true
+		}
+		score += float64(shared) / float64(len(union))
+	}
+	cast := map[string]bool{}
+	for _, name := range a.Cast {
+		cast[name] = true
+	}
+	shared := 0
+	for _, name := range b.Cast {
+		if cast[name] {
+			shared++
+		}
+	}
+	return score + min(float64(shared)*0.25, 1)
+}
+
+// GenreWeights measures the taste of a user: each watched movie counts for
+// its genres, and ratings move them up (10) or down (1) by up to one more
+func GenreWeights(watched []Movie, scores map[primitive.ObjectID]int) map[string]float64 {
+	weights := map[string]float64{}
+	for _, m := range watched {
+		for _, g := range m.Genres {
+			weights[g]++
+		}
+	}
+	for _, m := range watched {
+		score, ok := scores[m.ID]
+		if !ok {
+			continue
+		}
+		bonus := (float64(score) - 5.5) / 4.5
+		for _, g := range m.Genres {
+			weights[g] += bonus
+		}
+	}
+	return weights
+}
+
+// TopGenres returns the n heaviest genres with a positive weight
+func TopGenres(weights map[string]float64, n int) []string {
+	genres := []string{}
+	for g, w := range weights {
+		if w > 0 {
+			genres = append(genres, g)
+		}
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		if weights[genres[i]] != weights[genres[j]] {
+			return weights[genres[i]] > weights[genres[j]]
+		}
+		return genres[i] < genres[j]
+	})
+	if len(genres) > n {
+		genres = genres[:n]
+	}
+	return genres
+}
+
+// DailyRand is seeded with the user and the day, so random rows stay the
+// same for a user until the next day
+func DailyRand(userID primitive.ObjectID) *rand.Rand {
+	h := fnv.New64a()
+	h.Write(userID[:])
+	h.Write([]byte(time.Now().Format(time.DateOnly)))
+	return rand.New(rand.NewPCG(h.Sum64(), 0))
+}
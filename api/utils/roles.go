@@ -0,0 +1,78 @@
+package utils
+
+import "fmt"
+
+// Roles of the profiles
+const (
+	ROLE_ADMIN    = "admin"    // Manages libraries, media and profiles
+	ROLE_STANDARD = "standard" // Watches everything
+	ROLE_KID      = "kid"      // Only sees the kids libraries
+)
+
+// ValidateRole checks a role sent by a client
+func ValidateRole(role string) error {
+	switch role {
+	case ROLE_ADMIN, ROLE_STANDARD, ROLE_KID:
+		return nil
+	}
+	return fmt.Errorf("invalid role %q", role)
+}
+
+// CanSeeLibrary tells whether a profile may browse a library: kid profiles
+// only see the kids libraries rated at most KIDS_MAX_CERTIFICATION
+func (u User) CanSeeLibrary(lib Library) bool {
+	if u.Role != ROLE_KID {
+		return true
+	}
+	if !lib.Kids {
+		return false
+	}
+	max := KidsMaxCertification()
+	return max == "" || lib.ContentRating == "" || CertificationWithin(lib.ContentRating, max)
+}
+
+// MaxAge returns the age limit of the content a profile may watch: its
+// maxRating, or KIDS_MAX_CERTIFICATION for kid profiles, lowered by the
+// maturity level of its preferences. False means no limit.
+func (u User) MaxAge() (int, bool) {
+	limit := u.MaxRating
+	if limit == "" && u.Role == ROLE_KID {
+		limit = KidsMaxCertification()
+	}
+	maxAge, limited := 0, false
+	if limit != "" {
+		// A misconfigured limit only allows content rated for all ages
+		maxAge, _ = CertificationAge(limit)
+		limited = true
+	}
+	if age, ok := CertificationAge(u.Preferences.MaturityLevel); ok && (!limited || age < maxAge) {
+		maxAge, limited = age, true
+	}
+	return maxAge, limited
+}
+
+// Restricted tells whether some content may be hidden from the profile
+func (u User) Restricted() bool {
+	_, limited := u.MaxAge()
+	return u.Role == ROLE_KID || limited
+}
+
+// CanWatch tells whether a profile may see a movie or series of the library
+// rated minAge (nil when unrated)
+func (u User) CanWatch(library string, minAge *int) bool {
+	lib, err := GetLibrary(library)
+	if err != nil {
+		lib = Library{Key: library}
+	}
+	if !u.CanSeeLibrary(lib) {
+		return false
+	}
+	maxAge, limited := u.MaxAge()
+	if !limited {
+		return true
+	}
+	if minAge != nil {
+		return *minAge <= maxAge
+	}
+	return libraryAllows(lib, maxAge)
+}
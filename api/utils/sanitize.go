@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxNameBytes is the longest file or folder name most filesystems accept
+const MaxNameBytes = 255
+
+// ErrUnsafePath is returned when a relative path would leave its root
+var ErrUnsafePath = errors.New("path escapes its root")
+
+// Characters refused by Windows/SMB shares, with their replacement
+var nameReplacer = strings.NewReplacer(
+	"/", "_", "\\", "_", ":", " - ", "*", "-", "?", "", "\"", "", "'", "", "<", "", ">", "", "|", "-",
+)
+
+// Device names Windows reserves whatever the extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName turns a title into a single file or folder name usable on
+// Linux, macOS and Windows/SMB shares: Unicode is normalized (NFC) so the same
+// title always gives the same bytes, separators and reserved characters are
+// replaced, control characters dropped, leading/trailing dots and spaces
+// trimmed, reserved device names escaped and the length capped.
+func SanitizeName(name string) string {
+	n := norm.NFC.String(name)
+	n = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, n)
+	n = nameReplacer.Replace(n)
+	n = strings.Join(strings.Fields(n), " ")
+	n = strings.Trim(n, ". ")
+
+	stem := n
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if reservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		n = "_" + n
+	}
+
+	n = strings.TrimRight(TruncateName(n, MaxNameBytes), ". ")
+	if n == "" {
+		n = "untitled"
+	}
+	return n
+}
+
+// TruncateName shortens a name to max bytes without splitting a UTF-8
+// character, keeping its extension
+func TruncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > 10 || len(ext) >= max {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	limit := max - len(ext)
+	for limit > 0 && !utf8.RuneStart(stem[limit]) {
+		limit--
+	}
+	return strings.TrimRight(stem[:limit], " ") + ext
+}
+
+// SafeJoin joins a relative path to root, refusing absolute paths, ".."
+// elements and symlinks that would end outside of root
+func SafeJoin(root, rel string) (string, error) {
+	if rel == "" || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, rel)
+	}
+	for _, part := range strings.FieldsFunc(rel, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, rel)
+		}
+	}
+	joined := filepath.Join(root, rel)
+	cleanRoot := filepath.Clean(root)
+	if !within(cleanRoot, joined) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, rel)
+	}
+
+	// The part of the path already on disk is resolved, so a symlink can't
+	// lead outside of root either
+	realRoot, err := filepath.EvalSymlinks(cleanRoot)
+	if err != nil {
+		return joined, nil // root not created yet
+	}
+	existing := joined
+	for existing != cleanRoot {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil || !within(realRoot, real) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, rel)
+	}
+	return joined, nil
+}
+
+// within tells whether path is root or inside it, both being clean
+func within(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// candidatePath returns path for n == 1, then "name (n).ext"
+func candidatePath(path string, n int) string {
+	if n == 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	if len(ext) > 10 {
+		ext = ""
+	}
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// KeepUniqueSuffix carries over to the " (n)" suffix CreateUnique gave from,
+// when from is a numbered copy of the same name. Reorganising a duplicate
+// then keeps it apart from the original instead of colliding with it.
+func KeepUniqueSuffix(from, to string) string {
+	fromName, toName := filepath.Base(from), filepath.Base(to)
+	for n := 2; n <= 1000; n++ {
+		if candidatePath(toName, n) == fromName {
+			return candidatePath(to, n)
+		}
+	}
+	return to
+}
+
+// CreateUnique creates path, or "name (2).ext", "name (3).ext"... when it is
+// taken. The file is created with O_EXCL so concurrent uploads never share
+// or overwrite a file.
+func CreateUnique(path string) (*os.File, error) {
+	for n := 1; n <= 1000; n++ {
+		f, err := os.OpenFile(candidatePath(path, n), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no free name for %s", path)
+}
+
+// RenameUnique moves src to path, or to the next free "name (n).ext", and
+// returns the path used. The destination is claimed with CreateUnique first,
+// so an existing file is never replaced.
+func RenameUnique(src, path string) (string, error) {
+	claim, err := CreateUnique(path)
+	if err != nil {
+		return "", err
+	}
+	dst := claim.Name()
+	claim.Close()
+	if err := os.Rename(src, dst); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return dst, nil
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "The Matrix", "The Matrix"},
+		{"reserved device", "CON", "_CON"},
+		{"reserved with extension", "nul.mkv", "_nul.mkv"},
+		{"reserved lowercase", "com1", "_com1"},
+		{"reserved prefix only", "CONSOLE", "CONSOLE"},
+		{"trailing dot and space", "Title. ", "Title"},
+		{"leading dots", "  ..Hidden", "Hidden"},
+		{"colon", "Star Wars: Episode IV", "Star Wars - Episode IV"},
+		{"separators", `A/B\C`, "A_B_C"},
+		{"wildcards and pipe", "a*b|c", "a-b-c"},
+		{"dropped characters", `<"What?">`, "What"},
+		{"apostrophe", "L'Été", "LÉté"},
+		{"whitespace", "tab\tand\nnew  line", "tab and new line"},
+		{"control character", "bell\x07", "bell"},
+		{"NFC", "Ame\u0301lie", "Am\u00e9lie"},
+		{"only dots", "...", "untitled"},
+		{"empty", "", "untitled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeName(tt.in); got != tt.want {
+				t.Errorf("SanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeNameLength(t *testing.T) {
+	got := SanitizeName(strings.Repeat("é", 300) + ".mkv")
+	if len(got) > MaxNameBytes || !utf8.ValidString(got) || !strings.HasSuffix(got, ".mkv") {
+		t.Errorf("SanitizeName() = %d bytes, valid=%v, %q", len(got), utf8.ValidString(got), got[len(got)-4:])
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want string
+	}{
+		{"short", "movie.mkv", MaxNameBytes, "movie.mkv"},
+		{"ascii", strings.Repeat("a", 300) + ".mkv", MaxNameBytes, strings.Repeat("a", 251) + ".mkv"},
+		{"rune not split", strings.Repeat("é", 200) + ".mkv", MaxNameBytes, strings.Repeat("é", 125) + ".mkv"},
+		{"long extension dropped", strings.Repeat("a", 20) + ".verylongextension", 10, strings.Repeat("a", 10)},
+		{"trailing space", "abcd efgh.mkv", 9, "abcd.mkv"},
+		{"no extension", "abcdefgh", 4, "abcd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncateName(tt.in, tt.max)
+			if got != tt.want {
+				t.Errorf("TruncateName() = %q, want %q", got, tt.want)
+			}
+			if len(got) > tt.max || !utf8.ValidString(got) {
+				t.Errorf("TruncateName() = %d bytes, valid=%v", len(got), utf8.ValidString(got))
+			}
+		})
+	}
+}
+
+func TestCreateUnique(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		path     string
+		want     string
+	}{
+		{"free", nil, "Movie.mkv", "Movie.mkv"},
+		{"taken", []string{"Movie.mkv"}, "Movie.mkv", "Movie (2).mkv"},
+		{"several taken", []string{"Movie.mkv", "Movie (2).mkv"}, "Movie.mkv", "Movie (3).mkv"},
+		{"gap kept", []string{"Movie.mkv", "Movie (3).mkv"}, "Movie.mkv", "Movie (2).mkv"},
+		{"no extension", []string{"Folder"}, "Folder", "Folder (2)"},
+		{"long extension", []string{"file.verylongextension"}, "file.verylongextension", "file.verylongextension (2)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f, err := CreateUnique(filepath.Join(dir, tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			if got := filepath.Base(f.Name()); got != tt.want {
+				t.Errorf("CreateUnique() = %q, want %q", got, tt.want)
+			}
+			for _, name := range tt.existing {
+				if data, _ := os.ReadFile(filepath.Join(dir, name)); string(data) != name {
+					t.Errorf("%s was overwritten", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameUnique(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "Movie.mkv")
+	if err := os.WriteFile(dst, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Movie (2).mkv", "Movie (3).mkv"} {
+		src := filepath.Join(dir, "upload.tmp")
+		if err := os.WriteFile(src, []byte(want), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := RenameUnique(src, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(got) != want {
+			t.Errorf("RenameUnique() = %q, want %q", filepath.Base(got), want)
+		}
+		if data, _ := os.ReadFile(got); string(data) != want {
+			t.Errorf("%s holds %q", want, data)
+		}
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Errorf("source still there: %v", err)
+		}
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "original" {
+		t.Errorf("destination overwritten: %q", data)
+	}
+
+	// A missing destination folder fails without touching the source
+	src := filepath.Join(dir, "upload.tmp")
+	if err := os.WriteFile(src, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RenameUnique(src, filepath.Join(dir, "missing", "Movie.mkv")); err == nil {
+		t.Error("RenameUnique() into a missing folder succeeded")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("source lost: %v", err)
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "library")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "Series", "Saison 1"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(root, "escape"):          outside,
+		filepath.Join(root, "inside"):          filepath.Join(root, "Series"),
+		filepath.Join(root, "dangling.mkv"):    filepath.Join(outside, "missing.mkv"),
+		filepath.Join(root, "Series", "up"):    base,
+		filepath.Join(outside, "back-to-root"): root,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		rel  string
+		want string // "" when refused
+	}{
+		{"file", "movie.mkv", filepath.Join(root, "movie.mkv")},
+		{"nested new folders", "New/Saison 2/0201.mkv", filepath.Join(root, "New", "Saison 2", "0201.mkv")},
+		{"existing folder", "Series/Saison 1/0101.mkv", filepath.Join(root, "Series", "Saison 1", "0101.mkv")},
+		{"dot segments", "./Series/./0101.mkv", filepath.Join(root, "Series", "0101.mkv")},
+		{"parent", "../outside/file.mkv", ""},
+		{"parent in the middle", "Series/../../outside", ""},
+		{"parent staying inside", "Series/../movie.mkv", ""},
+		{"backslash parent", `Series\..\..\outside`, ""},
+		{"absolute", filepath.Join(outside, "file.mkv"), ""},
+		{"empty", "", ""},
+		{"symlink to outside", "escape/file.mkv", ""},
+		{"symlink to outside itself", "escape", ""},
+		{"nested symlink to outside", "Series/up/outside/file.mkv", ""},
+		{"dangling symlink", "dangling.mkv", ""},
+		{"symlink inside root", "inside/Saison 1/0102.mkv", filepath.Join(root, "inside", "Saison 1", "0102.mkv")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SafeJoin(root, tt.rel)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Errorf("SafeJoin(%q) = %q, %v, want ErrUnsafePath", tt.rel, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("SafeJoin(%q) = %q, %v, want %q", tt.rel, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeJoinMissingRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "not-created")
+	got, err := SafeJoin(root, "Series/0101.mkv")
+	if err != nil || got != filepath.Join(root, "Series", "0101.mkv") {
+		t.Errorf("SafeJoin() = %q, %v", got, err)
+	}
+	if _, err := SafeJoin(root, "../file.mkv"); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("SafeJoin(..) = %v, want ErrUnsafePath", err)
+	}
+}
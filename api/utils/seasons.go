@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SyncSeason recomputes a season record from the episodes in the library: it is
+// created with the first episode, deleted with the last one, and its metadata
+// is fetched from TMDB when missing or when refresh is set
+func SyncSeason(ctx context.Context, series Series, number int, refresh bool) error {
+	return syncSeason(ctx, series, number, true, refresh)
+}
+
+// SeedSeason is SyncSeason without TMDB: seasons seeded this way keep an
+// empty name until FillSeasonNames fetches their metadata
+func SeedSeason(ctx context.Context, series Series, number int) error {
+	return syncSeason(ctx, series, number, false, false)
+}
+
+func syncSeason(ctx context.Context, series Series, number int, fetch, refresh bool) error {
+	coll := GetCollection("seasons")
+	filter := bson.M{"seriesID": series.ID, "seasonNumber": number}
+
+	count, err := GetCollection("episodes").CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err := coll.DeleteOne(ctx, filter)
+		return err
+	}
+
+	var current Season
+	_ = coll.FindOne(ctx, filter).Decode(&current)
+
+	set := bson.M{"episodeCount": int(count)}
+
+	var ep Episode
+	if err := GetCollection("episodes").FindOne(ctx, filter).Decode(&ep); err == nil && ep.FilePath != "" {
+		folder := filepath.Base(filepath.Dir(ep.FilePath))
+		if folder != series.CustomTitle {
+			set["folderName"] = folder
+		} else {
+			set["folderName"] = "" // files at the root of the series folder
+		}
+	}
+
+	if fetch && (refresh || current.Name == "") {
+		tmdb, err := GetTmdbSeason(ctx, series.TmdbID, number)
+		switch {
+		case err == nil:
+			set["name"] = tmdb.Name
+			set["overview"] = tmdb.Overview
+			set["poster"] = tmdb.PosterPath
+			set["airDate"] = tmdb.AirDate
+			set["expectedEpisodes"] = len(tmdb.Episodes)
+		case current.Name == "":
+			if err != ErrTmdbDisabled {
+				log.Printf("TMDB season %d/%d: %v", series.TmdbID, number, err)
+			}
+			set["name"] = DefaultSeasonName(number)
+		}
+	}
+
+	_, err = coll.UpdateOne(ctx, filter, bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "seriesID": series.ID, "seasonNumber": number},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// DefaultSeasonName names a season TMDB knows nothing about
+func DefaultSeasonName(number int) string {
+	if number == 0 {
+		return "Épisodes spéciaux"
+	}
+	return fmt.Sprintf("Saison %d", number)
+}
+
+// SyncSeriesSeasons syncs every season having episodes or a record
+func SyncSeriesSeasons(ctx context.Context, series Series, refresh bool) error {
+	return syncSeriesSeasons(ctx, series, func(n int) error { return SyncSeason(ctx, series, n, refresh) })
+}
+
+// SeedSeriesSeasons seeds every season having episodes or a record, without TMDB
+func SeedSeriesSeasons(ctx context.Context, series Series) error {
+	return syncSeriesSeasons(ctx, series, func(n int) error { return SeedSeason(ctx, series, n) })
+}
+
+func syncSeriesSeasons(ctx context.Context, series Series, sync func(number int) error) error {
+	numbers := map[int]bool{}
+	for _, coll := range []string{"episodes", "seasons"} {
+		values, err := GetCollection(coll).Distinct(ctx, "seasonNumber", bson.M{"seriesID": series.ID})
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			switch n := v.(type) {
+			case int32:
+				numbers[int(n)] = true
+			case int64:
+				numbers[int(n)] = true
+			}
+		}
+	}
+	for n := range numbers {
+		if err := sync(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FillSeasonNames fetches from TMDB the metadata of the seeded seasons, one
+// season at a time. It runs in the background once the server is started.
+func FillSeasonNames(ctx context.Context) {
+	cursor, err := GetCollection("seasons").Find(ctx, bson.M{"name": bson.M{"$in": bson.A{"", nil}}})
+	if err != nil {
+		log.Printf("Season names: %v", err)
+		return
+	}
+	var seasons []Season
+	if err := cursor.All(ctx, &seasons); err != nil {
+		log.Printf("Season names: %v", err)
+		return
+	}
+	series := map[primitive.ObjectID]*Series{}
+	for _, season := range seasons {
+		s, ok := series[season.SeriesID]
+		if !ok {
+			var found Series
+			if err := GetCollection("series").FindOne(ctx, bson.M{"_id": season.SeriesID}).Decode(&found); err == nil {
+				s = &found
+			}
+			series[season.SeriesID] = s
+		}
+		if s == nil {
+			continue
+		}
+		if err := SyncSeason(ctx, *s, season.SeasonNumber, false); err != nil {
+			log.Printf("Season names: %v", err)
+			return
+		}
+	}
+}
+
+// GetSeasons returns the season records of a series, ordered by number
+func GetSeasons(ctx context.Context, seriesID primitive.ObjectID) ([]Season, error) {
+	cursor, err := GetCollection("seasons").Find(ctx, bson.M{"seriesID": seriesID},
+		options.Find().SetSort(bson.D{{Key: "seasonNumber", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	seasons := []Season{}
+	if err := cursor.All(ctx, &seasons); err != nil {
+		return nil, err
+	}
+	for i := range seasons {
+		if seasons[i].Name == "" {
+			seasons[i].Name = DefaultSeasonName(seasons[i].SeasonNumber) // not fetched yet
+		}
+	}
+	return seasons, nil
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stream URLs are signed so a <video> element or a cast device can load them
+// without a session: the signature covers one media, the user it was issued
+// to and an expiry.
+
+const DEFAULT_STREAM_URL_TTL = 6 * time.Hour
+
+var (
+	ErrStreamSignature = errors.New("invalid stream signature")
+	ErrStreamExpired   = errors.New("stream URL expired")
+
+	streamKeyOnce sync.Once
+	streamKey     []byte
+)
+
+// streamSigningKey reads STREAM_SIGNING_KEY. Without one a random key is
+// used, signed URLs then stop working when the server restarts.
+func streamSigningKey() []byte {
+	streamKeyOnce.Do(func() {
+		if key := os.Getenv("STREAM_SIGNING_KEY"); key != "" {
+			streamKey = []byte(key)
+			return
+		}
+		streamKey = make([]byte, 32)
+		if _, err := rand.Read(streamKey); err != nil {
+			log.Fatalf("failed to generate the stream signing key: %v", err)
+		}
+		log.Printf("Note: STREAM_SIGNING_KEY not set, stream URLs won't survive a restart")
+	})
+	return streamKey
+}
+
+// StreamURLTTL is the validity of signed URLs (STREAM_URL_TTL, Go duration)
+func StreamURLTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("STREAM_URL_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return DEFAULT_STREAM_URL_TTL
+}
+
+func streamSignature(kind, id, userID string, exp int64) string {
+	mac := hmac.New(sha256.New, streamSigningKey())
+	mac.Write([]byte(kind + "\n" + id + "\n" + userID + "\n" + strconv.FormatInt(exp, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignStream returns the query parameters (uid, exp, sig) giving access to
+// the streams of a media ("movie" by tmdbID, "episode" by ID) until exp
+func SignStream(kind, id, userID string, exp time.Time) url.Values {
+	return url.Values{
+		"uid": {userID},
+		"exp": {strconv.FormatInt(exp.Unix(), 10)},
+		"sig": {streamSignature(kind, id, userID, exp.Unix())},
+	}
+}
+
+// VerifyStream checks the signed parameters of a stream request and returns
+// the user the URL was issued to
+func VerifyStream(kind, id string, query url.Values) (string, error) {
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return "", ErrStreamSignature
+	}
+	userID := query.Get("uid")
+	want := streamSignature(kind, id, userID, exp)
+	if !hmac.Equal([]byte(want), []byte(query.Get("sig"))) {
+		return "", ErrStreamSignature
+	}
+	if time.Now().Unix() > exp {
+		return "", ErrStreamExpired
+	}
+	return userID, nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyStream(t *testing.T) {
+	const user = "64b7f0c2a1b2c3d4e5f60718"
+	valid := SignStream("movie", "603", user, time.Now().Add(time.Hour))
+	expired := SignStream("movie", "603", user, time.Now().Add(-time.Minute))
+
+	with := func(q url.Values, key, value string) url.Values {
+		out := url.Values{}
+		for k, v := range q {
+			out[k] = append([]string(nil), v...)
+		}
+		out.Set(key, value)
+		return out
+	}
+	without := func(q url.Values, key string) url.Values {
+		out := with(q, key, "")
+		out.Del(key)
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		kind  string
+		id    string
+		query url.Values
+		want  error
+	}{
+		{"valid", "movie", "603", valid, nil},
+		{"expired", "movie", "603", expired, ErrStreamExpired},
+		{"other media", "movie", "604", valid, ErrStreamSignature},
+		{"other kind", "episode", "603", valid, ErrStreamSignature},
+		{"other user", "movie", "603", with(valid, "uid", "64b7f0c2a1b2c3d4e5f60719"), ErrStreamSignature},
+		{"extended expiry", "movie", "603", with(expired, "exp", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)), ErrStreamSignature},
+		{"tampered signature", "movie", "603", with(valid, "sig", valid.Get("sig")[1:]+"A"), ErrStreamSignature},
+		{"missing signature", "movie", "603", without(valid, "sig"), ErrStreamSignature},
+		{"missing expiry", "movie", "603", without(valid, "exp"), ErrStreamSignature},
+		{"invalid expiry", "movie", "603", with(valid, "exp", "soon"), ErrStreamSignature},
+		{"empty query", "movie", "603", url.Values{}, ErrStreamSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, err := VerifyStream(tt.kind, tt.id, tt.query)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyStream() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && uid != user {
+				t.Errorf("VerifyStream() user = %q, want %q", uid, user)
+			}
+		})
+	}
+}
+
+func TestSignStreamParams(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	q := SignStream("episode", "64b7f0c2a1b2c3d4e5f60718", "", exp)
+	if q.Get("exp") != strconv.FormatInt(exp.Unix(), 10) {
+		t.Errorf("exp = %q, want %d", q.Get("exp"), exp.Unix())
+	}
+	if q.Get("sig") == "" {
+		t.Error("missing sig")
+	}
+	if _, ok := q["token"]; ok {
+		t.Error("signed query carries a session token")
+	}
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTmdbDisabled is returned when no TMDB key is configured; callers treat
+// TMDB metadata as optional
+var ErrTmdbDisabled = errors.New("TMDB_API_KEY is not set")
+
+var (
+	tmdbClient = &http.Client{Timeout: 10 * time.Second}
+
+	tmdbCacheMu sync.Mutex
+	tmdbCache   = map[string]tmdbCacheEntry{}
+)
+
+const tmdbCacheTTL = 6 * time.Hour
+
+type tmdbCacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+// TmdbSeason is the subset of GET /tv/{id}/season/{n} used by the API
+type TmdbSeason struct {
+	Name         string `json:"name"`
+	Overview     string `json:"overview"`
+	PosterPath   string `json:"poster_path"`
+	AirDate      string `json:"air_date"`
+	SeasonNumber int    `json:"season_number"`
+	Episodes     []struct {
+		EpisodeNumber int    `json:"episode_number"`
+		Name          string `json:"name"`
+		Overview      string `json:"overview"`
+		AirDate       string `json:"air_date"`
+		Runtime       int    `json:"runtime"`
+	} `json:"episodes"`
+}
+
+// TmdbMovie is the subset of GET /movie/{id}?append_to_response=credits,release_dates used by the API
+type TmdbMovie struct {
+	Title      string `json:"title"`
+	Overview   string `json:"overview"`
+	Runtime    int    `json:"runtime"`
+	PosterPath string `json:"poster_path"`
+	Genres     []struct {
+		Name string `json:"name"`
+	} `json:"genres"`
+	Credits struct {
+		Cast []struct {
+			Name  string `json:"name"`
+			Order int    `json:"order"`
+		} `json:"cast"`
+	} `json:"credits"`
+	ReleaseDates struct {
+		Results []struct {
+			Country      string `json:"iso_3166_1"`
+			ReleaseDates []struct {
+				Certification string `json:"certification"`
+			} `json:"release_dates"`
+		} `json:"results"`
+	} `json:"release_dates"`
+}
+
+// Certifications returns the first certification of each country
+func (m TmdbMovie) Certifications() map[string]string {
+	certs := map[string]string{}
+	for _, r := range m.ReleaseDates.Results {
+		for _, d := range r.ReleaseDates {
+			if d.Certification != "" {
+				certs[r.Country] = d.Certification
+				break
+			}
+		}
+	}
+	return certs
+}
+
+// TmdbShow is the subset of GET /tv/{id} used by the API
+type TmdbShow struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // "Returning Series", "Ended"...
+	PosterPath string `json:"poster_path"`
+	Seasons    []struct {
+		SeasonNumber int    `json:"season_number"`
+		EpisodeCount int    `json:"episode_count"`
+		AirDate      string `json:"air_date"`
+		Name         string `json:"name"`
+	} `json:"seasons"`
+}
+
+// TmdbContentRatings is GET /tv/{id}/content_ratings
+type TmdbContentRatings struct {
+	Results []struct {
+		Country string `json:"iso_3166_1"`
+		Rating  string `json:"rating"`
+	} `json:"results"`
+}
+
+// GetTmdbShowCertifications fetches the certifications of a TV show by country
+func GetTmdbShowCertifications(ctx context.Context, tvID int) (map[string]string, error) {
+	var r TmdbContentRatings
+	if err := TmdbGet(ctx, fmt.Sprintf("/tv/%d/content_ratings", tvID), nil, &r); err != nil {
+		return nil, err
+	}
+	certs := map[string]string{}
+	for _, res := range r.Results {
+		if res.Rating != "" {
+			certs[res.Country] = res.Rating
+		}
+	}
+	return certs, nil
+}
+
+// TmdbGet calls the TMDB API (TMDB_API_URL, default https://api.themoviedb.org/3)
+// and decodes the JSON response into out. Responses are cached in memory.
+// TMDB_API_KEY may be a v3 key or a v4 read access token.
+func TmdbGet(ctx context.Context, path string, query url.Values, out any) error {
+	key := os.Getenv("TMDB_API_KEY")
+	if key == "" {
+		return ErrTmdbDisabled
+	}
+	base := os.Getenv("TMDB_API_URL")
+	if base == "" {
+		base = "https://api.themoviedb.org/3"
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	if query.Get("language") == "" {
+		lang := os.Getenv("TMDB_LANGUAGE")
+		if lang == "" {
+			lang = "fr-FR"
+		}
+		query.Set("language", lang)
+	}
+	bearer := strings.HasPrefix(key, "eyJ") // v4 tokens are JWTs
+	if !bearer {
+		query.Set("api_key", key)
+	}
+	u := strings.TrimSuffix(base, "/") + path + "?" + query.Encode()
+
+	tmdbCacheMu.Lock()
+	entry, ok := tmdbCache[u]
+	tmdbCacheMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return json.Unmarshal(entry.body, out)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := tmdbClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tmdb %s: %s", path, resp.Status)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return err
+	}
+	tmdbCacheMu.Lock()
+	tmdbCache[u] = tmdbCacheEntry{body: raw, expires: time.Now().Add(tmdbCacheTTL)}
+	tmdbCacheMu.Unlock()
+	return json.Unmarshal(raw, out)
+}
+
+// GetTmdbSeason fetches a season of a TV show
+func GetTmdbSeason(ctx context.Context, tvID, season int) (TmdbSeason, error) {
+	var s TmdbSeason
+	err := TmdbGet(ctx, fmt.Sprintf("/tv/%d/season/%d", tvID, season), nil, &s)
+	return s, err
+}
+
+// GetTmdbShow fetches a TV show with its season list
+func GetTmdbShow(ctx context.Context, tvID int) (TmdbShow, error) {
+	var s TmdbShow
+	err := TmdbGet(ctx, fmt.Sprintf("/tv/%d", tvID), nil, &s)
+	return s, err
+}
+
+// GetTmdbMovie fetches a movie with its credits and certifications
+func GetTmdbMovie(ctx context.Context, movieID int) (TmdbMovie, error) {
+	var m TmdbMovie
+	err := TmdbGet(ctx, fmt.Sprintf("/movie/%d", movieID), url.Values{"append_to_response": {"credits,release_dates"}}, &m)
+	return m, err
+}
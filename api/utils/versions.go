@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MediaVersions returns the versions of a movie or episode. Documents created
+// before versions existed only have a filePath: a single version is synthesized
+// for them, with a nil ID until it gets stored.
+func MediaVersions(filePath string, versions []MediaVersion) []MediaVersion {
+	if len(versions) > 0 {
+		return versions
+	}
+	if filePath == "" {
+		return nil
+	}
+	return []MediaVersion{{
+		Label:    "Original",
+		FilePath: filePath,
+		Format:   filepath.Ext(filePath),
+	}}
+}
+
+// MaterializeVersions is MediaVersions with IDs assigned, ready to be stored
+func MaterializeVersions(filePath string, versions []MediaVersion) []MediaVersion {
+	out := append([]MediaVersion(nil), MediaVersions(filePath, versions)...)
+	for i := range out {
+		if out[i].ID.IsZero() {
+			out[i].ID = primitive.NewObjectID()
+		}
+	}
+	return out
+}
+
+// ResolutionHeight converts a resolution label ("2160p", "4K", "1080p"...) to
+// a pixel height, 0 if unknown
+func ResolutionHeight(resolution string) int {
+	r := strings.ToLower(strings.TrimSpace(resolution))
+	switch r {
+	case "":
+		return 0
+	case "4k", "uhd":
+		return 2160
+	case "2k":
+		return 1440
+	case "fhd", "full hd":
+		return 1080
+	case "hd":
+		return 720
+	case "sd":
+		return 480
+	}
+	h, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(r, "i"), "p"))
+	if err != nil {
+		return 0
+	}
+	return h
+}
+
+// SelectVersion picks the version to play. An explicit selector matches a
+// version ID, label, edition or resolution. Otherwise, when the client reports
+// a maximum height, the best version fitting it is used; else the default
+// version (the one at defaultPath).
+func SelectVersion(defaultPath string, versions []MediaVersion, selector string, maxHeight int) (MediaVersion, bool) {
+	all := MediaVersions(defaultPath, versions)
+	if len(all) == 0 {
+		return MediaVersion{}, false
+	}
+
+	if selector != "" && selector != "default" {
+		for _, v := range all {
+			if v.ID.Hex() == selector {
+				return v, true
+			}
+		}
+		for _, v := range all {
+			if strings.EqualFold(v.Label, selector) || strings.EqualFold(v.Edition, selector) || strings.EqualFold(v.Resolution, selector) {
+				return v, true
+			}
+		}
+		return MediaVersion{}, false
+	}
+
+	var def MediaVersion
+	for _, v := range all {
+		if v.FilePath == defaultPath {
+			def = v
+			break
+		}
+	}
+	if def.FilePath == "" {
+		def = all[0]
+	}
+	if h := ResolutionHeight(def.Resolution); maxHeight <= 0 || (h > 0 && h <= maxHeight) {
+		return def, true
+	}
+
+	// Highest resolution fitting the client, in the same edition as the default
+	candidates := make([]MediaVersion, 0, len(all))
+	for _, v := range all {
+		h := ResolutionHeight(v.Resolution)
+		if h > 0 && h <= maxHeight && strings.EqualFold(v.Edition, def.Edition) {
+			candidates = append(candidates, v)
+		}
+	}
+	if len(candidates) == 0 {
+		return def, true
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return ResolutionHeight(candidates[i].Resolution) > ResolutionHeight(candidates[j].Resolution)
+	})
+	return candidates[0], true
+}
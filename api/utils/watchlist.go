@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WatchlistItem is a title a user saved for later ("My List"), in the
+// "watchlist" collection. Titles are kept by TMDB ID so they can be added
+// before they are in the library.
+type WatchlistItem struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user" bson:"user"`
+	Type      string             `json:"type" bson:"type"` // "movie" | "series"
+	TmdbID    int                `json:"tmdbID" bson:"tmdbID"`
+	Title     string             `json:"title" bson:"title"`                       // From TMDB when added, the library title is used once available
+	Poster    string             `json:"poster,omitempty" bson:"poster,omitempty"` // TMDB image path
+	Position  int                `json:"position" bson:"position"`                 // Order in the list, ascending
+	Available bool               `json:"available" bson:"available"`               // In the library
+	Imported  *time.Time         `json:"imported,omitempty" bson:"imported,omitempty"`
+	Added     time.Time          `json:"added" bson:"added"`
+}
+
+// WatchlistImported flags the watchlist entries of a title that just reached
+// the library, ex: after UploadMovie or CreateSeries
+func WatchlistImported(ctx context.Context, typ string, tmdbID int) error {
+	_, err := GetCollection("watchlist").UpdateMany(ctx,
+		bson.M{"type": typ, "tmdbID": tmdbID, "available": false},
+		bson.M{"$set": bson.M{"available": true, "imported": time.Now()}})
+	return err
+}
+
+// WatchlistRemoved marks the entries of a title deleted from the library as
+// unavailable, they stay in the lists
+func WatchlistRemoved(ctx context.Context, typ string, tmdbID int) error {
+	_, err := GetCollection("watchlist").UpdateMany(ctx,
+		bson.M{"type": typ, "tmdbID": tmdbID},
+		bson.M{"$set": bson.M{"available": false}, "$unset": bson.M{"imported": ""}})
+	return err
+}
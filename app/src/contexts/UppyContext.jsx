@@ -2,6 +2,7 @@ import { useEffect, useMemo, useState } from 'react'
 import { UppyContext, useEpisodeTitles, useMainContext } from '../utils/hooks'
 import Uppy from '@uppy/core'
 import XHRUpload from '@uppy/xhr-upload'
+import { getSession } from '../utils/auth'
 
 export const UppyProvider = ({ children, isMovie }) => {
 	const { refetchNewSeries, refetchNewMovies } = useMainContext()
@@ -30,6 +31,10 @@ export const UppyProvider = ({ children, isMovie }) => {
 		// On ajoute le plugin XHRUpload directement ici
 		uppyInstance.use(XHRUpload, {
 			endpoint: import.meta.env.VITE_API + '/' + (isMovie ? 'movies' : 'series'),
+			headers: () => {
+				const token = getSession()?.accessToken
+				return token ? { Authorization: `Bearer ${token}` } : {}
+			},
 			formData: true,
 			bundle: isMovie ? false : true,
 			fieldName: 'file' + (isMovie ? '' : 's[]')
@@ -166,13 +166,15 @@ const OnGoingItem = ({ item, index = 0, onDeleted }) => {
 				</div>
 				<ProgressBar percent={progressPercent} />
 
-				<button
-					onClick={onDelete}
-					className='absolute top-2 right-2 p-1.5 rounded-full bg-black/70 text-white opacity-0 group-hover:opacity-100 transition-opacity hover:bg-red-600 z-10 cursor-pointer'
-					title='Supprimer'
-				>
-					<IoTrash size={16} />
-				</button>
+				{ogId && (
+					<button
+						onClick={onDelete}
+						className='absolute top-2 right-2 p-1.5 rounded-full bg-black/70 text-white opacity-0 group-hover:opacity-100 transition-opacity hover:bg-red-600 z-10 cursor-pointer'
+						title='Supprimer'
+					>
+						<IoTrash size={16} />
+					</button>
+				)}
 			</Link>
 		</Motion.div>
 	)
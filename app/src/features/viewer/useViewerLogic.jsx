@@ -9,6 +9,7 @@ import {
 	useGoBackToNonVideo,
 	useMainContext
 } from '../../utils/hooks'
+import { withToken } from '../../utils/auth'
 
 const SEEK_INTERVAL = 10
 const AUTO_HIDE_DELAY = 3000
@@ -47,6 +48,12 @@ export const useViewerLogic = () => {
 
 	const { data: onGoingMedias } = useAPI('GET', `/ongoing_media/${user.id}`)
 
+	// Version et piste audio choisies par l'API selon les préférences du profil
+	const { data: playback, isPending: playbackPending } = useAPI(
+		'GET',
+		isEpisode ? `/playback/episode/${episodeID}` : `/playback/movie/${tmdbID}`
+	)
+
 	// Chapters / Credits
 	const { data: episodeChapters } = useAPI(
 		'GET',
@@ -175,17 +182,21 @@ export const useViewerLogic = () => {
 	const progressiveSrc = useMemo(
 		() =>
 			isEpisode
-				? `${import.meta.env.VITE_API}/video/episode/${episodeID}`
-				: `${import.meta.env.VITE_API}/video/${tmdbID}`,
+				? withToken(`${import.meta.env.VITE_API}/video/episode/${episodeID}`)
+				: withToken(`${import.meta.env.VITE_API}/video/${tmdbID}`),
 		[isEpisode, episodeID, tmdbID]
 	)
 
 	const hlsSrc = useMemo(
 		() =>
-			isEpisode
-				? `${import.meta.env.VITE_API}/hls/episode/${episodeID}/master.m3u8`
-				: `${import.meta.env.VITE_API}/hls/movie/${tmdbID}/master.m3u8`,
-		[isEpisode, episodeID, tmdbID]
+			withToken(
+				playback?.hls
+					? `${import.meta.env.VITE_API}${playback.hls}`
+					: isEpisode
+						? `${import.meta.env.VITE_API}/hls/episode/${episodeID}/master.m3u8`
+						: `${import.meta.env.VITE_API}/hls/movie/${tmdbID}/master.m3u8`
+			),
+		[playback, isEpisode, episodeID, tmdbID]
 	)
 
 	// --- 5. PROGRESS SAVING ---
@@ -278,7 +289,7 @@ export const useViewerLogic = () => {
 	// --- 7. VIDEO EVENTS & HLS SETUP ---
 	useEffect(() => {
 		const video = videoRef.current
-		if (!video) return
+		if (!video || playbackPending) return
 
 		video.loop = false
 		if (hlsRef.current) hlsRef.current.destroy()
@@ -382,7 +393,7 @@ export const useViewerLogic = () => {
 			video.removeEventListener('ended', onEnded)
 			if (hlsRef.current) hlsRef.current.destroy()
 		}
-	}, [hlsSrc, progressiveSrc, episodeID, tmdbID])
+	}, [hlsSrc, playbackPending, progressiveSrc, episodeID, tmdbID])
 
 	// Restore position from onGoingMedias (separate effect to avoid re-running HLS setup)
 	useEffect(() => {
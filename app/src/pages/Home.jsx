@@ -9,11 +9,47 @@ import { createPortal } from 'react-dom'
 import { IoCloudUploadOutline, IoPersonCircle } from 'react-icons/io5'
 // eslint-disable-next-line
 import { motion } from 'framer-motion'
+import { login } from '../utils/auth'
 
-const Account = ({ user, index }) => {
+const loginError = err => {
+	switch (err?.response?.status) {
+		case 401:
+			return 'Code incorrect'
+		case 403:
+			return 'Un administrateur doit définir un mot de passe ou un PIN pour ce profil'
+		case 429:
+			return "Trop d'essais, réessayez plus tard"
+		default:
+			return 'Erreur de connexion'
+	}
+}
+
+const Account = ({ user, index, authEnabled }) => {
 	const navigate = useNavigate()
 	const { setUser } = useMainContext()
 	const [isHovered, setIsHovered] = useState(false)
+	const [asking, setAsking] = useState(false)
+	const [error, setError] = useState('')
+	const secretRef = useRef(null)
+
+	const open = profile => {
+		setUser(profile)
+		navigate('/explorer')
+	}
+
+	const onPick = () => {
+		setError('')
+		if (!authEnabled) return open(user)
+		if (user.authRequired) return setAsking(true)
+		// Profil sans code : l'API refuse la connexion (403)
+		login(user.id).then(open, err => setError(loginError(err)))
+	}
+
+	const onLogin = e => {
+		e.preventDefault()
+		setError('')
+		login(user.id, secretRef.current.value).then(open, err => setError(loginError(err)))
+	}
 
 	return (
 		<motion.div
@@ -26,10 +62,7 @@ const Account = ({ user, index }) => {
 				whileHover={{ scale: 1.1 }}
 				whileTap={{ scale: 0.95 }}
 				className='bg-gray-700 flex flex-col items-center rounded-xl p-4 cursor-pointer border-2 border-transparent hover:border-nitflex-red transition-all shadow-lg hover:shadow-xl'
-				onClick={() => {
-					navigate('/explorer')
-					setUser(user)
-				}}
+				onClick={onPick}
 				onMouseEnter={() => setIsHovered(true)}
 				onMouseLeave={() => setIsHovered(false)}
 			>
@@ -41,6 +74,30 @@ const Account = ({ user, index }) => {
 				</motion.div>
 			</motion.button>
 			<p className='mt-2 text-xl font-medium text-white'>{user.name || '-'}</p>
+			{error && !asking && (
+				<p className='mt-1 max-w-40 text-center text-sm text-red-500'>{error}</p>
+			)}
+			{asking && (
+				<PopUp close={() => setAsking(false)}>
+					<form onSubmit={onLogin} className='p-8 max-w-md w-full flex flex-col gap-4'>
+						<h2 className='text-2xl font-bold text-center text-white'>{user.name}</h2>
+						<input
+							type='password'
+							ref={secretRef}
+							placeholder='Mot de passe ou PIN'
+							className='w-full px-4 py-3 bg-gray-700 border border-gray-600 rounded-lg text-white placeholder-gray-400 focus:outline-none focus:ring-2 focus:ring-nitflex-red focus:border-transparent'
+							autoFocus
+						/>
+						{error && <p className='text-red-500 text-sm'>{error}</p>}
+						<button
+							type='submit'
+							className='bg-nitflex-red hover:bg-red-700 p-3 px-8 text-lg rounded-lg text-white font-medium transition-colors'
+						>
+							Se connecter
+						</button>
+					</form>
+				</PopUp>
+			)}
 		</motion.div>
 	)
 }
@@ -49,8 +106,10 @@ const Accounts = () => {
 	const [clicked, setClicked] = useState(false)
 	const [isCreating, setIsCreating] = useState(false)
 	const { refetch, data, isPending } = useAPI('GET', '/users')
+	const { data: auth, refetch: refetchAuth } = useAPI('GET', '/auth/status')
 	const { triggerAsync } = useAPIAfter('POST', '/users')
 	const usernameRef = useRef(null)
+	const secretRef = useRef(null)
 	const [error, setError] = useState('')
 
 	const handleSubmit = e => {
@@ -62,11 +121,26 @@ const Accounts = () => {
 			return
 		}
 
+		// Le premier profil protégé devient administrateur
+		const secret = secretRef.current?.value || ''
+		if (auth?.setupRequired && !secret) {
+			setError("Le premier profil a besoin d'un mot de passe ou d'un PIN")
+			return
+		}
+
 		setIsCreating(true)
-		triggerAsync({ name: usernameRef.current.value.trim() })
-			.then(() => {
+		const body = { name: usernameRef.current.value.trim() }
+		if (secret) {
+			body.auth = /^\d{4,8}$/.test(secret) ? 'pin' : 'password'
+			body.secret = secret
+		}
+		triggerAsync(body)
+			.then(created => {
+				if (!created) throw new Error('create user')
 				refetch()
+				refetchAuth()
 				usernameRef.current.value = ''
+				if (secretRef.current) secretRef.current.value = ''
 				setClicked(false)
 				setIsCreating(false)
 			})
@@ -83,7 +157,10 @@ const Accounts = () => {
 			transition={{ duration: 0.5, delay: 0.3 }}
 			className='flex gap-6 mt-8 flex-wrap justify-center items-start max-w-4xl'
 		>
-			{data && data.map((user, idx) => <Account key={user.id} user={user} index={idx} />)}
+			{data &&
+				data.map((user, idx) => (
+					<Account key={user.id} user={user} index={idx} authEnabled={auth?.enabled} />
+				))}
 			{isPending ? (
 				<Loader />
 			) : (
@@ -120,6 +197,20 @@ const Accounts = () => {
 									autoFocus
 								/>
 							</div>
+							{auth?.enabled && (
+								<div>
+									<label className='block text-white mb-2 font-medium'>
+										Mot de passe ou PIN
+										{!auth.setupRequired && ' (facultatif)'}
+									</label>
+									<input
+										type='password'
+										ref={secretRef}
+										placeholder='4 à 8 chiffres ou 6 caractères minimum'
+										className='w-full px-4 py-3 bg-gray-700 border border-gray-600 rounded-lg text-white placeholder-gray-400 focus:outline-none focus:ring-2 focus:ring-nitflex-red focus:border-transparent'
+									/>
+								</div>
+							)}
 							{error && (
 								<motion.p
 									initial={{ opacity: 0, y: -10 }}
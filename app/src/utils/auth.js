@@ -0,0 +1,55 @@
+import axios from 'axios'
+import { interactStorage } from './utils'
+
+// Session de l'API (POST /auth/login), gardée avec le profil choisi
+export const getSession = () => interactStorage('session')
+export const setSession = session => interactStorage('session', session || {})
+
+export const login = async (userId, password = '') => {
+	const res = await axios.post(import.meta.env.VITE_API + '/auth/login', { userId, password })
+	setSession({ accessToken: res.data.accessToken, refreshToken: res.data.refreshToken })
+	return res.data.user
+}
+
+// Ajoute le jeton aux URLs lues directement par le navigateur (<video>, HLS)
+export const withToken = url => {
+	const token = getSession()?.accessToken
+	if (!token) return url
+	return url + (url.includes('?') ? '&' : '?') + 'token=' + encodeURIComponent(token)
+}
+
+axios.interceptors.request.use(config => {
+	const token = getSession()?.accessToken
+	if (token && config.url?.startsWith(import.meta.env.VITE_API)) {
+		config.headers.Authorization = `Bearer ${token}`
+	}
+	return config
+})
+
+// Jeton expiré : un seul refresh à la fois, puis la requête est rejouée
+let refreshing = null
+axios.interceptors.response.use(null, async error => {
+	const { config, response } = error
+	const refreshToken = getSession()?.refreshToken
+	if (
+		response?.status !== 401 ||
+		!refreshToken ||
+		config._retried ||
+		config.url?.endsWith('/auth/refresh')
+	) {
+		return Promise.reject(error)
+	}
+	refreshing =
+		refreshing ||
+		axios
+			.post(import.meta.env.VITE_API + '/auth/refresh', { refreshToken })
+			.then(res => setSession(res.data))
+			.catch(err => {
+				setSession(null)
+				throw err
+			})
+			.finally(() => (refreshing = null))
+	await refreshing
+	config._retried = true
+	return axios(config)
+})
@@ -2,6 +2,7 @@ import { createContext, useCallback, useContext, useEffect, useState } from 'rea
 import { useMutation, useQuery } from '@tanstack/react-query'
 import axios from 'axios'
 import { useNavigate } from 'react-router-dom'
+import './auth' // Jeton de session sur les appels à l'API
 
 export const MainContext = createContext()
 export const useMainContext = () => useContext(MainContext)